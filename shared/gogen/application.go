@@ -1,7 +1,7 @@
 package gogen
 
 import (
-	"demogogen1/shared/util"
+	"infrastructure/shared/util"
 	"time"
 )
 
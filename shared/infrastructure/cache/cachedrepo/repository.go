@@ -0,0 +1,326 @@
+// Package cachedrepo wrap the database.Repository with the read-through cache.
+// It is kept out of the cache package so the cache does not depend on the database
+package cachedrepo
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"infrastructure/shared/infrastructure/cache"
+	"infrastructure/shared/infrastructure/database"
+	"infrastructure/shared/model/service"
+)
+
+// Option start from NewDefaultOption then override the value with the setter
+type Option struct {
+
+	// Prefix is put in front of every key, the type name of T is added after it
+	Prefix string
+
+	TTL time.Duration
+
+	// CacheGetAll cache the GetAll result by the normalized param. Any write invalidate all of them
+	CacheGetAll bool
+
+	Codec cache.Codec
+}
+
+func NewDefaultOption() Option {
+	return Option{
+		Prefix:      "repo",
+		TTL:         10 * time.Minute,
+		CacheGetAll: false,
+		Codec:       cache.JSONCodec{},
+	}
+}
+
+func (c Option) SetPrefix(prefix string) Option {
+	c.Prefix = prefix
+	return c
+}
+
+func (c Option) SetTTL(ttl time.Duration) Option {
+	c.TTL = ttl
+	return c
+}
+
+func (c Option) SetCacheGetAll(cacheGetAll bool) Option {
+	c.CacheGetAll = cacheGetAll
+	return c
+}
+
+func (c Option) SetCodec(codec cache.Codec) Option {
+	c.Codec = codec
+	return c
+}
+
+// Repository wrap the database.Repository with the read-through cache.
+// GetOne is cached when the filter only has the "_id" or "id" and the write invalidate the cached value.
+// The failure of the cache never fail the call, the TTL limit how long the value can be stale
+//
+//	repo := cachedrepo.NewRepository[Order](database.NewMongoGateway[Order](db), redisCache)
+//
+//	_, err := service.WithTransaction(ctx, trx, func(dbCtx context.Context) (*Order, error) {
+//		// the invalidation is deferred until the transaction is committed
+//		return order, repo.WithContext(dbCtx).InsertOrUpdate(order)
+//	})
+type Repository[T any] struct {
+	repo   database.Repository[T]
+	cache  cache.Cache
+	option Option
+	ctx    context.Context
+}
+
+func NewRepository[T any](repo database.Repository[T], c cache.Cache, option ...Option) *Repository[T] {
+
+	opt := NewDefaultOption()
+	if len(option) > 0 {
+		opt = option[0]
+	}
+
+	return &Repository[T]{
+		repo:   repo,
+		cache:  c,
+		option: opt,
+		ctx:    context.Background(),
+	}
+}
+
+// WithContext return the repository which use the ctx for the cache.
+// Inside WithTransaction the read bypass the cache and the invalidation wait for the commit
+func (r *Repository[T]) WithContext(ctx context.Context) *Repository[T] {
+	x := *r
+	x.ctx = ctx
+	return &x
+}
+
+func (r *Repository[T]) GetTypeName() string {
+	return r.repo.GetTypeName()
+}
+
+func (r *Repository[T]) InsertOrUpdate(obj *T) error {
+
+	err := r.repo.InsertOrUpdate(obj)
+	if err != nil {
+		return err
+	}
+
+	r.invalidate(idOf(obj))
+
+	return nil
+}
+
+func (r *Repository[T]) InsertMany(objs ...*T) error {
+
+	err := r.repo.InsertMany(objs...)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		ids = append(ids, idOf(obj))
+	}
+
+	r.invalidate(ids...)
+
+	return nil
+}
+
+func (r *Repository[T]) GetOne(filter map[string]any, result *T) error {
+
+	id, ok := idFromFilter(filter)
+	if !ok || service.InTransaction(r.ctx) {
+		return r.repo.GetOne(filter, result)
+	}
+
+	key := r.idKey(id)
+
+	bytes, err := r.cache.Get(r.ctx, key)
+	if err == nil && r.option.Codec.Unmarshal(bytes, result) == nil {
+		return nil
+	}
+
+	err = r.repo.GetOne(filter, result)
+	if err != nil {
+		return err
+	}
+
+	r.store(key, result)
+
+	return nil
+}
+
+type cachedGetAll[T any] struct {
+	Count int64 `json:"count"`
+	Items []*T  `json:"items"`
+}
+
+func (r *Repository[T]) GetAll(param database.GetAllParam, results *[]*T) (int64, error) {
+
+	if !r.option.CacheGetAll || service.InTransaction(r.ctx) {
+		return r.repo.GetAll(param, results)
+	}
+
+	key, ok := r.allKey(param)
+	if !ok {
+		return r.repo.GetAll(param, results)
+	}
+
+	var cached cachedGetAll[T]
+	bytes, err := r.cache.Get(r.ctx, key)
+	if err == nil && r.option.Codec.Unmarshal(bytes, &cached) == nil {
+		*results = cached.Items
+		return cached.Count, nil
+	}
+
+	count, err := r.repo.GetAll(param, results)
+	if err != nil {
+		return 0, err
+	}
+
+	r.store(key, cachedGetAll[T]{Count: count, Items: *results})
+
+	return count, nil
+}
+
+// GetAllEachItem is not cached because the result is streamed
+func (r *Repository[T]) GetAllEachItem(param database.GetAllParam, resultEachItem func(result T)) (int64, error) {
+	return r.repo.GetAllEachItem(param, resultEachItem)
+}
+
+func (r *Repository[T]) Delete(filter map[string]any) error {
+
+	err := r.repo.Delete(filter)
+	if err != nil {
+		return err
+	}
+
+	if id, ok := idFromFilter(filter); ok {
+		r.invalidate(id)
+		return nil
+	}
+
+	// the deleted id is unknown so all the cached id of this type is removed
+	ctx := r.ctx
+	service.AfterCommit(ctx, func() {
+		_, _ = r.cache.DelPattern(ctx, r.key("id", "*"))
+		r.bumpGeneration(ctx)
+	})
+
+	return nil
+}
+
+func (r *Repository[T]) invalidate(ids ...string) {
+
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id != "" {
+			keys = append(keys, r.idKey(id))
+		}
+	}
+
+	ctx := r.ctx
+	service.AfterCommit(ctx, func() {
+		if len(keys) > 0 {
+			_ = r.cache.Del(ctx, keys...)
+		}
+		r.bumpGeneration(ctx)
+	})
+}
+
+func (r *Repository[T]) store(key string, value any) {
+	bytes, err := r.option.Codec.Marshal(value)
+	if err != nil {
+		return
+	}
+	_ = r.cache.Set(r.ctx, key, bytes, r.option.TTL)
+}
+
+// bumpGeneration make all the cached GetAll unreachable, the old one is removed by the TTL
+func (r *Repository[T]) bumpGeneration(ctx context.Context) {
+	if r.option.CacheGetAll {
+		_, _ = r.cache.Incr(ctx, r.key("generation"), 1)
+	}
+}
+
+func (r *Repository[T]) key(parts ...string) string {
+	key := r.option.Prefix + ":" + r.repo.GetTypeName()
+	for _, part := range parts {
+		key += ":" + part
+	}
+	return key
+}
+
+func (r *Repository[T]) idKey(id string) string {
+	return r.key("id", id)
+}
+
+// allKey use the json of the param as the normalized query because the json sort the map key
+func (r *Repository[T]) allKey(param database.GetAllParam) (string, bool) {
+
+	bytes, err := json.Marshal(param)
+	if err != nil {
+		return "", false
+	}
+
+	generation := int64(0)
+	if value, err := r.cache.Get(r.ctx, r.key("generation")); err == nil {
+		generation, _ = strconv.ParseInt(string(value), 10, 64)
+	}
+
+	hash := sha1.Sum(bytes)
+
+	return r.key("all", strconv.FormatInt(generation, 10), hex.EncodeToString(hash[:])), true
+}
+
+func idFromFilter(filter map[string]any) (string, bool) {
+	if len(filter) != 1 {
+		return "", false
+	}
+
+	for _, name := range []string{"_id", "id"} {
+		value, exist := filter[name]
+		if !exist {
+			continue
+		}
+
+		// the operator like {"$in": [...]} is not a single id
+		switch reflect.Indirect(reflect.ValueOf(value)).Kind() {
+		case reflect.Map, reflect.Slice, reflect.Invalid:
+			return "", false
+		}
+
+		return idString(value), true
+	}
+
+	return "", false
+}
+
+func idOf(obj any) string {
+	value := reflect.Indirect(reflect.ValueOf(obj))
+	if value.Kind() != reflect.Struct {
+		return ""
+	}
+
+	field := value.FieldByName("ID")
+	if !field.IsValid() {
+		return ""
+	}
+
+	return idString(field.Interface())
+}
+
+// idString use the Hex of the mongo ObjectID so the id from the filter and from the object is the same
+func idString(value any) string {
+	if hexer, ok := value.(interface{ Hex() string }); ok {
+		return hexer.Hex()
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,229 @@
+package cachedrepo_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"infrastructure/shared/infrastructure/cache"
+	"infrastructure/shared/infrastructure/cache/cachedrepo"
+	"infrastructure/shared/infrastructure/database"
+	"infrastructure/shared/model/service"
+)
+
+type Order struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// fakeRepository keep the order in the map and count the read which reach it
+type fakeRepository struct {
+	mutex  sync.Mutex
+	orders map[string]Order
+	reads  int
+}
+
+func newFakeRepository(orders ...Order) *fakeRepository {
+	r := &fakeRepository{orders: map[string]Order{}}
+	for _, order := range orders {
+		r.orders[order.ID] = order
+	}
+	return r
+}
+
+func (r *fakeRepository) InsertOrUpdate(obj *Order) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.orders[obj.ID] = *obj
+	return nil
+}
+
+func (r *fakeRepository) InsertMany(objs ...*Order) error {
+	for _, obj := range objs {
+		_ = r.InsertOrUpdate(obj)
+	}
+	return nil
+}
+
+func (r *fakeRepository) GetOne(filter map[string]any, result *Order) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.reads++
+	order, exist := r.orders[fmt.Sprint(filter["id"])]
+	if !exist {
+		return errors.New("not found")
+	}
+	*result = order
+	return nil
+}
+
+func (r *fakeRepository) GetAll(param database.GetAllParam, results *[]*Order) (int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.reads++
+	for _, order := range r.orders {
+		order := order
+		*results = append(*results, &order)
+	}
+	return int64(len(r.orders)), nil
+}
+
+func (r *fakeRepository) GetAllEachItem(param database.GetAllParam, resultEachItem func(result Order)) (int64, error) {
+	return 0, nil
+}
+
+func (r *fakeRepository) Delete(filter map[string]any) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.orders, fmt.Sprint(filter["id"]))
+	return nil
+}
+
+func (r *fakeRepository) GetTypeName() string {
+	return "order"
+}
+
+func (r *fakeRepository) readCount() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.reads
+}
+
+// fakeTransaction only mark the context, the fakeRepository write immediately
+type fakeTransaction struct{}
+
+func (fakeTransaction) BeginTransaction(ctx context.Context) (context.Context, error) {
+	return ctx, nil
+}
+
+func (fakeTransaction) CommitTransaction(ctx context.Context) error {
+	return nil
+}
+
+func (fakeTransaction) RollbackTransaction(ctx context.Context) error {
+	return nil
+}
+
+func newRepository(t *testing.T, option cachedrepo.Option, orders ...Order) (*cachedrepo.Repository[Order], *fakeRepository, *cache.MemoryCache) {
+	origin := newFakeRepository(orders...)
+	memory := cache.NewMemoryCache()
+	t.Cleanup(memory.Close)
+	return cachedrepo.NewRepository[Order](origin, memory, option), origin, memory
+}
+
+func getStatus(t *testing.T, repo *cachedrepo.Repository[Order], id string) string {
+	var order Order
+	if err := repo.GetOne(map[string]any{"id": id}, &order); err != nil {
+		t.Fatal(err)
+	}
+	return order.Status
+}
+
+func TestReadThrough(t *testing.T) {
+
+	repo, origin, _ := newRepository(t, cachedrepo.NewDefaultOption(), Order{ID: "O1", Status: "new"})
+
+	for i := 0; i < 3; i++ {
+		if status := getStatus(t, repo, "O1"); status != "new" {
+			t.Fatalf("status is %s", status)
+		}
+	}
+
+	if origin.readCount() != 1 {
+		t.Fatalf("origin is read %d times, want 1", origin.readCount())
+	}
+
+	// the filter other than the single id is not cached
+	var order Order
+	_ = repo.GetOne(map[string]any{"id": "O1", "status": "new"}, &order)
+	if origin.readCount() != 2 {
+		t.Fatalf("origin is read %d times, want 2", origin.readCount())
+	}
+}
+
+func TestInvalidateAfterCommit(t *testing.T) {
+
+	repo, origin, memory := newRepository(t, cachedrepo.NewDefaultOption(), Order{ID: "O1", Status: "new"})
+
+	getStatus(t, repo, "O1")
+
+	_, err := service.WithTransaction(context.Background(), fakeTransaction{}, func(dbCtx context.Context) (*Order, error) {
+
+		order := &Order{ID: "O1", Status: "paid"}
+		if err := repo.WithContext(dbCtx).InsertOrUpdate(order); err != nil {
+			return nil, err
+		}
+
+		// the other reader still see the committed value until the transaction is done
+		exist, _ := memory.Exist(context.Background(), "repo:order:id:O1")
+		if !exist {
+			t.Error("cached value is removed before the commit")
+		}
+
+		return order, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := getStatus(t, repo, "O1"); status != "paid" {
+		t.Fatalf("status after commit is %s", status)
+	}
+
+	if origin.readCount() != 2 {
+		t.Fatalf("origin is read %d times, want 2", origin.readCount())
+	}
+}
+
+func TestRollbackKeepTheCache(t *testing.T) {
+
+	repo, _, memory := newRepository(t, cachedrepo.NewDefaultOption(), Order{ID: "O1", Status: "new"})
+
+	getStatus(t, repo, "O1")
+
+	_, err := service.WithTransaction(context.Background(), fakeTransaction{}, func(dbCtx context.Context) (*Order, error) {
+		if err := repo.WithContext(dbCtx).InsertOrUpdate(&Order{ID: "O1", Status: "paid"}); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("payment is rejected")
+	})
+	if err == nil {
+		t.Fatal("transaction must fail")
+	}
+
+	exist, _ := memory.Exist(context.Background(), "repo:order:id:O1")
+	if !exist {
+		t.Fatal("cached value is removed by the rolled back transaction")
+	}
+}
+
+func TestGetAllInvalidatedByWrite(t *testing.T) {
+
+	repo, origin, _ := newRepository(t, cachedrepo.NewDefaultOption().SetCacheGetAll(true), Order{ID: "O1", Status: "new"})
+
+	param := database.GetAllParam{Page: 1, Size: 10}
+
+	for i := 0; i < 2; i++ {
+		var results []*Order
+		count, err := repo.GetAll(param, &results)
+		if err != nil || count != 1 || len(results) != 1 {
+			t.Fatalf("get all return %d %d %v", count, len(results), err)
+		}
+	}
+
+	if origin.readCount() != 1 {
+		t.Fatalf("origin is read %d times, want 1", origin.readCount())
+	}
+
+	if err := repo.InsertOrUpdate(&Order{ID: "O2", Status: "new"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var results []*Order
+	count, err := repo.GetAll(param, &results)
+	if err != nil || count != 2 {
+		t.Fatalf("get all after write return %d %v", count, err)
+	}
+}
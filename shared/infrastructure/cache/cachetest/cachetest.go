@@ -0,0 +1,412 @@
+// Package cachetest check that a cache.Cache implementation behave as the contract describe,
+// so the implementation can be swapped without changing the caller. Use it from a test like
+//
+//	if err := cachetest.TestCache(ctx, cache.NewMemoryCache()); err != nil {
+//		t.Fatal(err)
+//	}
+package cachetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"infrastructure/shared/infrastructure/cache"
+	"infrastructure/shared/util"
+)
+
+type checkFunc func(ctx context.Context, c cache.Cache, prefix string) error
+
+// TestCache run all the check against the cache and return every failure as one error.
+// All the key used are prefixed with a random value and removed at the end
+func TestCache(ctx context.Context, c cache.Cache) error {
+
+	checks := []struct {
+		name  string
+		check checkFunc
+	}{
+		{"SetGet", checkSetGet},
+		{"Miss", checkMiss},
+		{"Del", checkDel},
+		{"Exist", checkExist},
+		{"Reset", checkReset},
+		{"MGetMSet", checkMGetMSet},
+		{"SetNX", checkSetNX},
+		{"IncrDecr", checkIncrDecr},
+		{"TTLExpire", checkTTLExpire},
+		{"Expiration", checkExpiration},
+		{"GetOrSet", checkGetOrSet},
+		{"DelPattern", checkDelPattern},
+	}
+
+	prefix := fmt.Sprintf("cachetest:%s:", util.GenerateID(8))
+
+	var failures []string
+	for _, x := range checks {
+		if err := x.check(ctx, c, prefix+x.name+":"); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", x.name, err))
+		}
+	}
+
+	if _, err := c.DelPattern(ctx, prefix+"*"); err != nil {
+		failures = append(failures, fmt.Sprintf("cleanup: %v", err))
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("cache conformance failed:\n%s", strings.Join(failures, "\n"))
+	}
+
+	return nil
+}
+
+func expectValue(ctx context.Context, c cache.Cache, key string, want []byte) error {
+	got, err := c.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("get %s: %v", key, err)
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("get %s: got %q want %q", key, got, want)
+	}
+	return nil
+}
+
+func expectMiss(ctx context.Context, c cache.Cache, key string) error {
+	_, err := c.Get(ctx, key)
+	if !errors.Is(err, cache.ErrCacheMiss) {
+		return fmt.Errorf("get %s: got error %v want ErrCacheMiss", key, err)
+	}
+	return nil
+}
+
+func checkSetGet(ctx context.Context, c cache.Cache, prefix string) error {
+	key := prefix + "a"
+
+	if err := c.Set(ctx, key, []byte("1"), time.Minute); err != nil {
+		return err
+	}
+	if err := expectValue(ctx, c, key, []byte("1")); err != nil {
+		return err
+	}
+
+	// the key which had the expiration must not keep it
+	if err := c.Set(ctx, key, []byte("2"), cache.NoExpiration); err != nil {
+		return err
+	}
+	if err := expectValue(ctx, c, key, []byte("2")); err != nil {
+		return err
+	}
+
+	ttl, err := c.TTL(ctx, key)
+	if err != nil {
+		return err
+	}
+	if ttl != cache.NoExpiration {
+		return fmt.Errorf("ttl %s after set with NoExpiration: got %s want NoExpiration", key, ttl)
+	}
+
+	return nil
+}
+
+func checkMiss(ctx context.Context, c cache.Cache, prefix string) error {
+	return expectMiss(ctx, c, prefix+"missing")
+}
+
+func checkDel(ctx context.Context, c cache.Cache, prefix string) error {
+	a, b := prefix+"a", prefix+"b"
+
+	if err := c.MSet(ctx, map[string][]byte{a: []byte("1"), b: []byte("2")}, time.Minute); err != nil {
+		return err
+	}
+	if err := c.Del(ctx, a, b, prefix+"missing"); err != nil {
+		return err
+	}
+	if err := expectMiss(ctx, c, a); err != nil {
+		return err
+	}
+	return expectMiss(ctx, c, b)
+}
+
+func checkExist(ctx context.Context, c cache.Cache, prefix string) error {
+	a, b := prefix+"a", prefix+"b"
+
+	if err := c.Set(ctx, a, []byte("1"), time.Minute); err != nil {
+		return err
+	}
+
+	exist, err := c.Exist(ctx, a)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return fmt.Errorf("exist %s: got false want true", a)
+	}
+
+	exist, err = c.Exist(ctx, a, b)
+	if err != nil {
+		return err
+	}
+	if exist {
+		return fmt.Errorf("exist %s %s: got true want false", a, b)
+	}
+
+	return nil
+}
+
+func checkReset(ctx context.Context, c cache.Cache, prefix string) error {
+	key := prefix + "a"
+
+	if err := c.Set(ctx, key, []byte("1"), time.Minute); err != nil {
+		return err
+	}
+	if err := c.Reset(ctx, key, []byte("2")); err != nil {
+		return err
+	}
+	if err := expectValue(ctx, c, key, []byte("2")); err != nil {
+		return err
+	}
+
+	ttl, err := c.TTL(ctx, key)
+	if err != nil {
+		return err
+	}
+	if ttl <= 0 || ttl > time.Minute {
+		return fmt.Errorf("reset must keep the expiration, got ttl %v", ttl)
+	}
+
+	return nil
+}
+
+func checkMGetMSet(ctx context.Context, c cache.Cache, prefix string) error {
+	a, b, missing := prefix+"a", prefix+"b", prefix+"missing"
+
+	if err := c.MSet(ctx, map[string][]byte{a: []byte("1"), b: []byte("2")}, time.Minute); err != nil {
+		return err
+	}
+
+	values, err := c.MGet(ctx, a, missing, b)
+	if err != nil {
+		return err
+	}
+	if len(values) != 3 {
+		return fmt.Errorf("mget: got %d values want 3", len(values))
+	}
+	if !bytes.Equal(values[0], []byte("1")) || values[1] != nil || !bytes.Equal(values[2], []byte("2")) {
+		return fmt.Errorf("mget: got %q want [1 <nil> 2]", values)
+	}
+
+	return nil
+}
+
+func checkSetNX(ctx context.Context, c cache.Cache, prefix string) error {
+	key := prefix + "a"
+
+	ok, err := c.SetNX(ctx, key, []byte("1"), time.Minute)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("first setnx: got false want true")
+	}
+
+	ok, err = c.SetNX(ctx, key, []byte("2"), time.Minute)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return fmt.Errorf("second setnx: got true want false")
+	}
+
+	return expectValue(ctx, c, key, []byte("1"))
+}
+
+func checkIncrDecr(ctx context.Context, c cache.Cache, prefix string) error {
+	key := prefix + "a"
+
+	n, err := c.Incr(ctx, key, 5)
+	if err != nil {
+		return err
+	}
+	if n != 5 {
+		return fmt.Errorf("incr missing key: got %d want 5", n)
+	}
+
+	n, err = c.Decr(ctx, key, 2)
+	if err != nil {
+		return err
+	}
+	if n != 3 {
+		return fmt.Errorf("decr: got %d want 3", n)
+	}
+
+	if err := expectValue(ctx, c, key, []byte("3")); err != nil {
+		return err
+	}
+
+	text := prefix + "text"
+	if err := c.Set(ctx, text, []byte("abc"), time.Minute); err != nil {
+		return err
+	}
+	if _, err := c.Incr(ctx, text, 1); err == nil {
+		return fmt.Errorf("incr non integer: got no error")
+	}
+
+	return nil
+}
+
+func checkTTLExpire(ctx context.Context, c cache.Cache, prefix string) error {
+	key, persistent := prefix+"a", prefix+"persistent"
+
+	if _, err := c.TTL(ctx, prefix+"missing"); !errors.Is(err, cache.ErrCacheMiss) {
+		return fmt.Errorf("ttl missing key: got error %v want ErrCacheMiss", err)
+	}
+
+	if err := c.Set(ctx, persistent, []byte("1"), cache.NoExpiration); err != nil {
+		return err
+	}
+	ttl, err := c.TTL(ctx, persistent)
+	if err != nil {
+		return err
+	}
+	if ttl != cache.NoExpiration {
+		return fmt.Errorf("ttl without expiration: got %v want NoExpiration", ttl)
+	}
+
+	if err := c.Set(ctx, key, []byte("1"), time.Minute); err != nil {
+		return err
+	}
+	ok, err := c.Expire(ctx, key, time.Hour)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("expire existing key: got false want true")
+	}
+	ttl, err = c.TTL(ctx, key)
+	if err != nil {
+		return err
+	}
+	if ttl <= time.Minute || ttl > time.Hour {
+		return fmt.Errorf("ttl after expire: got %v want around 1h", ttl)
+	}
+
+	// NoExpiration remove the expiration instead of deleting the key, also for the key without the expiration
+	for _, k := range []string{key, persistent} {
+		ok, err = c.Expire(ctx, k, cache.NoExpiration)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("expire %s with NoExpiration: got false want true", k)
+		}
+		ttl, err = c.TTL(ctx, k)
+		if err != nil {
+			return fmt.Errorf("ttl after expire %s with NoExpiration: %w", k, err)
+		}
+		if ttl != cache.NoExpiration {
+			return fmt.Errorf("ttl after expire %s with NoExpiration: got %v want NoExpiration", k, ttl)
+		}
+	}
+
+	ok, err = c.Expire(ctx, prefix+"missing", cache.NoExpiration)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return fmt.Errorf("expire missing key with NoExpiration: got true want false")
+	}
+
+	ok, err = c.Expire(ctx, prefix+"missing", time.Hour)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return fmt.Errorf("expire missing key: got true want false")
+	}
+
+	return nil
+}
+
+func checkExpiration(ctx context.Context, c cache.Cache, prefix string) error {
+	key := prefix + "a"
+
+	if err := c.Set(ctx, key, []byte("1"), 50*time.Millisecond); err != nil {
+		return err
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if err := expectMiss(ctx, c, key); err != nil {
+		return fmt.Errorf("after expiration: %v", err)
+	}
+
+	exist, err := c.Exist(ctx, key)
+	if err != nil {
+		return err
+	}
+	if exist {
+		return fmt.Errorf("exist after expiration: got true want false")
+	}
+
+	return nil
+}
+
+func checkGetOrSet(ctx context.Context, c cache.Cache, prefix string) error {
+	key := prefix + "a"
+
+	calls := 0
+	loader := func(ctx context.Context) ([]byte, error) {
+		calls++
+		return []byte("loaded"), nil
+	}
+
+	for i := 0; i < 2; i++ {
+		value, err := c.GetOrSet(ctx, key, time.Minute, loader)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(value, []byte("loaded")) {
+			return fmt.Errorf("getorset: got %q want %q", value, "loaded")
+		}
+	}
+	if calls != 1 {
+		return fmt.Errorf("getorset: loader called %d times want 1", calls)
+	}
+
+	failing := prefix + "failing"
+	loadErr := errors.New("load failed")
+	_, err := c.GetOrSet(ctx, failing, time.Minute, func(ctx context.Context) ([]byte, error) {
+		return nil, loadErr
+	})
+	if !errors.Is(err, loadErr) {
+		return fmt.Errorf("getorset loader error: got %v want %v", err, loadErr)
+	}
+
+	return expectMiss(ctx, c, failing)
+}
+
+func checkDelPattern(ctx context.Context, c cache.Cache, prefix string) error {
+	values := map[string][]byte{
+		prefix + "user:1":  []byte("1"),
+		prefix + "user:2":  []byte("2"),
+		prefix + "order:1": []byte("3"),
+	}
+	if err := c.MSet(ctx, values, time.Minute); err != nil {
+		return err
+	}
+
+	deleted, err := c.DelPattern(ctx, prefix+"user:*")
+	if err != nil {
+		return err
+	}
+	if deleted != 2 {
+		return fmt.Errorf("delpattern: got %d deleted want 2", deleted)
+	}
+
+	if err := expectMiss(ctx, c, prefix+"user:1"); err != nil {
+		return err
+	}
+	return expectValue(ctx, c, prefix+"order:1", []byte("3"))
+}
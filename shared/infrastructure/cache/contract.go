@@ -5,18 +5,57 @@ import (
 	"time"
 )
 
-// Cache We only use 3 base function in redis
+// NoExpiration is returned by TTL for the key which has no expiration
+const NoExpiration = time.Duration(-1)
+
+// LoaderFunc load the value from the origin when the key is not in the cache
+type LoaderFunc func(ctx context.Context) ([]byte, error)
+
+// Cache is the common contract of the cache implementation.
+// Use Typed to work with the go type instead of the bytes.
+// The returned error can be checked with ErrCacheMiss, ErrCacheTimeout and ErrCacheConnection
 type Cache interface {
 
 	// Set put the initial value
 	Set(ctx context.Context, key string, value []byte, exp time.Duration) error
 
-	// Get the value
-	Get(ctx context.Context, key string) (string, error)
+	// Get the value, return ErrCacheMiss if the key is not exist
+	Get(ctx context.Context, key string) ([]byte, error)
 
 	// Del Delete the value
-	Del(ctx context.Context, key string) error
+	Del(ctx context.Context, keys ...string) error
+
+	// Exist Check all the keys are exist
+	Exist(ctx context.Context, keys ...string) (bool, error)
+
+	// Reset replace the value and keep the existing expiration
+	Reset(ctx context.Context, key string, value []byte) error
+
+	// MGet return the values in the same order as the keys, the value of the missing key is nil
+	MGet(ctx context.Context, keys ...string) ([][]byte, error)
+
+	// MSet put many values with the same expiration
+	MSet(ctx context.Context, values map[string][]byte, exp time.Duration) error
+
+	// SetNX put the value only if the key is not exist, return true if the value is put
+	SetNX(ctx context.Context, key string, value []byte, exp time.Duration) (bool, error)
+
+	// Incr add the delta to the integer value and return the new value. The missing key start from 0
+	Incr(ctx context.Context, key string, delta int64) (int64, error)
+
+	// Decr subtract the delta from the integer value and return the new value. The missing key start from 0
+	Decr(ctx context.Context, key string, delta int64) (int64, error)
+
+	// TTL return the remaining time to live or NoExpiration, return ErrCacheMiss if the key is not exist
+	TTL(ctx context.Context, key string) (time.Duration, error)
+
+	// Expire change the expiration of the existing key, return false if the key is not exist.
+	// The non positive exp like NoExpiration remove the expiration
+	Expire(ctx context.Context, key string, exp time.Duration) (bool, error)
+
+	// GetOrSet return the cached value or load and put it if the key is not exist
+	GetOrSet(ctx context.Context, key string, exp time.Duration, loader LoaderFunc) ([]byte, error)
 
-	// Exist Check existing key
-	Exist(ctx context.Context, key string) (bool, error)
+	// DelPattern delete all keys that match the glob style pattern ("user:*") and return the number of deleted keys
+	DelPattern(ctx context.Context, pattern string) (int64, error)
 }
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// The error returned by every Cache implementation can be checked with errors.Is
+//
+//	value, err := c.Get(ctx, key)
+//	if errors.Is(err, cache.ErrCacheMiss) {
+//		// load from the database
+//	}
+var (
+	ErrCacheMiss       = errors.New("cache miss")
+	ErrCacheTimeout    = errors.New("cache timeout")
+	ErrCacheConnection = errors.New("cache connection failed")
+)
+
+// ErrValueTooLarge is returned by MemoryCache when the key and the value alone are bigger than MaxBytes
+var ErrValueTooLarge = errors.New("value is larger than the cache max bytes")
+
+// ErrNotFound is returned by the LoaderFunc when the origin has no value. Loader cache it as the negative result
+var ErrNotFound = errors.New("not found")
+
+// cacheError keep the original error while it is also matched with the sentinel error
+type cacheError struct {
+	kind error
+	err  error
+}
+
+func (e *cacheError) Error() string {
+	return fmt.Sprintf("%s: %s", e.kind.Error(), e.err.Error())
+}
+
+func (e *cacheError) Is(target error) bool {
+	return target == e.kind
+}
+
+func (e *cacheError) Unwrap() error {
+	return e.err
+}
+
+func errCacheMiss(key string) error {
+	return &cacheError{kind: ErrCacheMiss, err: fmt.Errorf("key %s not found", key)}
+}
+
+// classifyRedisError wrap the redis error with the sentinel error. The unknown error is returned as is
+func classifyRedisError(err error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, ErrCacheMiss) || errors.Is(err, ErrCacheTimeout) || errors.Is(err, ErrCacheConnection) {
+		return err
+	}
+
+	if errors.Is(err, redis.Nil) {
+		return &cacheError{kind: ErrCacheMiss, err: err}
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) || err.Error() == "redis: connection pool timeout" {
+		return &cacheError{kind: ErrCacheTimeout, err: err}
+	}
+
+	var opErr *net.OpError
+	if errors.Is(err, redis.ErrClosed) || errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.As(err, &opErr) {
+		return &cacheError{kind: ErrCacheConnection, err: err}
+	}
+
+	return err
+}
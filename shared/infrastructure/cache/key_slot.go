@@ -0,0 +1,60 @@
+package cache
+
+import "strings"
+
+// clusterSlots is the number of the hash slot in the redis cluster
+const clusterSlots = 16384
+
+// HashTagKey put the tag in the curly braces so all the keys with the same tag are in the same cluster slot.
+// Use it for the keys which are used together by MGet, Exist, Del or the lua script
+//
+//	cartKey := cache.HashTagKey(userID, "cart")     // {user-1}:cart
+//	itemsKey := cache.HashTagKey(userID, "items")   // {user-1}:items
+func HashTagKey(tag, key string) string {
+	return "{" + tag + "}:" + key
+}
+
+// Slot return the redis cluster slot of the key. Only the first non empty {tag} is hashed if exist
+func Slot(key string) int {
+	return int(crc16(hashTag(key))) % clusterSlots
+}
+
+// SameSlot return true if all the keys can be used in one multi key command in the cluster
+func SameSlot(keys ...string) bool {
+	for i := 1; i < len(keys); i++ {
+		if Slot(keys[i]) != Slot(keys[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+func hashTag(key string) string {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return key
+	}
+
+	end := strings.IndexByte(key[start+1:], '}')
+	if end <= 0 {
+		return key
+	}
+
+	return key[start+1 : start+1+end]
+}
+
+// crc16 is the CRC16-CCITT (XMODEM) used by the redis cluster
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,302 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// LoaderOption start from NewDefaultLoaderOption then override the value with the setter
+type LoaderOption struct {
+
+	// TTL is how long the loaded value is fresh. 0 means the value never expire and it is only loaded again after Invalidate
+	TTL time.Duration
+
+	// StaleTTL is how long after TTL the stale value is still returned while it is refreshed in the background, 0 to disable
+	StaleTTL time.Duration
+
+	// NegativeTTL is how long the ErrNotFound from the loader is cached, 0 to disable
+	NegativeTTL time.Duration
+
+	// Beta control the probabilistic early expiration, bigger value refresh earlier, 0 to disable
+	Beta float64
+
+	// RefreshTimeout limit the loader call because it does not follow the caller context.
+	// Both the background refresh and the load shared by the concurrent callers are detached from the caller
+	RefreshTimeout time.Duration
+}
+
+func NewDefaultLoaderOption() LoaderOption {
+	return LoaderOption{
+		TTL:            5 * time.Minute,
+		StaleTTL:       0,
+		NegativeTTL:    30 * time.Second,
+		Beta:           1,
+		RefreshTimeout: 10 * time.Second,
+	}
+}
+
+func (l LoaderOption) SetTTL(ttl time.Duration) LoaderOption {
+	l.TTL = ttl
+	return l
+}
+
+func (l LoaderOption) SetStaleTTL(staleTTL time.Duration) LoaderOption {
+	l.StaleTTL = staleTTL
+	return l
+}
+
+func (l LoaderOption) SetNegativeTTL(negativeTTL time.Duration) LoaderOption {
+	l.NegativeTTL = negativeTTL
+	return l
+}
+
+func (l LoaderOption) SetBeta(beta float64) LoaderOption {
+	l.Beta = beta
+	return l
+}
+
+func (l LoaderOption) SetRefreshTimeout(refreshTimeout time.Duration) LoaderOption {
+	l.RefreshTimeout = refreshTimeout
+	return l
+}
+
+// Loader protect the origin from the cache stampede.
+// The concurrent miss of the same key in this instance call the LoaderFunc only once
+//
+//	loader := cache.NewLoader(redisCache, cache.NewDefaultLoaderOption().SetStaleTTL(time.Minute))
+//	value, err := loader.Get(ctx, "product:"+id, func(ctx context.Context) ([]byte, error) {
+//		product, err := repo.FindOne(ctx, id)
+//		if errors.Is(err, mongo.ErrNoDocuments) {
+//			return nil, cache.ErrNotFound
+//		}
+//		...
+//	})
+type Loader struct {
+	cache  Cache
+	option LoaderOption
+	group  singleflight.Group
+
+	// refreshing is the key which has the background refresh running
+	refreshing sync.Map
+}
+
+func NewLoader(c Cache, option ...LoaderOption) *Loader {
+
+	opt := NewDefaultLoaderOption()
+	if len(option) > 0 {
+		opt = option[0]
+	}
+
+	return &Loader{
+		cache:  c,
+		option: opt,
+	}
+}
+
+// Get return the cached value or call the loader. It return ErrNotFound for the cached negative result
+func (l *Loader) Get(ctx context.Context, key string, loader LoaderFunc) ([]byte, error) {
+
+	bytes, err := l.cache.Get(ctx, key)
+	if err == nil {
+
+		entry, ok := decodeLoaderEntry(bytes)
+		if ok {
+
+			if entry.negative {
+				return nil, ErrNotFound
+			}
+
+			now := time.Now()
+
+			if entry.freshUntil.IsZero() {
+				return entry.value, nil
+			}
+
+			if now.Before(entry.freshUntil) {
+				if l.expireEarly(now, entry) {
+					l.refresh(key, loader)
+				}
+				return entry.value, nil
+			}
+
+			// the entry is only kept after the fresh time when the stale is enabled
+			if l.option.StaleTTL > 0 {
+				l.refresh(key, loader)
+				return entry.value, nil
+			}
+		}
+	}
+
+	// the cache failure other than miss is ignored so the caller still get the value from the origin.
+	// The load run with the detached ctx so the cancellation of one caller does not fail the others waiting the same key
+	resultChan := l.group.DoChan(key, func() (any, error) {
+		loadCtx, cancel := context.WithTimeout(detachedContext{parent: ctx}, l.option.RefreshTimeout)
+		defer cancel()
+		return l.load(loadCtx, key, loader)
+	})
+
+	select {
+	case result := <-resultChan:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.([]byte), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// detachedContext keep the value of the parent like the trace id but never done
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (d detachedContext) Value(key any) any {
+	return d.parent.Value(key)
+}
+
+// Invalidate remove the key so the next Get call the loader
+func (l *Loader) Invalidate(ctx context.Context, keys ...string) error {
+	return l.cache.Del(ctx, keys...)
+}
+
+// expireEarly is the XFetch algorithm, the key which take longer to load is refreshed earlier
+func (l *Loader) expireEarly(now time.Time, entry loaderEntry) bool {
+	if l.option.Beta <= 0 || entry.delta <= 0 {
+		return false
+	}
+
+	gap := float64(entry.delta) * l.option.Beta * -math.Log(1-rand.Float64())
+
+	return !now.Add(time.Duration(gap)).Before(entry.freshUntil)
+}
+
+// refresh start the background load unless it is already running for the key
+func (l *Loader) refresh(key string, loader LoaderFunc) {
+
+	if _, running := l.refreshing.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+
+	go func() {
+		defer l.refreshing.Delete(key)
+
+		ctx, cancel := context.WithTimeout(context.Background(), l.option.RefreshTimeout)
+		defer cancel()
+
+		_, _, _ = l.group.Do(key, func() (any, error) {
+			return l.load(ctx, key, loader)
+		})
+	}()
+}
+
+func (l *Loader) load(ctx context.Context, key string, loader LoaderFunc) ([]byte, error) {
+
+	start := time.Now()
+
+	value, err := loader(ctx)
+	if errors.Is(err, ErrNotFound) {
+		if l.option.NegativeTTL > 0 {
+			_ = l.cache.Set(ctx, key, encodeLoaderEntry(loaderEntry{negative: true}), l.option.NegativeTTL)
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry := loaderEntry{
+		value: value,
+		delta: time.Since(start),
+	}
+
+	// the zero freshUntil is never stale
+	exp := NoExpiration
+	if l.option.TTL > 0 {
+		entry.freshUntil = time.Now().Add(l.option.TTL)
+		exp = l.option.TTL + l.option.StaleTTL
+	}
+
+	// the failure to write the cache does not fail the caller, the next Get just load again
+	_ = l.cache.Set(ctx, key, encodeLoaderEntry(entry), exp)
+
+	return value, nil
+}
+
+// loaderEntry is stored as 1 byte flag, 8 bytes fresh until in unix nano, 8 bytes load duration then the value.
+// The fresh until is 0 for the value which never expire
+type loaderEntry struct {
+	negative   bool
+	freshUntil time.Time
+	delta      time.Duration
+	value      []byte
+}
+
+const (
+	loaderEntryHeader   = 17
+	loaderEntryValue    = byte(1)
+	loaderEntryNegative = byte(2)
+)
+
+func encodeLoaderEntry(entry loaderEntry) []byte {
+
+	bytes := make([]byte, loaderEntryHeader+len(entry.value))
+
+	bytes[0] = loaderEntryValue
+	if entry.negative {
+		bytes[0] = loaderEntryNegative
+	}
+
+	if !entry.freshUntil.IsZero() {
+		binary.BigEndian.PutUint64(bytes[1:9], uint64(entry.freshUntil.UnixNano()))
+	}
+	binary.BigEndian.PutUint64(bytes[9:17], uint64(entry.delta))
+	copy(bytes[loaderEntryHeader:], entry.value)
+
+	return bytes
+}
+
+func decodeLoaderEntry(bytes []byte) (loaderEntry, bool) {
+
+	if len(bytes) < loaderEntryHeader {
+		return loaderEntry{}, false
+	}
+
+	switch bytes[0] {
+	case loaderEntryNegative:
+		return loaderEntry{negative: true}, true
+	case loaderEntryValue:
+	default:
+		return loaderEntry{}, false
+	}
+
+	entry := loaderEntry{
+		delta: time.Duration(binary.BigEndian.Uint64(bytes[9:17])),
+		value: bytes[loaderEntryHeader:],
+	}
+
+	if freshUntil := int64(binary.BigEndian.Uint64(bytes[1:9])); freshUntil != 0 {
+		entry.freshUntil = time.Unix(0, freshUntil)
+	}
+
+	return entry, true
+}
@@ -0,0 +1,224 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"infrastructure/shared/infrastructure/cache"
+)
+
+// countingLoader return the value of the current version and count the call
+type countingLoader struct {
+	calls   int64
+	mutex   sync.Mutex
+	value   string
+	err     error
+	release chan struct{}
+}
+
+func (c *countingLoader) load(ctx context.Context) ([]byte, error) {
+	atomic.AddInt64(&c.calls, 1)
+
+	if c.release != nil {
+		<-c.release
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.err != nil {
+		return nil, c.err
+	}
+	return []byte(c.value), nil
+}
+
+func (c *countingLoader) set(value string, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.value = value
+	c.err = err
+}
+
+func (c *countingLoader) callCount() int64 {
+	return atomic.LoadInt64(&c.calls)
+}
+
+func newTestLoader(t *testing.T, option cache.LoaderOption) *cache.Loader {
+	c := cache.NewMemoryCache()
+	t.Cleanup(c.Close)
+	return cache.NewLoader(c, option.SetBeta(0))
+}
+
+func expectValue(t *testing.T, loader *cache.Loader, source *countingLoader, want string) {
+	t.Helper()
+	value, err := loader.Get(context.Background(), "product:1", source.load)
+	if err != nil || string(value) != want {
+		t.Fatalf("value is %q %v, want %q", value, err, want)
+	}
+}
+
+func waitUntil(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition is not reached in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLoaderCoalesceConcurrentMiss(t *testing.T) {
+
+	loader := newTestLoader(t, cache.NewDefaultLoaderOption())
+	source := &countingLoader{value: "v1", release: make(chan struct{})}
+
+	// the cancelled caller give up without failing the others
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 11)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := loader.Get(context.Background(), "product:1", source.load)
+			if err == nil && string(value) != "v1" {
+				err = errors.New("unexpected value " + string(value))
+			}
+			errs <- err
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := loader.Get(cancelledCtx, "product:1", source.load)
+		if !errors.Is(err, context.Canceled) {
+			errs <- errors.New("cancelled caller does not get the ctx error")
+		}
+	}()
+
+	waitUntil(t, func() bool { return source.callCount() == 1 })
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	close(source.release)
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if source.callCount() != 1 {
+		t.Fatalf("loader is called %d times", source.callCount())
+	}
+}
+
+func TestLoaderStaleWhileRevalidate(t *testing.T) {
+
+	loader := newTestLoader(t, cache.NewDefaultLoaderOption().SetTTL(30*time.Millisecond).SetStaleTTL(time.Minute))
+	source := &countingLoader{value: "v1"}
+
+	expectValue(t, loader, source, "v1")
+
+	time.Sleep(40 * time.Millisecond)
+	source.set("v2", nil)
+
+	// the stale value is returned while it is loaded in the background
+	expectValue(t, loader, source, "v1")
+
+	waitUntil(t, func() bool {
+		value, _ := loader.Get(context.Background(), "product:1", source.load)
+		return string(value) == "v2"
+	})
+
+	if source.callCount() != 2 {
+		t.Fatalf("loader is called %d times", source.callCount())
+	}
+}
+
+func TestLoaderWithoutStaleLoadAfterTTL(t *testing.T) {
+
+	loader := newTestLoader(t, cache.NewDefaultLoaderOption().SetTTL(30*time.Millisecond))
+	source := &countingLoader{value: "v1"}
+
+	expectValue(t, loader, source, "v1")
+	expectValue(t, loader, source, "v1")
+
+	time.Sleep(40 * time.Millisecond)
+	source.set("v2", nil)
+
+	expectValue(t, loader, source, "v2")
+
+	if source.callCount() != 2 {
+		t.Fatalf("loader is called %d times", source.callCount())
+	}
+}
+
+func TestLoaderNegativeCache(t *testing.T) {
+
+	loader := newTestLoader(t, cache.NewDefaultLoaderOption().SetNegativeTTL(30*time.Millisecond))
+	source := &countingLoader{err: cache.ErrNotFound}
+
+	for i := 0; i < 2; i++ {
+		if _, err := loader.Get(context.Background(), "product:1", source.load); !errors.Is(err, cache.ErrNotFound) {
+			t.Fatalf("get of the missing value return %v", err)
+		}
+	}
+
+	if source.callCount() != 1 {
+		t.Fatalf("not found is loaded %d times", source.callCount())
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	source.set("v1", nil)
+
+	expectValue(t, loader, source, "v1")
+}
+
+func TestLoaderDoesNotCacheTheError(t *testing.T) {
+
+	loader := newTestLoader(t, cache.NewDefaultLoaderOption())
+	source := &countingLoader{err: errors.New("database is down")}
+
+	for i := 0; i < 2; i++ {
+		if _, err := loader.Get(context.Background(), "product:1", source.load); err == nil {
+			t.Fatal("error of the loader is not returned")
+		}
+	}
+
+	if source.callCount() != 2 {
+		t.Fatalf("failed load is called %d times", source.callCount())
+	}
+}
+
+func TestLoaderZeroTTLNeverExpire(t *testing.T) {
+
+	loader := newTestLoader(t, cache.NewDefaultLoaderOption().SetTTL(0))
+	source := &countingLoader{value: "v1"}
+
+	expectValue(t, loader, source, "v1")
+	time.Sleep(10 * time.Millisecond)
+	source.set("v2", nil)
+	expectValue(t, loader, source, "v1")
+
+	if source.callCount() != 1 {
+		t.Fatalf("loader is called %d times", source.callCount())
+	}
+
+	if err := loader.Invalidate(context.Background(), "product:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectValue(t, loader, source, "v2")
+}
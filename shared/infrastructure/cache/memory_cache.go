@@ -0,0 +1,598 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type EvictionPolicy int
+
+const (
+	// EvictionLRU remove the least recently used key first
+	EvictionLRU EvictionPolicy = iota
+
+	// EvictionLFU remove the least frequently used key first, the older one first on the same frequency
+	EvictionLFU
+)
+
+// entryOverhead is the estimated memory used by one entry beside the key and the value
+const entryOverhead = 96
+
+// MemoryCacheOption start from NewDefaultMemoryCacheOption then override the value with the setter
+type MemoryCacheOption struct {
+
+	// MaxEntries is the maximum number of key, 0 means unlimited
+	MaxEntries int
+
+	// MaxBytes is the maximum estimated memory of the keys and values, 0 means unlimited
+	MaxBytes int64
+
+	Eviction EvictionPolicy
+
+	// JanitorInterval is how often the expired key is removed, the expired key is never returned even before it is removed
+	JanitorInterval time.Duration
+}
+
+func NewDefaultMemoryCacheOption() MemoryCacheOption {
+	return MemoryCacheOption{
+		MaxEntries:      10000,
+		MaxBytes:        0,
+		Eviction:        EvictionLRU,
+		JanitorInterval: time.Minute,
+	}
+}
+
+func (m MemoryCacheOption) SetMaxEntries(maxEntries int) MemoryCacheOption {
+	m.MaxEntries = maxEntries
+	return m
+}
+
+func (m MemoryCacheOption) SetMaxBytes(maxBytes int64) MemoryCacheOption {
+	m.MaxBytes = maxBytes
+	return m
+}
+
+func (m MemoryCacheOption) SetEviction(eviction EvictionPolicy) MemoryCacheOption {
+	m.Eviction = eviction
+	return m
+}
+
+func (m MemoryCacheOption) SetJanitorInterval(janitorInterval time.Duration) MemoryCacheOption {
+	m.JanitorInterval = janitorInterval
+	return m
+}
+
+// MemoryCacheStats is the snapshot of the cache counter
+type MemoryCacheStats struct {
+	Hits        int64
+	Misses      int64
+	Evictions   int64
+	Expirations int64
+	Entries     int
+	Bytes       int64
+}
+
+// HitRate return the ratio of hit from all the read, 0 if there is no read yet
+func (s MemoryCacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type memoryEntry struct {
+	key      string
+	value    []byte
+	expireAt time.Time
+
+	// element is the position in the LRU list
+	element *list.Element
+
+	// frequency, sequence and heapIndex is the position in the LFU heap
+	frequency int64
+	sequence  int64
+	heapIndex int
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
+
+func (e *memoryEntry) size() int64 {
+	return int64(len(e.key) + len(e.value) + entryOverhead)
+}
+
+// MemoryCache is the in-process Cache for the local development and the test.
+// It behave the same as RedisCache so both can be swapped without changing the caller
+type MemoryCache struct {
+	option MemoryCacheOption
+
+	mutex    sync.Mutex
+	entries  map[string]*memoryEntry
+	lru      *list.List
+	lfu      lfuHeap
+	sequence int64
+	stats    MemoryCacheStats
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewMemoryCache(option ...MemoryCacheOption) *MemoryCache {
+
+	opt := NewDefaultMemoryCacheOption()
+	if len(option) > 0 {
+		opt = option[0]
+	}
+
+	c := &MemoryCache{
+		option:  opt,
+		entries: map[string]*memoryEntry{},
+		lru:     list.New(),
+		stop:    make(chan struct{}),
+	}
+
+	if opt.JanitorInterval > 0 {
+		go c.janitor()
+	}
+
+	return c
+}
+
+// Close stop the janitor
+func (c *MemoryCache) Close() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+}
+
+func (c *MemoryCache) Stats() MemoryCacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	return stats
+}
+
+func (c *MemoryCache) janitor() {
+
+	ticker := time.NewTicker(c.option.JanitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case now := <-ticker.C:
+			c.mutex.Lock()
+			for _, entry := range c.entries {
+				if entry.expired(now) {
+					c.remove(entry)
+					c.stats.Expirations++
+				}
+			}
+			c.mutex.Unlock()
+		}
+	}
+}
+
+// lookup return the live entry and record the hit or miss. The caller must hold the lock
+func (c *MemoryCache) lookup(key string, now time.Time) (*memoryEntry, bool) {
+
+	entry, exist := c.entries[key]
+	if exist && entry.expired(now) {
+		c.remove(entry)
+		c.stats.Expirations++
+		exist = false
+	}
+
+	if !exist {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.stats.Hits++
+	c.touch(entry)
+
+	return entry, true
+}
+
+// peek return the live entry without changing the eviction order. The caller must hold the lock
+func (c *MemoryCache) peek(key string, now time.Time) (*memoryEntry, bool) {
+
+	entry, exist := c.entries[key]
+	if exist && entry.expired(now) {
+		c.remove(entry)
+		c.stats.Expirations++
+		return nil, false
+	}
+
+	return entry, exist
+}
+
+func (c *MemoryCache) touch(entry *memoryEntry) {
+	c.sequence++
+	entry.sequence = c.sequence
+	entry.frequency++
+
+	if c.option.Eviction == EvictionLFU {
+		heap.Fix(&c.lfu, entry.heapIndex)
+		return
+	}
+
+	c.lru.MoveToFront(entry.element)
+}
+
+// put insert or replace the value. The caller must hold the lock.
+// The value which can never fit is not stored and the previous value of the key is removed so it is not read as the new one
+func (c *MemoryCache) put(key string, value []byte, expireAt time.Time) error {
+
+	if err := c.checkSize(key, value); err != nil {
+		if entry, exist := c.entries[key]; exist {
+			c.remove(entry)
+		}
+		return err
+	}
+
+	if entry, exist := c.entries[key]; exist {
+		c.stats.Bytes -= entry.size()
+		entry.value = append([]byte{}, value...)
+		entry.expireAt = expireAt
+		c.stats.Bytes += entry.size()
+		c.touch(entry)
+		c.evict(0, 0)
+		return nil
+	}
+
+	entry := &memoryEntry{
+		key:      key,
+		value:    append([]byte{}, value...),
+		expireAt: expireAt,
+	}
+
+	// make room before the insert so the new key is never the one evicted
+	c.evict(1, entry.size())
+
+	c.entries[key] = entry
+	c.stats.Bytes += entry.size()
+
+	if c.option.Eviction == EvictionLFU {
+		heap.Push(&c.lfu, entry)
+	} else {
+		entry.element = c.lru.PushFront(entry)
+	}
+
+	c.touch(entry)
+
+	return nil
+}
+
+// checkSize reject the value which would evict the whole cache and still not fit
+func (c *MemoryCache) checkSize(key string, value []byte) error {
+	size := int64(len(key) + len(value) + entryOverhead)
+	if c.option.MaxBytes > 0 && size > c.option.MaxBytes {
+		return fmt.Errorf("key %s has %d bytes, max %d: %w", key, size, c.option.MaxBytes, ErrValueTooLarge)
+	}
+	return nil
+}
+
+func (c *MemoryCache) remove(entry *memoryEntry) {
+
+	delete(c.entries, entry.key)
+	c.stats.Bytes -= entry.size()
+
+	if c.option.Eviction == EvictionLFU {
+		heap.Remove(&c.lfu, entry.heapIndex)
+		return
+	}
+
+	c.lru.Remove(entry.element)
+}
+
+// evict remove the entries until the extra entries and bytes fit the limit
+func (c *MemoryCache) evict(extraEntries int, extraBytes int64) {
+	for len(c.entries) > 0 && c.overLimit(extraEntries, extraBytes) {
+
+		var victim *memoryEntry
+		if c.option.Eviction == EvictionLFU {
+			victim = c.lfu[0]
+		} else {
+			victim = c.lru.Back().Value.(*memoryEntry)
+		}
+
+		c.remove(victim)
+		c.stats.Evictions++
+	}
+}
+
+func (c *MemoryCache) overLimit(extraEntries int, extraBytes int64) bool {
+	if c.option.MaxEntries > 0 && len(c.entries)+extraEntries > c.option.MaxEntries {
+		return true
+	}
+	return c.option.MaxBytes > 0 && c.stats.Bytes+extraBytes > c.option.MaxBytes
+}
+
+func expireAt(now time.Time, exp time.Duration) time.Time {
+	if exp <= 0 {
+		return time.Time{}
+	}
+	return now.Add(exp)
+}
+
+func (c *MemoryCache) Set(ctx context.Context, key string, value []byte, exp time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+
+	return c.put(key, value, expireAt(now, exp))
+}
+
+func (c *MemoryCache) Get(ctx context.Context, key string) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, exist := c.lookup(key, time.Now())
+	if !exist {
+		return nil, errCacheMiss(key)
+	}
+
+	return append([]byte{}, entry.value...), nil
+}
+
+func (c *MemoryCache) Del(ctx context.Context, keys ...string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, key := range keys {
+		if entry, exist := c.entries[key]; exist {
+			c.remove(entry)
+		}
+	}
+
+	return nil
+}
+
+func (c *MemoryCache) Exist(ctx context.Context, keys ...string) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(keys) == 0 {
+		return false, nil
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		if _, exist := c.peek(key, now); !exist {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (c *MemoryCache) Reset(ctx context.Context, key string, value []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+
+	var at time.Time
+	if entry, exist := c.peek(key, now); exist {
+		at = entry.expireAt
+	}
+
+	return c.put(key, value, at)
+}
+
+func (c *MemoryCache) MGet(ctx context.Context, keys ...string) ([][]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+
+	results := make([][]byte, len(keys))
+	for i, key := range keys {
+		if entry, exist := c.lookup(key, now); exist {
+			results[i] = append([]byte{}, entry.value...)
+		}
+	}
+
+	return results, nil
+}
+
+func (c *MemoryCache) MSet(ctx context.Context, values map[string][]byte, exp time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// none of the value is stored when one of them is too large, the previous value of that key is removed like Set
+	for key, value := range values {
+		if err := c.checkSize(key, value); err != nil {
+			if entry, exist := c.entries[key]; exist {
+				c.remove(entry)
+			}
+			return err
+		}
+	}
+
+	now := time.Now()
+	for key, value := range values {
+		_ = c.put(key, value, expireAt(now, exp))
+	}
+
+	return nil
+}
+
+func (c *MemoryCache) SetNX(ctx context.Context, key string, value []byte, exp time.Duration) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if _, exist := c.peek(key, now); exist {
+		return false, nil
+	}
+
+	if err := c.put(key, value, expireAt(now, exp)); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (c *MemoryCache) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+
+	var current int64
+	var at time.Time
+
+	if entry, exist := c.peek(key, now); exist {
+		n, err := strconv.ParseInt(string(entry.value), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("value of key %s is not an integer", key)
+		}
+		current = n
+		at = entry.expireAt
+	}
+
+	current += delta
+	if err := c.put(key, []byte(strconv.FormatInt(current, 10)), at); err != nil {
+		return 0, err
+	}
+
+	return current, nil
+}
+
+func (c *MemoryCache) Decr(ctx context.Context, key string, delta int64) (int64, error) {
+	return c.Incr(ctx, key, -delta)
+}
+
+func (c *MemoryCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+
+	entry, exist := c.peek(key, now)
+	if !exist {
+		return 0, errCacheMiss(key)
+	}
+
+	if entry.expireAt.IsZero() {
+		return NoExpiration, nil
+	}
+
+	return entry.expireAt.Sub(now), nil
+}
+
+func (c *MemoryCache) Expire(ctx context.Context, key string, exp time.Duration) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+
+	entry, exist := c.peek(key, now)
+	if !exist {
+		return false, nil
+	}
+
+	// same as the redis cache, the non positive expiration like NoExpiration keep the key forever
+	entry.expireAt = expireAt(now, exp)
+
+	return true, nil
+}
+
+// GetOrSet call the loader without holding the lock, many caller may load the same key at the same time
+func (c *MemoryCache) GetOrSet(ctx context.Context, key string, exp time.Duration, loader LoaderFunc) ([]byte, error) {
+
+	value, err := c.Get(ctx, key)
+	if err == nil {
+		return value, nil
+	}
+
+	value, err = loader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.Set(ctx, key, value, exp)
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// DelPattern support the "*" and "?" glob
+func (c *MemoryCache) DelPattern(ctx context.Context, pattern string) (int64, error) {
+
+	matcher, err := globToRegexp(pattern)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var deleted int64
+	for key, entry := range c.entries {
+		if matcher.MatchString(key) {
+			c.remove(entry)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	return regexp.Compile("^" + expr + "$")
+}
+
+// lfuHeap is the min heap by the frequency then the sequence
+type lfuHeap []*memoryEntry
+
+func (h lfuHeap) Len() int {
+	return len(h)
+}
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].frequency == h[j].frequency {
+		return h[i].sequence < h[j].sequence
+	}
+	return h[i].frequency < h[j].frequency
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *lfuHeap) Push(x any) {
+	entry := x.(*memoryEntry)
+	entry.heapIndex = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *lfuHeap) Pop() any {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return entry
+}
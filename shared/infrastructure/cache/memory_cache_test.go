@@ -0,0 +1,74 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"infrastructure/shared/infrastructure/cache"
+	"infrastructure/shared/infrastructure/cache/cachetest"
+)
+
+func TestMemoryCacheConformance(t *testing.T) {
+
+	for _, eviction := range []cache.EvictionPolicy{cache.EvictionLRU, cache.EvictionLFU} {
+
+		c := cache.NewMemoryCache(cache.NewDefaultMemoryCacheOption().
+			SetEviction(eviction).
+			SetJanitorInterval(10 * time.Millisecond))
+
+		if err := cachetest.TestCache(context.Background(), c); err != nil {
+			t.Errorf("eviction %v: %v", eviction, err)
+		}
+
+		c.Close()
+	}
+}
+
+func TestMemoryCacheRejectTheValueLargerThanMaxBytes(t *testing.T) {
+
+	ctx := context.Background()
+
+	c := cache.NewMemoryCache(cache.NewDefaultMemoryCacheOption().SetMaxBytes(1024))
+	defer c.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := c.Set(ctx, key, []byte("small"), cache.NoExpiration); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	large := make([]byte, 2048)
+
+	if err := c.Set(ctx, "large", large, cache.NoExpiration); !errors.Is(err, cache.ErrValueTooLarge) {
+		t.Fatalf("set of the large value return %v", err)
+	}
+
+	if _, err := c.SetNX(ctx, "large", large, cache.NoExpiration); !errors.Is(err, cache.ErrValueTooLarge) {
+		t.Fatalf("setnx of the large value return %v", err)
+	}
+
+	if err := c.MSet(ctx, map[string][]byte{"d": []byte("small"), "large": large}, cache.NoExpiration); !errors.Is(err, cache.ErrValueTooLarge) {
+		t.Fatalf("mset with the large value return %v", err)
+	}
+
+	// the other keys are not evicted for the value which can never fit
+	stats := c.Stats()
+	if stats.Entries != 3 || stats.Evictions != 0 {
+		t.Fatalf("cache has %d entries and %d evictions", stats.Entries, stats.Evictions)
+	}
+
+	if exist, _ := c.Exist(ctx, "large"); exist {
+		t.Fatal("large value is stored")
+	}
+
+	// the previous value of the key is not read as the new one
+	if err := c.Set(ctx, "a", large, cache.NoExpiration); !errors.Is(err, cache.ErrValueTooLarge) {
+		t.Fatalf("replace with the large value return %v", err)
+	}
+
+	if _, err := c.Get(ctx, "a"); !errors.Is(err, cache.ErrCacheMiss) {
+		t.Fatalf("previous value is returned, %v", err)
+	}
+}
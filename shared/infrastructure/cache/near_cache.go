@@ -0,0 +1,445 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"infrastructure/shared/util"
+)
+
+// NearCacheOption start from NewDefaultNearCacheOption then override the value with the setter
+type NearCacheOption struct {
+
+	// Channel is the redis pub/sub channel shared by all the instance to send the invalidation
+	Channel string
+
+	// LocalTTL is the maximum time the value stay in the local tier.
+	// It limit how long a value can be stale when an invalidation message is lost
+	LocalTTL time.Duration
+
+	Local MemoryCacheOption
+}
+
+func NewDefaultNearCacheOption() NearCacheOption {
+	return NearCacheOption{
+		Channel:  "cache.invalidation",
+		LocalTTL: time.Minute,
+		Local:    NewDefaultMemoryCacheOption(),
+	}
+}
+
+func (n NearCacheOption) SetChannel(channel string) NearCacheOption {
+	n.Channel = channel
+	return n
+}
+
+func (n NearCacheOption) SetLocalTTL(localTTL time.Duration) NearCacheOption {
+	n.LocalTTL = localTTL
+	return n
+}
+
+func (n NearCacheOption) SetLocal(local MemoryCacheOption) NearCacheOption {
+	n.Local = local
+	return n
+}
+
+// NearCacheStats is the snapshot of the counter per tier
+type NearCacheStats struct {
+	LocalHits          int64
+	RemoteHits         int64
+	Misses             int64
+	InvalidationErrors int64
+	Local              MemoryCacheStats
+}
+
+// LocalHitRate return the ratio of the read served by the local tier
+func (s NearCacheStats) LocalHitRate() float64 {
+	total := s.LocalHits + s.RemoteHits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.LocalHits) / float64(total)
+}
+
+// RemoteHitRate return the ratio of the read which miss the local tier but found in redis
+func (s NearCacheStats) RemoteHitRate() float64 {
+	total := s.RemoteHits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.RemoteHits) / float64(total)
+}
+
+type invalidationMessage struct {
+	Origin  string   `json:"origin"`
+	Keys    []string `json:"keys,omitempty"`
+	Pattern string   `json:"pattern,omitempty"`
+}
+
+// NearCache keep the hot value in the local memory in front of the RedisCache.
+// Every write go to redis then remove the local value in all the instance through redis pub/sub
+type NearCache struct {
+	option NearCacheOption
+	origin string
+	local  *MemoryCache
+	remote *RedisCache
+
+	localHits          int64
+	remoteHits         int64
+	misses             int64
+	invalidationErrors int64
+
+	cancel   context.CancelFunc
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewNearCache(remote *RedisCache, option ...NearCacheOption) *NearCache {
+
+	opt := NewDefaultNearCacheOption()
+	if len(option) > 0 {
+		opt = option[0]
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := &NearCache{
+		option: opt,
+		origin: util.GenerateID(16),
+		local:  NewMemoryCache(opt.Local),
+		remote: remote,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go c.listen(ctx)
+
+	return c
+}
+
+// Close stop listening the invalidation and stop the local tier janitor
+func (c *NearCache) Close() {
+	c.stopOnce.Do(func() {
+		c.cancel()
+		<-c.done
+		c.local.Close()
+	})
+}
+
+func (c *NearCache) Stats() NearCacheStats {
+	return NearCacheStats{
+		LocalHits:          atomic.LoadInt64(&c.localHits),
+		RemoteHits:         atomic.LoadInt64(&c.remoteHits),
+		Misses:             atomic.LoadInt64(&c.misses),
+		InvalidationErrors: atomic.LoadInt64(&c.invalidationErrors),
+		Local:              c.local.Stats(),
+	}
+}
+
+func (c *NearCache) listen(ctx context.Context) {
+	defer close(c.done)
+
+	pubsub := c.remote.Client.Subscribe(ctx, c.option.Channel)
+	defer func() {
+		_ = pubsub.Close()
+	}()
+
+	// go-redis reconnect by itself, the message published while disconnected is lost and the LocalTTL limit the staleness
+	messages := pubsub.Channel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+
+			var message invalidationMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
+				continue
+			}
+
+			if message.Origin == c.origin {
+				continue
+			}
+
+			c.evictLocal(ctx, message)
+		}
+	}
+}
+
+func (c *NearCache) evictLocal(ctx context.Context, message invalidationMessage) {
+	if len(message.Keys) > 0 {
+		_ = c.local.Del(ctx, message.Keys...)
+	}
+	if message.Pattern != "" {
+		_, _ = c.local.DelPattern(ctx, message.Pattern)
+	}
+}
+
+// invalidate remove the local value then tell the other instance to do the same.
+// The write to redis is already done so the publish failure is only counted, the LocalTTL limit the staleness
+func (c *NearCache) invalidate(ctx context.Context, message invalidationMessage) {
+
+	c.evictLocal(ctx, message)
+
+	message.Origin = c.origin
+
+	bytes, err := json.Marshal(message)
+	if err != nil {
+		atomic.AddInt64(&c.invalidationErrors, 1)
+		return
+	}
+
+	err = c.remote.Client.Publish(ctx, c.option.Channel, bytes).Err()
+	if err != nil {
+		atomic.AddInt64(&c.invalidationErrors, 1)
+	}
+}
+
+func (c *NearCache) invalidateKeys(ctx context.Context, keys ...string) {
+	c.invalidate(ctx, invalidationMessage{Keys: keys})
+}
+
+func (c *NearCache) Set(ctx context.Context, key string, value []byte, exp time.Duration) error {
+	err := c.remote.Set(ctx, key, value, exp)
+	if err != nil {
+		return err
+	}
+	c.invalidateKeys(ctx, key)
+	_ = c.local.Set(ctx, key, value, c.localExpiration(exp))
+	return nil
+}
+
+// localExpiration never let the local value outlive the value in redis. exp is the expiration or the remaining ttl of the key in redis
+func (c *NearCache) localExpiration(exp time.Duration) time.Duration {
+	if exp > 0 && exp < c.option.LocalTTL {
+		return exp
+	}
+	return c.option.LocalTTL
+}
+
+func (c *NearCache) Get(ctx context.Context, key string) ([]byte, error) {
+
+	value, err := c.local.Get(ctx, key)
+	if err == nil {
+		atomic.AddInt64(&c.localHits, 1)
+		return value, nil
+	}
+
+	var getCmd *redis.StringCmd
+	var ttlCmd *redis.DurationCmd
+
+	// the ttl is read together with the value so the local copy expire with the value in redis
+	_, err = c.remote.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		getCmd = pipe.Get(ctx, key)
+		ttlCmd = pipe.PTTL(ctx, key)
+		return nil
+	})
+
+	value, errGet := getCmd.Bytes()
+	if errors.Is(errGet, redis.Nil) {
+		atomic.AddInt64(&c.misses, 1)
+		return nil, errCacheMiss(key)
+	}
+	if err != nil {
+		return nil, classifyRedisError(err)
+	}
+
+	atomic.AddInt64(&c.remoteHits, 1)
+	c.fillLocal(ctx, key, value, ttlCmd.Val())
+
+	return value, nil
+}
+
+// fillLocal put the value from redis into the local tier with the remaining ttl of the key in redis.
+// The ttl is the PTTL reply, -1 is no expiration and -2 is the key which is already gone
+func (c *NearCache) fillLocal(ctx context.Context, key string, value []byte, remoteTTL time.Duration) {
+
+	if remoteTTL == -2 || remoteTTL == 0 {
+		return
+	}
+
+	_ = c.local.Set(ctx, key, value, c.localExpiration(remoteTTL))
+}
+
+func (c *NearCache) Del(ctx context.Context, keys ...string) error {
+	err := c.remote.Del(ctx, keys...)
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		c.invalidateKeys(ctx, keys...)
+	}
+	return nil
+}
+
+// Exist always ask redis because the local tier only has part of the keys
+func (c *NearCache) Exist(ctx context.Context, keys ...string) (bool, error) {
+	return c.remote.Exist(ctx, keys...)
+}
+
+func (c *NearCache) Reset(ctx context.Context, key string, value []byte) error {
+	err := c.remote.Reset(ctx, key, value)
+	if err != nil {
+		return err
+	}
+	c.invalidateKeys(ctx, key)
+	return nil
+}
+
+func (c *NearCache) MGet(ctx context.Context, keys ...string) ([][]byte, error) {
+
+	results, err := c.local.MGet(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	var missingKeys []string
+	var missingIndexes []int
+	for i, value := range results {
+		if value == nil {
+			missingKeys = append(missingKeys, keys[i])
+			missingIndexes = append(missingIndexes, i)
+		}
+	}
+
+	atomic.AddInt64(&c.localHits, int64(len(keys)-len(missingKeys)))
+
+	if len(missingKeys) == 0 {
+		return results, nil
+	}
+
+	remoteValues, err := c.remote.MGet(ctx, missingKeys...)
+	if err != nil {
+		return nil, err
+	}
+
+	ttlCmds := make([]*redis.DurationCmd, len(missingKeys))
+
+	// the local tier is only filled when the ttl can be read
+	_, errTTL := c.remote.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, value := range remoteValues {
+			if value != nil {
+				ttlCmds[i] = pipe.PTTL(ctx, missingKeys[i])
+			}
+		}
+		return nil
+	})
+
+	for i, value := range remoteValues {
+		if value == nil {
+			atomic.AddInt64(&c.misses, 1)
+			continue
+		}
+		atomic.AddInt64(&c.remoteHits, 1)
+		results[missingIndexes[i]] = value
+		if errTTL == nil {
+			c.fillLocal(ctx, missingKeys[i], value, ttlCmds[i].Val())
+		}
+	}
+
+	return results, nil
+}
+
+func (c *NearCache) MSet(ctx context.Context, values map[string][]byte, exp time.Duration) error {
+	err := c.remote.MSet(ctx, values, exp)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	if len(keys) > 0 {
+		c.invalidateKeys(ctx, keys...)
+	}
+
+	return nil
+}
+
+func (c *NearCache) SetNX(ctx context.Context, key string, value []byte, exp time.Duration) (bool, error) {
+	ok, err := c.remote.SetNX(ctx, key, value, exp)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		c.invalidateKeys(ctx, key)
+	}
+	return ok, nil
+}
+
+func (c *NearCache) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	result, err := c.remote.Incr(ctx, key, delta)
+	if err != nil {
+		return 0, err
+	}
+	c.invalidateKeys(ctx, key)
+	return result, nil
+}
+
+func (c *NearCache) Decr(ctx context.Context, key string, delta int64) (int64, error) {
+	result, err := c.remote.Decr(ctx, key, delta)
+	if err != nil {
+		return 0, err
+	}
+	c.invalidateKeys(ctx, key)
+	return result, nil
+}
+
+func (c *NearCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return c.remote.TTL(ctx, key)
+}
+
+func (c *NearCache) Expire(ctx context.Context, key string, exp time.Duration) (bool, error) {
+	ok, err := c.remote.Expire(ctx, key, exp)
+	if err != nil {
+		return false, err
+	}
+	// the local copy may outlive the new expiration, it is filled again with the new ttl on the next Get
+	if ok {
+		c.invalidateKeys(ctx, key)
+	}
+	return ok, nil
+}
+
+func (c *NearCache) GetOrSet(ctx context.Context, key string, exp time.Duration, loader LoaderFunc) ([]byte, error) {
+
+	value, err := c.Get(ctx, key)
+	if err == nil {
+		return value, nil
+	}
+	if !errors.Is(err, ErrCacheMiss) {
+		return nil, err
+	}
+
+	value, err = loader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.Set(ctx, key, value, exp)
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+func (c *NearCache) DelPattern(ctx context.Context, pattern string) (int64, error) {
+	deleted, err := c.remote.DelPattern(ctx, pattern)
+	if err != nil {
+		return 0, err
+	}
+	c.invalidate(ctx, invalidationMessage{Pattern: pattern})
+	return deleted, nil
+}
@@ -0,0 +1,98 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"infrastructure/shared/infrastructure/cache"
+	"infrastructure/shared/infrastructure/cache/cachetest"
+)
+
+func TestNearCacheConformance(t *testing.T) {
+
+	c := cache.NewNearCache(&cache.RedisCache{Client: newMiniRedis(t)})
+	defer c.Close()
+
+	if err := cachetest.TestCache(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectEventually(t *testing.T, condition func() bool, message string) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestNearCacheLocalCopyExpireWithRedis(t *testing.T) {
+
+	ctx := context.Background()
+	remote := &cache.RedisCache{Client: newMiniRedis(t)}
+
+	c := cache.NewNearCache(remote)
+	defer c.Close()
+
+	// the key is written by the other writer with the short ttl, the local copy must not outlive it
+	if err := remote.Set(ctx, "k", []byte("v"), 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Get(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.MGet(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Stats().LocalHits != 1 {
+		t.Fatalf("local hits: got %d want 1", c.Stats().LocalHits)
+	}
+
+	expectEventually(t, func() bool {
+		_, err := c.Get(ctx, "k")
+		return errors.Is(err, cache.ErrCacheMiss)
+	}, "local copy outlive the key in redis")
+}
+
+func TestNearCacheExpireInvalidateOtherInstance(t *testing.T) {
+
+	ctx := context.Background()
+	remote := &cache.RedisCache{Client: newMiniRedis(t)}
+
+	a := cache.NewNearCache(remote)
+	defer a.Close()
+
+	b := cache.NewNearCache(remote)
+	defer b.Close()
+
+	// wait until both instances listen the invalidation
+	expectEventually(t, func() bool {
+		n, err := remote.Client.PubSubNumSub(ctx, cache.NewDefaultNearCacheOption().Channel).Result()
+		return err == nil && n[cache.NewDefaultNearCacheOption().Channel] == 2
+	}, "near cache does not subscribe the invalidation channel")
+
+	if err := a.Set(ctx, "k", []byte("v"), cache.NoExpiration); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Get(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := a.Expire(ctx, "k", 50*time.Millisecond)
+	if err != nil || !ok {
+		t.Fatalf("expire: %v %v", ok, err)
+	}
+
+	expectEventually(t, func() bool {
+		_, err := b.Get(ctx, "k")
+		return errors.Is(err, cache.ErrCacheMiss)
+	}, "local copy is not invalidated by the shorter expiration")
+}
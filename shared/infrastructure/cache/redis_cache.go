@@ -4,44 +4,154 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"infrastructure/shared/infrastructure/config"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
+// RedisCache work with the standalone, the sentinel and the cluster client.
+// In the cluster the multi key operation is split by the slot, use HashTagKey to keep them in one command
 type RedisCache struct {
-	Client *redis.Client
+	Client redis.UniversalClient
+}
+
+// NewRedisClient create the redis client from the config. The client can be shared by RedisCache and the redis stream messaging
+//
+//	client, err := cache.NewRedisClient(cfg.Cache)
+//	if err != nil {
+//		panic(err.Error())
+//	}
+//	redisCache := &cache.RedisCache{Client: client}
+func NewRedisClient(cfg config.Cache) (redis.UniversalClient, error) {
+
+	tlsConfig, err := cfg.TLS.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := cfg.Addresses
+	if len(addresses) == 0 && cfg.Address != "" {
+		addresses = []string{cfg.Address}
+	}
+
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("redis address is not set")
+	}
+
+	options := &redis.UniversalOptions{
+		Addrs:            addresses,
+		MasterName:       cfg.MasterName,
+		Username:         cfg.Username,
+		Password:         cfg.Password.Value(),
+		SentinelPassword: cfg.SentinelPassword.Value(),
+		DB:               cfg.Database,
+		PoolSize:         cfg.PoolSize,
+		MinIdleConns:     cfg.MinIdleConns,
+		DialTimeout:      cfg.DialTimeout.Duration(),
+		ReadTimeout:      cfg.ReadTimeout.Duration(),
+		WriteTimeout:     cfg.WriteTimeout.Duration(),
+		PoolTimeout:      cfg.PoolTimeout.Duration(),
+		TLSConfig:        tlsConfig,
+	}
+
+	switch cfg.Mode {
+	case "", config.CacheModeStandalone:
+		return redis.NewClient(options.Simple()), nil
+
+	case config.CacheModeSentinel:
+		if cfg.MasterName == "" {
+			return nil, fmt.Errorf("masterName is required by the sentinel mode")
+		}
+		return redis.NewFailoverClient(options.Failover()), nil
+
+	case config.CacheModeCluster:
+		return redis.NewClusterClient(options.Cluster()), nil
+	}
+
+	return nil, fmt.Errorf("unknown redis mode %s", cfg.Mode)
+}
+
+// groupBySlot return the indexes of the keys per slot, all the keys are in one group when it is not the cluster
+func (c *RedisCache) groupBySlot(keys []string) [][]int {
+
+	if _, ok := c.Client.(*redis.ClusterClient); !ok {
+		group := make([]int, len(keys))
+		for i := range keys {
+			group[i] = i
+		}
+		return [][]int{group}
+	}
+
+	var groups [][]int
+	slotGroup := map[int]int{}
+	for i, key := range keys {
+		slot := Slot(key)
+		index, exist := slotGroup[slot]
+		if !exist {
+			index = len(groups)
+			slotGroup[slot] = index
+			groups = append(groups, nil)
+		}
+		groups[index] = append(groups[index], i)
+	}
+
+	return groups
+}
+
+func pick(keys []string, indexes []int) []string {
+	results := make([]string, len(indexes))
+	for i, index := range indexes {
+		results[i] = keys[index]
+	}
+	return results
 }
 
 // Set receive key and value as input and return error
+// redisExpiration turn the negative expiration like NoExpiration into 0.
+// go-redis read -1 as redis.KeepTTL which keep the old expiration instead of removing it
+func redisExpiration(exp time.Duration) time.Duration {
+	if exp < 0 {
+		return 0
+	}
+	return exp
+}
+
 func (c *RedisCache) Set(ctx context.Context, key string, value []byte, exp time.Duration) error {
-	err := c.Client.Set(ctx, key, value, exp).Err()
+	err := c.Client.Set(ctx, key, value, redisExpiration(exp)).Err()
 	if err != nil {
-		return err
+		return classifyRedisError(err)
 	}
 	return nil
 }
 
-// Get receive key and bytes (return object from passing by reference)
-// return isExist flag and error
-func (c *RedisCache) Get(ctx context.Context, key string) (string, error) {
-	result, err := c.Client.Get(ctx, key).Result()
+// Get receive key and return the value
+// return ErrCacheMiss if the key is not exist
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	result, err := c.Client.Get(ctx, key).Bytes()
 	if errors.Is(err, redis.Nil) {
-		return "", fmt.Errorf("Redis key %s not found", key)
+		return nil, errCacheMiss(key)
 	}
 
 	if err != nil {
-		return "", err
+		return nil, classifyRedisError(err)
 	}
 	return result, nil
 }
 
 // Del deletes by key
-func (c *RedisCache) Del(ctx context.Context, key string) error {
+func (c *RedisCache) Del(ctx context.Context, keys ...string) error {
 
-	err := c.Client.Del(ctx, key).Err()
-	if err != nil {
-		return err
+	if len(keys) == 0 {
+		return nil
+	}
+
+	for _, group := range c.groupBySlot(keys) {
+		err := c.Client.Del(ctx, pick(keys, group)...).Err()
+		if err != nil {
+			return classifyRedisError(err)
+		}
 	}
 
 	return nil
@@ -52,23 +162,213 @@ func (c *RedisCache) Del(ctx context.Context, key string) error {
 func (c *RedisCache) Reset(ctx context.Context, key string, value []byte) error {
 	err := c.Client.Set(ctx, key, value, redis.KeepTTL).Err()
 	if err != nil {
-		return err
+		return classifyRedisError(err)
 	}
 	return nil
 }
 
-// Exist check the existence of key
+// Exist check the existence of all the keys without fetching the value
 // return isExist flag and error
-func (c *RedisCache) Exist(ctx context.Context, key string) (bool, error) {
+func (c *RedisCache) Exist(ctx context.Context, keys ...string) (bool, error) {
 
-	_, err := c.Client.Get(ctx, key).Result()
-	if errors.Is(err, redis.Nil) {
+	if len(keys) == 0 {
 		return false, nil
 	}
 
-	if err != nil {
-		return false, err
+	// EXISTS count the same key as many times as it is mentioned
+	for _, group := range c.groupBySlot(keys) {
+		count, err := c.Client.Exists(ctx, pick(keys, group)...).Result()
+		if err != nil {
+			return false, classifyRedisError(err)
+		}
+
+		if count != int64(len(group)) {
+			return false, nil
+		}
 	}
 
 	return true, nil
 }
+
+// MGet return nil for the missing key
+func (c *RedisCache) MGet(ctx context.Context, keys ...string) ([][]byte, error) {
+
+	if len(keys) == 0 {
+		return [][]byte{}, nil
+	}
+
+	results := make([][]byte, len(keys))
+
+	for _, group := range c.groupBySlot(keys) {
+		items, err := c.Client.MGet(ctx, pick(keys, group)...).Result()
+		if err != nil {
+			return nil, classifyRedisError(err)
+		}
+
+		for i, item := range items {
+			if s, ok := item.(string); ok {
+				results[group[i]] = []byte(s)
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// MSet use the pipeline since MSET has no expiration
+func (c *RedisCache) MSet(ctx context.Context, values map[string][]byte, exp time.Duration) error {
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	_, err := c.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, value := range values {
+			pipe.Set(ctx, key, value, redisExpiration(exp))
+		}
+		return nil
+	})
+	if err != nil {
+		return classifyRedisError(err)
+	}
+
+	return nil
+}
+
+func (c *RedisCache) SetNX(ctx context.Context, key string, value []byte, exp time.Duration) (bool, error) {
+	ok, err := c.Client.SetNX(ctx, key, value, redisExpiration(exp)).Result()
+	return ok, classifyRedisError(err)
+}
+
+func (c *RedisCache) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	n, err := c.Client.IncrBy(ctx, key, delta).Result()
+	return n, classifyRedisError(err)
+}
+
+func (c *RedisCache) Decr(ctx context.Context, key string, delta int64) (int64, error) {
+	n, err := c.Client.DecrBy(ctx, key, delta).Result()
+	return n, classifyRedisError(err)
+}
+
+// TTL return NoExpiration if the key has no expiration
+func (c *RedisCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+
+	// go-redis return the negative reply of PTTL as is, -2 is the missing key and -1 is no expiration
+	ttl, err := c.Client.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, classifyRedisError(err)
+	}
+
+	if ttl == -2 {
+		return 0, errCacheMiss(key)
+	}
+
+	if ttl < 0 {
+		return NoExpiration, nil
+	}
+
+	return ttl, nil
+}
+
+// Expire with the non positive exp like NoExpiration remove the expiration by PERSIST, the negative PEXPIRE delete the key.
+// PERSIST return false for the key without the expiration so EXISTS is sent in the same transaction
+func (c *RedisCache) Expire(ctx context.Context, key string, exp time.Duration) (bool, error) {
+
+	if exp > 0 {
+		ok, err := c.Client.PExpire(ctx, key, exp).Result()
+		return ok, classifyRedisError(err)
+	}
+
+	var exists *redis.IntCmd
+	_, err := c.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		exists = pipe.Exists(ctx, key)
+		pipe.Persist(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return false, classifyRedisError(err)
+	}
+
+	return exists.Val() > 0, nil
+}
+
+// GetOrSet is not atomic, many caller may load the same key at the same time
+func (c *RedisCache) GetOrSet(ctx context.Context, key string, exp time.Duration, loader LoaderFunc) ([]byte, error) {
+
+	result, err := c.Client.Get(ctx, key).Bytes()
+	if err == nil {
+		return result, nil
+	}
+
+	if !errors.Is(err, redis.Nil) {
+		return nil, classifyRedisError(err)
+	}
+
+	result, err = loader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.Set(ctx, key, result, exp)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// DelPattern use SCAN instead of KEYS so it does not block redis. In the cluster every master is scanned
+func (c *RedisCache) DelPattern(ctx context.Context, pattern string) (int64, error) {
+
+	cluster, ok := c.Client.(*redis.ClusterClient)
+	if !ok {
+		return delPattern(ctx, c.Client, pattern)
+	}
+
+	var mutex sync.Mutex
+	var deleted int64
+
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+		n, err := delPattern(ctx, client, pattern)
+		mutex.Lock()
+		deleted += n
+		mutex.Unlock()
+		return err
+	})
+
+	return deleted, err
+}
+
+// delPattern delete the key one by one because the keys from SCAN may be in the different slot
+func delPattern(ctx context.Context, client redis.Cmdable, pattern string) (int64, error) {
+
+	var deleted int64
+	var cursor uint64
+
+	for {
+		keys, nextCursor, err := client.Scan(ctx, cursor, pattern, 500).Result()
+		if err != nil {
+			return deleted, classifyRedisError(err)
+		}
+
+		if len(keys) > 0 {
+			cmds, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				for _, key := range keys {
+					pipe.Del(ctx, key)
+				}
+				return nil
+			})
+			if err != nil {
+				return deleted, classifyRedisError(err)
+			}
+			for _, cmd := range cmds {
+				deleted += cmd.(*redis.IntCmd).Val()
+			}
+		}
+
+		cursor = nextCursor
+		if cursor == 0 {
+			return deleted, nil
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package cache_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+
+	"infrastructure/shared/infrastructure/cache"
+	"infrastructure/shared/infrastructure/cache/cachetest"
+)
+
+// newMiniRedis start the in-process redis. Its clock only move by FastForward, so it is moved along the real time
+// to let the key expire while the test sleep
+func newMiniRedis(t *testing.T) *redis.Client {
+
+	server := miniredis.RunT(t)
+
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+
+	go func() {
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+
+		last := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				server.FastForward(now.Sub(last))
+				last = now
+			}
+		}
+	}()
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	return client
+}
+
+func TestRedisCacheConformance(t *testing.T) {
+
+	c := &cache.RedisCache{Client: newMiniRedis(t)}
+
+	if err := cachetest.TestCache(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Codec convert the go value into the cached bytes
+type Codec interface {
+	Marshal(obj any) ([]byte, error)
+	Unmarshal(bytes []byte, obj any) error
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(obj any) ([]byte, error) {
+	return json.Marshal(obj)
+}
+
+func (JSONCodec) Unmarshal(bytes []byte, obj any) error {
+	return json.Unmarshal(bytes, obj)
+}
+
+// Typed wrap the Cache to work with T instead of the bytes
+//
+//	userCache := cache.NewTyped[User](redisCache, cache.JSONCodec{})
+//	user, err := userCache.GetOrSet(ctx, "user:"+id, time.Minute, func(ctx context.Context) (*User, error) {
+//		return repo.FindUser(ctx, id)
+//	})
+type Typed[T any] struct {
+	cache Cache
+	codec Codec
+}
+
+func NewTyped[T any](c Cache, codec Codec) *Typed[T] {
+	return &Typed[T]{
+		cache: c,
+		codec: codec,
+	}
+}
+
+func (r *Typed[T]) Set(ctx context.Context, key string, value *T, exp time.Duration) error {
+	bytes, err := r.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return r.cache.Set(ctx, key, bytes, exp)
+}
+
+func (r *Typed[T]) Get(ctx context.Context, key string) (*T, error) {
+	bytes, err := r.cache.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return r.decode(bytes)
+}
+
+// MGet return the values in the same order as the keys, the value of the missing key is nil
+func (r *Typed[T]) MGet(ctx context.Context, keys ...string) ([]*T, error) {
+
+	items, err := r.cache.MGet(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*T, len(items))
+	for i, bytes := range items {
+		if bytes == nil {
+			continue
+		}
+		results[i], err = r.decode(bytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+func (r *Typed[T]) MSet(ctx context.Context, values map[string]*T, exp time.Duration) error {
+
+	items := make(map[string][]byte, len(values))
+	for key, value := range values {
+		bytes, err := r.codec.Marshal(value)
+		if err != nil {
+			return err
+		}
+		items[key] = bytes
+	}
+
+	return r.cache.MSet(ctx, items, exp)
+}
+
+func (r *Typed[T]) GetOrSet(ctx context.Context, key string, exp time.Duration, loader func(ctx context.Context) (*T, error)) (*T, error) {
+
+	bytes, err := r.cache.GetOrSet(ctx, key, exp, func(ctx context.Context) ([]byte, error) {
+		value, err := loader(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return r.codec.Marshal(value)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r.decode(bytes)
+}
+
+func (r *Typed[T]) decode(bytes []byte) (*T, error) {
+	var obj T
+	err := r.codec.Unmarshal(bytes, &obj)
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
@@ -1,10 +1,13 @@
 package config
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 )
 
+// ReadConfig read the config.json and panic when it can not be read. Only the Secret reference is resolved,
+// use LoadConfig for the layered config with the env, the flag, the source of every value and the validation
 func ReadConfig() *Config {
 
 	bytes, err := os.ReadFile("config.json")
@@ -19,5 +22,10 @@ func ReadConfig() *Config {
 		panic(err.Error())
 	}
 
+	err = ResolveSecrets(context.Background(), &cfg)
+	if err != nil {
+		panic(err.Error())
+	}
+
 	return &cfg
 }
@@ -0,0 +1,568 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// SourceDefault is the source of the value from LoadOption.Defaults
+const SourceDefault = "default"
+
+// Sources map the dotted path of the value like "cache.address" to where it come from.
+// The source is "default", "file:<path>", "env:<NAME>" or "flag:-<name>"
+type Sources map[string]string
+
+// Of return the source of the path, empty if the value is not set by any source
+func (s Sources) Of(path string) string {
+	return s[path]
+}
+
+// String list the path and the source in the sorted order, useful for the startup log
+func (s Sources) String() string {
+	paths := make([]string, 0, len(s))
+	for path := range s {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var sb strings.Builder
+	for _, path := range paths {
+		sb.WriteString(fmt.Sprintf("%s=%s\n", path, s[path]))
+	}
+	return sb.String()
+}
+
+type ConfigFile struct {
+	Path string
+
+	// Optional file is skipped when it does not exist
+	Optional bool
+}
+
+// LoadOption start from NewDefaultLoadOption then override the value with the setter.
+// The later source override the earlier one: defaults, files in order, env then flags.
+// The Secret is resolved after all the source is merged
+type LoadOption struct {
+
+	// Defaults is the struct with the same type as the target. Nil means the current value of the target
+	Defaults any
+
+	// Files is read by the extension .json, .yaml, .yml or .toml. The key is the json name of the field
+	Files []ConfigFile
+
+	// EnvPrefix enable the env like APP_CACHE_MASTER_NAME for "cache.masterName", empty to disable
+	EnvPrefix string
+
+	// Args enable the flag like -cache.master-name=x or -cache.addresses=a,b, usually os.Args[1:]. Nil to disable.
+	// The flag which is not in the config and the positional argument are ignored
+	Args []string
+
+	// Validate run Validate after all the source is merged
+	Validate bool
+}
+
+func NewDefaultLoadOption() LoadOption {
+	return LoadOption{
+		Files:     []ConfigFile{{Path: "config.json", Optional: true}},
+		EnvPrefix: "APP",
+		Validate:  true,
+	}
+}
+
+func (l LoadOption) SetDefaults(defaults any) LoadOption {
+	l.Defaults = defaults
+	return l
+}
+
+// SetFiles replace the files, use AddFile to keep the default config.json
+func (l LoadOption) SetFiles(files ...ConfigFile) LoadOption {
+	l.Files = files
+	return l
+}
+
+func (l LoadOption) AddFile(path string, optional bool) LoadOption {
+	l.Files = append(append([]ConfigFile{}, l.Files...), ConfigFile{Path: path, Optional: optional})
+	return l
+}
+
+func (l LoadOption) SetEnvPrefix(envPrefix string) LoadOption {
+	l.EnvPrefix = envPrefix
+	return l
+}
+
+func (l LoadOption) SetArgs(args []string) LoadOption {
+	l.Args = args
+	return l
+}
+
+func (l LoadOption) SetValidate(validate bool) LoadOption {
+	l.Validate = validate
+	return l
+}
+
+// LoadConfig load the Config from all the source
+//
+//	cfg, sources, err := config.LoadConfig(config.NewDefaultLoadOption().AddFile("config.local.yaml", true).SetArgs(os.Args[1:]))
+func LoadConfig(option LoadOption) (*Config, Sources, error) {
+	var cfg Config
+	sources, err := Load(&cfg, option)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &cfg, sources, nil
+}
+
+// Load merge all the source into the target which must be the pointer to the struct
+func Load(target any, option LoadOption) (Sources, error) {
+
+	targetValue := reflect.ValueOf(target)
+	if targetValue.Kind() != reflect.Pointer || targetValue.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config target must be the pointer to the struct")
+	}
+
+	leaves := collectLeaves(targetValue.Elem().Type(), nil)
+
+	tree := map[string]any{}
+	sources := Sources{}
+
+	defaults := option.Defaults
+	if defaults == nil {
+		defaults = target
+	}
+
+	defaultTree, err := toTree(defaults)
+	if err != nil {
+		return nil, fmt.Errorf("defaults: %w", err)
+	}
+	// the plain text secret is redacted by the json so the original text is put back
+	walkSecrets(reflect.ValueOf(defaults), "", func(path string, secret Secret) {
+		if secret.source() != "" && !strings.Contains(path, "[") {
+			setPath(defaultTree, strings.Split(path, "."), secret.source())
+		}
+	})
+
+	mergeTree(tree, defaultTree, "", SourceDefault, sources)
+
+	for _, file := range option.Files {
+		fileTree, err := readFile(file.Path)
+		if os.IsNotExist(err) && file.Optional {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("file %s: %w", file.Path, err)
+		}
+		mergeTree(tree, fileTree, "", "file:"+file.Path, sources)
+	}
+
+	if option.EnvPrefix != "" {
+		for _, l := range leaves {
+			name := envName(option.EnvPrefix, l.path)
+
+			text, exist := os.LookupEnv(name)
+			if !exist {
+				continue
+			}
+
+			value, err := convertText(text, l.typ)
+			if err != nil {
+				return nil, fmt.Errorf("env %s: %w", name, err)
+			}
+
+			setPath(tree, l.path, value)
+			sources[strings.Join(l.path, ".")] = "env:" + name
+		}
+	}
+
+	if option.Args != nil {
+		err := applyFlags(tree, leaves, option.Args, sources)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bytes, err := json.Marshal(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(bytes, target)
+	if err != nil {
+		return nil, fmt.Errorf("decode config: %w", err)
+	}
+
+	err = ResolveSecrets(context.Background(), target)
+	if err != nil {
+		return sources, err
+	}
+
+	if option.Validate {
+		err = Validate(target)
+
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			for i, v := range validationErr.Violations {
+				validationErr.Violations[i].Source = sources.Of(trimIndex(v.Path))
+			}
+		}
+
+		if err != nil {
+			return sources, err
+		}
+	}
+
+	return sources, nil
+}
+
+// trimIndex remove the list index so "cache.addresses[1]" has the source of "cache.addresses"
+func trimIndex(path string) string {
+	if i := strings.IndexByte(path, '['); i >= 0 {
+		return path[:i]
+	}
+	return path
+}
+
+func applyFlags(tree map[string]any, leaves []leaf, args []string, sources Sources) error {
+
+	flagSet := flag.NewFlagSet("config", flag.ContinueOnError)
+	flagSet.SetOutput(io.Discard)
+
+	values := map[string]*textFlag{}
+	for _, l := range leaves {
+		name := flagName(l.path)
+		values[name] = &textFlag{isBool: l.typ.Kind() == reflect.Bool}
+		flagSet.Var(values[name], name, strings.Join(l.path, "."))
+	}
+
+	err := flagSet.Parse(configArgs(args, values))
+	if err != nil {
+		return fmt.Errorf("flag: %w", err)
+	}
+
+	var convertErr error
+	flagSet.Visit(func(f *flag.Flag) {
+		if convertErr != nil {
+			return
+		}
+
+		for _, l := range leaves {
+			if flagName(l.path) != f.Name {
+				continue
+			}
+
+			value, err := convertText(values[f.Name].text, l.typ)
+			if err != nil {
+				convertErr = fmt.Errorf("flag -%s: %w", f.Name, err)
+				return
+			}
+
+			setPath(tree, l.path, value)
+			sources[strings.Join(l.path, ".")] = "flag:-" + f.Name
+		}
+	})
+
+	return convertErr
+}
+
+// configArgs take only the config flag and its value from the args so the application can have its own flag and argument.
+// The other flag and the positional argument are ignored, "--" stop the parsing like the flag package
+func configArgs(args []string, values map[string]*textFlag) []string {
+
+	result := make([]string, 0, len(args))
+
+	for i := 0; i < len(args); i++ {
+
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+
+		if len(arg) < 2 || arg[0] != '-' {
+			continue
+		}
+
+		name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		name, _, hasValue := strings.Cut(name, "=")
+
+		value, exist := values[name]
+		if !exist {
+			continue
+		}
+
+		result = append(result, arg)
+
+		if !hasValue && !value.isBool && i+1 < len(args) {
+			i++
+			result = append(result, args[i])
+		}
+	}
+
+	return result
+}
+
+// textFlag keep the flag as the text, it is converted later by the type of the field
+type textFlag struct {
+	text   string
+	isBool bool
+}
+
+func (f *textFlag) String() string {
+	return f.text
+}
+
+func (f *textFlag) Set(text string) error {
+	f.text = text
+	return nil
+}
+
+func (f *textFlag) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// leaf is the field which is set as one value by the env or the flag
+type leaf struct {
+	path []string
+	typ  reflect.Type
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+func collectLeaves(t reflect.Type, prefix []string) []leaf {
+
+	var leaves []leaf
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name, skip := jsonName(field)
+		if skip {
+			continue
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+
+		path := append(append([]string{}, prefix...), name)
+
+		isStruct := fieldType.Kind() == reflect.Struct && !reflect.PointerTo(fieldType).Implements(jsonUnmarshalerType)
+
+		if field.Anonymous && isStruct && field.Tag.Get("json") == "" {
+			leaves = append(leaves, collectLeaves(fieldType, prefix)...)
+			continue
+		}
+
+		if isStruct {
+			leaves = append(leaves, collectLeaves(fieldType, path)...)
+			continue
+		}
+
+		leaves = append(leaves, leaf{path: path, typ: fieldType})
+	}
+
+	return leaves
+}
+
+func jsonName(field reflect.StructField) (string, bool) {
+
+	if !field.IsExported() {
+		return "", true
+	}
+
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", true
+	}
+
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+
+	return name, false
+}
+
+// convertText convert the text from the env or the flag into the json value of the field type
+func convertText(text string, t reflect.Type) (any, error) {
+
+	// the secret is always the text even when it looks like the number
+	if t == secretType {
+		return text, nil
+	}
+
+	if reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+		var value any
+		if json.Unmarshal([]byte(text), &value) == nil {
+			return value, nil
+		}
+		return text, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return text, nil
+
+	case reflect.Bool:
+		return strconv.ParseBool(text)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(text, 10, 64)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(text, 10, 64)
+
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(text, 64)
+
+	case reflect.Slice, reflect.Array:
+		if strings.HasPrefix(strings.TrimSpace(text), "[") {
+			return decodeJSONText(text)
+		}
+
+		// the comma separated value like "a,b,c"
+		var values []any
+		for _, item := range strings.Split(text, ",") {
+			value, err := convertText(strings.TrimSpace(item), t.Elem())
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	}
+
+	return decodeJSONText(text)
+}
+
+func decodeJSONText(text string) (any, error) {
+	var value any
+	err := json.Unmarshal([]byte(text), &value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid json value %q", text)
+	}
+	return value, nil
+}
+
+func toTree(obj any) (map[string]any, error) {
+	bytes, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := map[string]any{}
+	err = json.Unmarshal(bytes, &tree)
+	if err != nil {
+		return nil, err
+	}
+
+	return tree, nil
+}
+
+func readFile(path string) (map[string]any, error) {
+
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := map[string]any{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(bytes, &tree)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(bytes, &tree)
+	case ".toml":
+		err = toml.Unmarshal(bytes, &tree)
+	default:
+		return nil, fmt.Errorf("unsupported config file extension %s", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return tree, nil
+}
+
+// mergeTree put the src into the dst, the nested map is merged and the other value is replaced
+func mergeTree(dst, src map[string]any, prefix, source string, sources Sources) {
+	for key, value := range src {
+
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		srcMap, srcIsMap := value.(map[string]any)
+		if !srcIsMap {
+			dst[key] = value
+			sources[path] = source
+			continue
+		}
+
+		dstMap, dstIsMap := dst[key].(map[string]any)
+		if !dstIsMap {
+			dstMap = map[string]any{}
+			dst[key] = dstMap
+		}
+
+		mergeTree(dstMap, srcMap, path, source, sources)
+	}
+}
+
+func setPath(tree map[string]any, path []string, value any) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := tree[key].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			tree[key] = next
+		}
+		tree = next
+	}
+	tree[path[len(path)-1]] = value
+}
+
+// envName convert the path ["cache", "masterName"] into APP_CACHE_MASTER_NAME
+func envName(prefix string, path []string) string {
+	parts := []string{prefix}
+	for _, key := range path {
+		parts = append(parts, strings.ToUpper(splitWords(key, "_")))
+	}
+	return strings.Join(parts, "_")
+}
+
+// flagName convert the path ["cache", "masterName"] into cache.master-name
+func flagName(path []string) string {
+	parts := make([]string, len(path))
+	for i, key := range path {
+		parts[i] = strings.ToLower(splitWords(key, "-"))
+	}
+	return strings.Join(parts, ".")
+}
+
+// splitWords put the separator between the camel case word, "minIdleConns" become "min_Idle_Conns"
+func splitWords(s string, separator string) string {
+	var sb strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])) {
+			sb.WriteString(separator)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
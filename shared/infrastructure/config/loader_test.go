@@ -0,0 +1,254 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFiles write the files into the temp dir and return the path by the name
+func writeFiles(t *testing.T, files map[string]string) map[string]string {
+	dir := t.TempDir()
+	paths := map[string]string{}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		paths[name] = path
+	}
+	return paths
+}
+
+func TestLoad(t *testing.T) {
+
+	testCases := []struct {
+		name     string
+		defaults *Config
+		files    map[string]string
+		order    []string
+		optional []string
+		env      map[string]string
+		args     []string
+		check    func(t *testing.T, cfg *Config, sources Sources, paths map[string]string)
+		wantErr  string
+	}{
+		{
+			name:     "defaults",
+			defaults: &Config{Cache: Cache{Address: "localhost:6379"}},
+			check: func(t *testing.T, cfg *Config, sources Sources, paths map[string]string) {
+				expect(t, cfg.Cache.Address, "localhost:6379")
+				expect(t, sources.Of("cache.address"), SourceDefault)
+			},
+		},
+		{
+			name:     "the later file override the earlier one",
+			defaults: &Config{Cache: Cache{Address: "localhost:6379", PoolSize: 1}},
+			files: map[string]string{
+				"a.json": `{"cache": {"address": "a:6379", "poolSize": 5}}`,
+				"b.yaml": "cache:\n  address: b:6379\n",
+				"c.toml": "[server]\nport = 8080\n",
+			},
+			order: []string{"a.json", "b.yaml", "c.toml"},
+			check: func(t *testing.T, cfg *Config, sources Sources, paths map[string]string) {
+				expect(t, cfg.Cache.Address, "b:6379")
+				expect(t, cfg.Cache.PoolSize, 5)
+				expect(t, cfg.Server.Port, 8080)
+				expect(t, sources.Of("cache.address"), "file:"+paths["b.yaml"])
+				expect(t, sources.Of("cache.poolSize"), "file:"+paths["a.json"])
+				expect(t, sources.Of("server.port"), "file:"+paths["c.toml"])
+			},
+		},
+		{
+			name:     "optional file is skipped",
+			order:    []string{"missing.json"},
+			optional: []string{"missing.json"},
+			check: func(t *testing.T, cfg *Config, sources Sources, paths map[string]string) {
+				expect(t, cfg.Cache.Address, "")
+				expect(t, sources.Of("cache.address"), "")
+			},
+		},
+		{
+			name:    "required file is missing",
+			order:   []string{"missing.json"},
+			wantErr: "missing.json",
+		},
+		{
+			name:  "env override the file",
+			files: map[string]string{"a.json": `{"cache": {"poolSize": 5, "dialTimeout": "1s"}}`},
+			order: []string{"a.json"},
+			env: map[string]string{
+				"TESTAPP_CACHE_POOL_SIZE":    "7",
+				"TESTAPP_CACHE_ADDRESSES":    "a:26379, b:26379",
+				"TESTAPP_CACHE_DIAL_TIMEOUT": "5s",
+				"TESTAPP_CACHE_MASTER_NAME":  "main",
+				"TESTAPP_CACHE_MODE":         "sentinel",
+			},
+			check: func(t *testing.T, cfg *Config, sources Sources, paths map[string]string) {
+				expect(t, cfg.Cache.PoolSize, 7)
+				expect(t, cfg.Cache.Addresses, []string{"a:26379", "b:26379"})
+				expect(t, cfg.Cache.DialTimeout.Duration(), 5*time.Second)
+				expect(t, cfg.Cache.MasterName, "main")
+				expect(t, sources.Of("cache.poolSize"), "env:TESTAPP_CACHE_POOL_SIZE")
+				expect(t, sources.Of("cache.masterName"), "env:TESTAPP_CACHE_MASTER_NAME")
+			},
+		},
+		{
+			name:    "env with the wrong type",
+			env:     map[string]string{"TESTAPP_CACHE_POOL_SIZE": "many"},
+			wantErr: "env TESTAPP_CACHE_POOL_SIZE",
+		},
+		{
+			name: "flag override the env and the unknown flag is ignored",
+			env:  map[string]string{"TESTAPP_CACHE_POOL_SIZE": "7"},
+			args: []string{"serve", "-verbose", "--cache.pool-size=9", "-cache.addresses", "a:7000,b:7000", "-cache.tls.enabled", "--", "-server.port=1"},
+			check: func(t *testing.T, cfg *Config, sources Sources, paths map[string]string) {
+				expect(t, cfg.Cache.PoolSize, 9)
+				expect(t, cfg.Cache.Addresses, []string{"a:7000", "b:7000"})
+				expect(t, cfg.Cache.TLS.Enabled, true)
+				expect(t, cfg.Server.Port, 0)
+				expect(t, sources.Of("cache.poolSize"), "flag:-cache.pool-size")
+				expect(t, sources.Of("cache.tls.enabled"), "flag:-cache.tls.enabled")
+			},
+		},
+		{
+			name: "numeric secret from the env is the text",
+			env:  map[string]string{"TESTAPP_DATABASE_PASSWORD": "1234"},
+			check: func(t *testing.T, cfg *Config, sources Sources, paths map[string]string) {
+				expect(t, cfg.Database.Password.Value(), "1234")
+			},
+		},
+		{
+			name:  "secret reference is resolved and kept as the reference",
+			files: map[string]string{"a.json": `{"database": {"password": "env:TESTAPP_SECRET_DB"}}`},
+			order: []string{"a.json"},
+			env:   map[string]string{"TESTAPP_SECRET_DB": "s3cret"},
+			check: func(t *testing.T, cfg *Config, sources Sources, paths map[string]string) {
+				expect(t, cfg.Database.Password.Value(), "s3cret")
+
+				bytes, err := json.Marshal(cfg.Database)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if strings.Contains(string(bytes), "s3cret") || !strings.Contains(string(bytes), "env:TESTAPP_SECRET_DB") {
+					t.Fatalf("marshaled secret is %s", bytes)
+				}
+			},
+		},
+		{
+			name:     "plain text secret of the defaults survive the redaction",
+			defaults: &Config{Database: Database{Password: NewSecret("plain")}},
+			check: func(t *testing.T, cfg *Config, sources Sources, paths map[string]string) {
+				expect(t, cfg.Database.Password.Value(), "plain")
+			},
+		},
+		{
+			name:    "unresolved secret reference",
+			files:   map[string]string{"a.json": `{"database": {"password": "env:TESTAPP_SECRET_MISSING"}}`},
+			order:   []string{"a.json"},
+			wantErr: "secret database.password",
+		},
+		{
+			name:    "validation error has the source",
+			files:   map[string]string{"a.json": `{"cache": {"database": 20}}`},
+			order:   []string{"a.json"},
+			wantErr: "cache.database must be at most 15 (from file:",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			for name, value := range tc.env {
+				t.Setenv(name, value)
+			}
+
+			paths := writeFiles(t, tc.files)
+
+			option := NewDefaultLoadOption().SetEnvPrefix("TESTAPP").SetFiles().SetArgs(tc.args)
+			if tc.defaults != nil {
+				option = option.SetDefaults(tc.defaults)
+			}
+
+			dir := t.TempDir()
+			for _, name := range tc.order {
+				path, exist := paths[name]
+				if !exist {
+					path = filepath.Join(dir, name)
+				}
+				optional := false
+				for _, o := range tc.optional {
+					optional = optional || o == name
+				}
+				option = option.AddFile(path, optional)
+			}
+
+			cfg, sources, err := LoadConfig(option)
+
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("error is %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tc.check(t, cfg, sources, paths)
+		})
+	}
+}
+
+func TestLoadValidationErrorHasAllViolations(t *testing.T) {
+
+	paths := writeFiles(t, map[string]string{"a.json": `{"cache": {"database": 20, "mode": "ring"}}`})
+
+	_, _, err := LoadConfig(NewDefaultLoadOption().SetEnvPrefix("").SetFiles(ConfigFile{Path: paths["a.json"]}))
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || len(validationErr.Violations) != 2 {
+		t.Fatalf("error is %v", err)
+	}
+
+	for _, v := range validationErr.Violations {
+		expect(t, v.Source, "file:"+paths["a.json"])
+	}
+}
+
+func TestReadConfigDoesNotUseTheEnv(t *testing.T) {
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"server": {"port": 8080}, "cache": {"database": 20}}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.Chdir(wd)
+	}()
+
+	t.Setenv("APP_SERVER_PORT", "9090")
+
+	// the invalid value is not validated and the env is not applied
+	cfg := ReadConfig()
+	expect(t, cfg.Server.Port, 8080)
+	expect(t, cfg.Cache.Database, 20)
+}
+
+func expect(t *testing.T, actual, expected any) {
+	t.Helper()
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("value is %v, want %v", actual, expected)
+	}
+}
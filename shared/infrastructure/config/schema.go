@@ -0,0 +1,186 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// durationPattern is the text accepted by time.ParseDuration
+const durationPattern = `^-?([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+
+// JSONSchema export the JSON Schema of the config struct from the json and the validate tag.
+// It can be given to the editor to check the config file
+//
+//	bytes, err := config.JSONSchema(config.Config{})
+//	err = os.WriteFile("config.schema.json", bytes, 0644)
+func JSONSchema(obj any) ([]byte, error) {
+
+	schema := schemaOf(reflect.TypeOf(obj))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func schemaOf(t reflect.Type) map[string]any {
+
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == secretType {
+		return map[string]any{
+			"type":        "string",
+			"description": "plain text or the reference like env:NAME or file:/run/secrets/name",
+		}
+	}
+
+	if t == durationType {
+		return map[string]any{
+			"type":        []string{"string", "integer"},
+			"pattern":     durationPattern,
+			"description": "duration like 5s or the number of millisecond",
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return structSchema(t)
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	}
+
+	return map[string]any{}
+}
+
+func structSchema(t reflect.Type) map[string]any {
+
+	properties := map[string]any{}
+	required := []string{}
+	var conditions []any
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name, skip := jsonName(field)
+		if skip {
+			continue
+		}
+
+		property := schemaOf(field.Type)
+
+		for _, r := range parseRules(field.Tag.Get("validate")) {
+			switch r.name {
+			case "required":
+				required = append(required, name)
+				if field.Type.Kind() == reflect.String {
+					property["minLength"] = 1
+				}
+
+			case "required_if":
+				sibling, expected, _ := strings.Cut(r.param, " ")
+				conditions = append(conditions, map[string]any{
+					"if":   map[string]any{"properties": map[string]any{sibling: map[string]any{"const": expected}}, "required": []string{sibling}},
+					"then": map[string]any{"required": []string{name}},
+				})
+
+			case "required_with":
+				conditions = append(conditions, map[string]any{
+					"dependentRequired": map[string]any{r.param: []string{name}},
+				})
+
+			case "required_without":
+				conditions = append(conditions, map[string]any{
+					"anyOf": []any{
+						map[string]any{"required": []string{r.param}},
+						map[string]any{"required": []string{name}},
+					},
+				})
+
+			case "min", "max":
+				addRangeSchema(property, field.Type, r)
+
+			case "oneof":
+				itemSchema(property)["enum"] = strings.Fields(r.param)
+
+			case "hostport":
+				itemSchema(property)["pattern"] = `^.+:[0-9]{1,5}$`
+
+			case "url":
+				itemSchema(property)["format"] = "uri"
+			}
+		}
+
+		properties[name] = property
+	}
+
+	schema := map[string]any{
+		"type":       "object",
+		"properties": properties,
+	}
+
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	if len(conditions) > 0 {
+		schema["allOf"] = conditions
+	}
+
+	return schema
+}
+
+// itemSchema return the schema of the item for the list because the rule is checked for every item
+func itemSchema(property map[string]any) map[string]any {
+	if items, ok := property["items"].(map[string]any); ok {
+		return items
+	}
+	return property
+}
+
+func addRangeSchema(property map[string]any, t reflect.Type, r rule) {
+
+	keywords := map[reflect.Kind][2]string{
+		reflect.String: {"minLength", "maxLength"},
+		reflect.Slice:  {"minItems", "maxItems"},
+		reflect.Array:  {"minItems", "maxItems"},
+		reflect.Map:    {"minProperties", "maxProperties"},
+	}
+
+	keyword, ok := keywords[t.Kind()]
+	if !ok {
+		keyword = [2]string{"minimum", "maximum"}
+	}
+
+	name := keyword[0]
+	if r.name == "max" {
+		name = keyword[1]
+	}
+
+	if t == durationType {
+		// only the number of millisecond can be checked by the schema
+		duration, err := time.ParseDuration(r.param)
+		if err == nil {
+			property[name] = duration.Milliseconds()
+		}
+		return
+	}
+
+	number, err := strconv.ParseFloat(r.param, 64)
+	if err == nil {
+		property[name] = number
+	}
+}
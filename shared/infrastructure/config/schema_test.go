@@ -0,0 +1,80 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden file")
+
+// TestJSONSchema compare the schema of the Config with the golden file, run with -update after the Config is changed
+func TestJSONSchema(t *testing.T) {
+
+	actual, err := JSONSchema(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual = append(actual, '\n')
+
+	golden := filepath.Join("testdata", "config.schema.json")
+
+	if *update {
+		if err := os.WriteFile(golden, actual, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(actual, expected) {
+		t.Fatalf("schema is changed, run go test -run TestJSONSchema -update and check the diff of %s", golden)
+	}
+}
+
+func TestJSONSchemaOfTheRule(t *testing.T) {
+
+	bytes, err := JSONSchema(validateTarget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schema struct {
+		Properties map[string]map[string]any `json:"properties"`
+		Required   []string                  `json:"required"`
+		AllOf      []map[string]any          `json:"allOf"`
+	}
+	if err := json.Unmarshal(bytes, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	properties := schema.Properties
+
+	expect(t, schema.Required, []string{"name", "primary"})
+	expect(t, properties["name"]["minLength"], float64(2))
+	expect(t, properties["name"]["maxLength"], float64(5))
+	expect(t, properties["mode"]["enum"], []any{"standalone", "sentinel"})
+	expect(t, properties["hosts"]["minItems"], float64(1))
+	expect(t, properties["hosts"]["items"].(map[string]any)["pattern"], `^.+:[0-9]{1,5}$`)
+	expect(t, properties["endpoint"]["format"], "uri")
+	expect(t, properties["ratio"]["minimum"], 0.5)
+	expect(t, properties["timeout"]["minimum"], float64(100))
+	expect(t, properties["timeout"]["maximum"], float64(60000))
+	expect(t, properties["password"]["type"], "string")
+	expect(t, properties["primary"]["required"], []any{"host"})
+	expect(t, properties["backup"]["type"], "object")
+
+	if _, exist := properties["Ignored"]; exist {
+		t.Fatal("the field with the json tag - is in the schema")
+	}
+
+	// required_if, required_with and required_without
+	expect(t, len(schema.AllOf), 3)
+	expect(t, schema.AllOf[1]["dependentRequired"], map[string]any{"username": []any{"password"}})
+}
@@ -0,0 +1,283 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+const redacted = "******"
+
+// ErrSecretNotResolved is returned when the reference is used before ResolveSecrets
+var ErrSecretNotResolved = errors.New("secret reference is not resolved")
+
+// SecretProvider resolve the reference like "vault:database/password" into the secret value.
+// The reference given to Resolve is the text after the scheme and the colon
+type SecretProvider interface {
+	Resolve(ctx context.Context, reference string) (string, error)
+}
+
+// SecretProviderFunc is the function as the SecretProvider
+type SecretProviderFunc func(ctx context.Context, reference string) (string, error)
+
+func (f SecretProviderFunc) Resolve(ctx context.Context, reference string) (string, error) {
+	return f(ctx, reference)
+}
+
+var secretProviderMutex sync.RWMutex
+
+var secretProviders = map[string]SecretProvider{
+	"env":  SecretProviderFunc(resolveEnvSecret),
+	"file": SecretProviderFunc(resolveFileSecret),
+}
+
+// RegisterSecretProvider add the provider for the scheme, the value "<scheme>:<reference>" is resolved by it
+func RegisterSecretProvider(scheme string, provider SecretProvider) {
+	secretProviderMutex.Lock()
+	defer secretProviderMutex.Unlock()
+	secretProviders[scheme] = provider
+}
+
+func getSecretProvider(scheme string) (SecretProvider, bool) {
+	secretProviderMutex.RLock()
+	defer secretProviderMutex.RUnlock()
+	provider, exist := secretProviders[scheme]
+	return provider, exist
+}
+
+func resolveEnvSecret(ctx context.Context, name string) (string, error) {
+	value, exist := os.LookupEnv(name)
+	if !exist {
+		return "", fmt.Errorf("env %s is not set", name)
+	}
+	return value, nil
+}
+
+// resolveFileSecret remove the trailing new line which is usually added by the editor and the secret mount
+func resolveFileSecret(ctx context.Context, path string) (string, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(bytes), "\r\n"), nil
+}
+
+type secretState struct {
+	mutex    sync.RWMutex
+	source   string
+	value    string
+	resolved bool
+}
+
+// Secret is the config value which can be the plain text or the reference like "env:DB_PASSWORD",
+// "file:/run/secrets/db_password" or the scheme added by RegisterSecretProvider.
+// The text is the reference whenever it start with the registered scheme and the colon, so the plain text
+// like "env:abc" can not be used as the secret, the text with the other scheme like "amqp://..." is the plain text.
+// It is printed and marshaled as the reference or the redacted text so it is safe to log the config.
+// The copy of the Secret share the value so RefreshSecrets is seen by all the copy
+type Secret struct {
+	state *secretState
+}
+
+// NewSecret create the Secret from the plain text or the reference, it is resolved by ResolveSecrets
+func NewSecret(source string) Secret {
+	return Secret{state: &secretState{source: source}}
+}
+
+// Value return the resolved secret and panic when the reference is not resolved, so the missing secret
+// is not used as the empty password. The plain text is returned as is even before it is resolved
+func (s Secret) Value() string {
+	value, err := s.Resolved()
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+// Resolved return the resolved secret or ErrSecretNotResolved when the reference is not resolved
+func (s Secret) Resolved() (string, error) {
+	if s.state == nil {
+		return "", nil
+	}
+
+	s.state.mutex.RLock()
+	defer s.state.mutex.RUnlock()
+
+	if s.state.resolved {
+		return s.state.value, nil
+	}
+
+	if _, _, isReference := s.reference(); isReference {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotResolved, s.state.source)
+	}
+
+	return s.state.source, nil
+}
+
+// IsZero is used by the required rule
+func (s Secret) IsZero() bool {
+	return s.state == nil || s.state.source == ""
+}
+
+func (s Secret) String() string {
+	if s.IsZero() {
+		return ""
+	}
+	if _, _, isReference := s.reference(); isReference {
+		return s.state.source
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON accept only the string, the numeric password must be quoted so its leading zero is not lost
+func (s *Secret) UnmarshalJSON(bytes []byte) error {
+
+	var text string
+	if err := json.Unmarshal(bytes, &text); err != nil {
+		return fmt.Errorf("secret must be the string: %s", bytes)
+	}
+
+	*s = NewSecret(text)
+
+	return nil
+}
+
+// reference split the source into the scheme and the reference when the scheme is registered
+func (s Secret) reference() (SecretProvider, string, bool) {
+	if s.state == nil {
+		return nil, "", false
+	}
+
+	scheme, reference, found := strings.Cut(s.state.source, ":")
+	if !found {
+		return nil, "", false
+	}
+
+	provider, exist := getSecretProvider(scheme)
+	if !exist {
+		return nil, "", false
+	}
+
+	return provider, reference, true
+}
+
+// source is the original text, it is used by Load to keep the plain text secret of the defaults
+func (s Secret) source() string {
+	if s.state == nil {
+		return ""
+	}
+	return s.state.source
+}
+
+func (s Secret) resolve(ctx context.Context) error {
+	if s.state == nil {
+		return nil
+	}
+
+	value := s.state.source
+
+	provider, reference, isReference := s.reference()
+	if isReference {
+		var err error
+		value, err = provider.Resolve(ctx, reference)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.state.mutex.Lock()
+	defer s.state.mutex.Unlock()
+
+	s.state.value = value
+	s.state.resolved = true
+
+	return nil
+}
+
+var secretType = reflect.TypeOf(Secret{})
+
+// ResolveSecrets resolve all the Secret in the config struct, it is called by Load
+func ResolveSecrets(ctx context.Context, obj any) error {
+	var errs []string
+
+	walkSecrets(reflect.ValueOf(obj), "", func(path string, secret Secret) {
+		if err := secret.resolve(ctx); err != nil {
+			errs = append(errs, fmt.Sprintf("secret %s: %v", path, err))
+		}
+	})
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+
+	return nil
+}
+
+// RefreshSecrets resolve the secret again, the value is changed in place so the holder of the config see the new one.
+// The secret which is failed to resolve keep the previous value
+//
+//	go func() {
+//		for range time.Tick(10 * time.Minute) {
+//			if err := config.RefreshSecrets(ctx, cfg); err != nil {
+//				log.Error(ctx, err.Error())
+//			}
+//		}
+//	}()
+func RefreshSecrets(ctx context.Context, obj any) error {
+	return ResolveSecrets(ctx, obj)
+}
+
+func walkSecrets(value reflect.Value, path string, fn func(path string, secret Secret)) {
+
+	if value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+
+	if value.Type() == secretType {
+		fn(path, value.Interface().(Secret))
+		return
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		t := value.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name, skip := jsonName(t.Field(i))
+			if skip {
+				continue
+			}
+			fieldPath := joinPath(path, name)
+			if t.Field(i).Anonymous && t.Field(i).Tag.Get("json") == "" {
+				fieldPath = path
+			}
+			walkSecrets(value.Field(i), fieldPath, fn)
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			walkSecrets(value.Index(i), fmt.Sprintf("%s[%d]", path, i), fn)
+		}
+
+	case reflect.Map:
+		iter := value.MapRange()
+		for iter.Next() {
+			walkSecrets(iter.Value(), joinPath(path, fmt.Sprint(iter.Key().Interface())), fn)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Store keep the active config and reload it when the config file is changed.
+// The invalid update is rejected and the previous config stay active
+//
+//	store, err := config.NewStore[config.Config](config.NewDefaultLoadOption())
+//	if err != nil {
+//		panic(err.Error())
+//	}
+//
+//	config.Subscribe(store, func(c *config.Config) config.Cache { return c.Cache }, func(old, new config.Cache) {
+//		// apply the new cache config
+//	})
+//
+//	go store.Watch(ctx, 5*time.Second)
+type Store[T any] struct {
+	option  LoadOption
+	current atomic.Pointer[T]
+	sources atomic.Pointer[Sources]
+
+	mutex       sync.Mutex
+	fingerprint string
+	subscribers map[int]func(old, new *T)
+	nextID      int
+	onError     func(err error)
+}
+
+// NewStore load the config once, it return the error when the first config is invalid
+func NewStore[T any](option LoadOption) (*Store[T], error) {
+
+	s := &Store[T]{
+		option:      option,
+		subscribers: map[int]func(old, new *T){},
+		onError:     func(err error) {},
+	}
+
+	err := s.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Get return the active config. Do not change it, the same value is shared by all the caller
+func (s *Store[T]) Get() *T {
+	return s.current.Load()
+}
+
+// Sources return the source of every value of the active config
+func (s *Store[T]) Sources() Sources {
+	return *s.sources.Load()
+}
+
+// OnError is called with the error from Watch, like the invalid update which is rejected
+func (s *Store[T]) OnError(fn func(err error)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onError = fn
+}
+
+// Reload load the config from all the source again. The subscriber is called only when the config is valid
+func (s *Store[T]) Reload() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fingerprint, err := s.readFingerprint(secretFiles(s.current.Load()))
+	if err != nil {
+		return err
+	}
+
+	return s.reload(fingerprint)
+}
+
+// reload must be called while holding the mutex so the subscriber is called in the order of the change.
+// The fingerprint is read before the load so the change during the load is found by the next check
+func (s *Store[T]) reload(fingerprint string) error {
+
+	next := new(T)
+	sources, err := Load(next, s.option)
+	if err != nil {
+		return err
+	}
+
+	// the new config refer to the other secret files, hash them now so the next check does not reload again
+	if files := secretFiles(next); !reflect.DeepEqual(files, secretFiles(s.current.Load())) {
+		fingerprint, err = s.readFingerprint(files)
+		if err != nil {
+			return err
+		}
+	}
+
+	previous := s.current.Swap(next)
+	s.sources.Store(&sources)
+	s.fingerprint = fingerprint
+
+	if previous == nil {
+		return nil
+	}
+
+	for _, fn := range s.subscribers {
+		s.notify(fn, previous, next)
+	}
+
+	return nil
+}
+
+// notify keep the other subscriber running when one of them panic
+func (s *Store[T]) notify(fn func(old, new *T), previous, next *T) {
+	defer func() {
+		if p := recover(); p != nil {
+			s.onError(fmt.Errorf("config subscriber panic: %v", p))
+		}
+	}()
+	fn(previous, next)
+}
+
+// Watch check the config files and the secret files referred by "file:" every interval and reload when one of them is changed,
+// it block until the ctx is done. The env and the other secret provider is read only by the reload,
+// call Reload or RefreshSecrets when they are changed
+func (s *Store[T]) Watch(ctx context.Context, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.mutex.Lock()
+
+		fingerprint, err := s.readFingerprint(secretFiles(s.current.Load()))
+		if err == nil && fingerprint != s.fingerprint {
+			err = s.reload(fingerprint)
+			if err != nil {
+				// remember the rejected content so the same error is not reported again on every tick
+				s.fingerprint = fingerprint
+				err = fmt.Errorf("config update is rejected: %w", err)
+			}
+		}
+
+		if err != nil {
+			s.onError(err)
+		}
+
+		s.mutex.Unlock()
+	}
+}
+
+// readFingerprint hash the content of all the config files and the secret files, the missing optional file is part of the hash
+func (s *Store[T]) readFingerprint(secretFiles []string) (string, error) {
+
+	hash := sha256.New()
+
+	for _, file := range s.option.Files {
+		bytes, err := os.ReadFile(file.Path)
+		if os.IsNotExist(err) && file.Optional {
+			hash.Write([]byte("missing:" + file.Path + "\n"))
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("file %s: %w", file.Path, err)
+		}
+		hash.Write([]byte(file.Path + "\n"))
+		hash.Write(bytes)
+	}
+
+	// the missing secret file is reported by the reload
+	for _, path := range secretFiles {
+		bytes, err := os.ReadFile(path)
+		if err != nil {
+			hash.Write([]byte("missing:" + path + "\n"))
+			continue
+		}
+		hash.Write([]byte(path + "\n"))
+		hash.Write(bytes)
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// secretFiles return the path of the "file:" secret in the config
+func secretFiles(cfg any) []string {
+
+	var paths []string
+
+	walkSecrets(reflect.ValueOf(cfg), "", func(path string, secret Secret) {
+		if filePath := strings.TrimPrefix(secret.source(), "file:"); filePath != secret.source() {
+			paths = append(paths, filePath)
+		}
+	})
+
+	return paths
+}
+
+func (s *Store[T]) subscribe(fn func(old, new *T)) func() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id := s.nextID
+	s.nextID++
+	s.subscribers[id] = fn
+
+	return func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		delete(s.subscribers, id)
+	}
+}
+
+// Subscribe call the fn when the section of the config is changed after the reload. It return the function to unsubscribe.
+// The fn is called while the store is locked so it must not call Reload or Subscribe
+//
+//	unsubscribe := config.Subscribe(store, func(c *config.Config) config.Server { return c.Server }, func(old, new config.Server) {
+//		log.Info(ctx, "port is changed from %d to %d", old.Port, new.Port)
+//	})
+func Subscribe[T, S any](store *Store[T], section func(cfg *T) S, fn func(old, new S)) func() {
+	return store.subscribe(func(previous, next *T) {
+		oldSection := section(previous)
+		newSection := section(next)
+		if !reflect.DeepEqual(oldSection, newSection) {
+			fn(oldSection, newSection)
+		}
+	})
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestStore create the store of the config file with the content, the env and the flag is not used
+func newTestStore(t *testing.T, content string) (*Store[Config], string) {
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfig(t, path, content)
+
+	store, err := NewStore[Config](NewDefaultLoadOption().SetEnvPrefix("").SetFiles(ConfigFile{Path: path}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store, path
+}
+
+// writeConfig replace the file by the rename so Watch never read the half written file
+func writeConfig(t *testing.T, path, content string) {
+	if err := os.WriteFile(path+".tmp", []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreReloadSwapTheConfig(t *testing.T) {
+
+	store, path := newTestStore(t, `{"server": {"port": 8080}}`)
+
+	previous := store.Get()
+	expect(t, previous.Server.Port, 8080)
+
+	writeConfig(t, path, `{"server": {"port": 9090}}`)
+
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the holder of the previous config does not see the change
+	expect(t, store.Get().Server.Port, 9090)
+	expect(t, previous.Server.Port, 8080)
+	expect(t, store.Sources().Of("server.port"), "file:"+path)
+}
+
+func TestStoreRejectTheInvalidUpdate(t *testing.T) {
+
+	store, path := newTestStore(t, `{"cache": {"mode": "sentinel", "addresses": ["a:26379"], "masterName": "main"}}`)
+
+	called := false
+	Subscribe(store, func(c *Config) Cache { return c.Cache }, func(old, new Cache) {
+		called = true
+	})
+
+	for _, content := range []string{`{"cache": {"mode": "ring"}}`, `{"cache": `} {
+		writeConfig(t, path, content)
+
+		if err := store.Reload(); err == nil {
+			t.Fatalf("invalid update %s is accepted", content)
+		}
+
+		expect(t, store.Get().Cache.Mode, "sentinel")
+	}
+
+	if called {
+		t.Fatal("subscriber is called for the rejected update")
+	}
+}
+
+func TestStoreSubscribeTheSection(t *testing.T) {
+
+	store, path := newTestStore(t, `{"server": {"port": 8080}, "cache": {"poolSize": 5}}`)
+
+	var serverChanges, cacheChanges []string
+
+	Subscribe(store, func(c *Config) Server { return c.Server }, func(old, new Server) {
+		serverChanges = append(serverChanges, fmt.Sprintf("%d->%d", old.Port, new.Port))
+	})
+
+	unsubscribe := Subscribe(store, func(c *Config) Cache { return c.Cache }, func(old, new Cache) {
+		cacheChanges = append(cacheChanges, fmt.Sprintf("%d->%d", old.PoolSize, new.PoolSize))
+	})
+
+	steps := []string{
+		// only the cache is changed
+		`{"server": {"port": 8080}, "cache": {"poolSize": 10}}`,
+		// only the server is changed
+		`{"server": {"port": 9090}, "cache": {"poolSize": 10}}`,
+		// nothing is changed
+		`{"cache": {"poolSize": 10}, "server": {"port": 9090}}`,
+	}
+
+	for _, content := range steps {
+		writeConfig(t, path, content)
+		if err := store.Reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	unsubscribe()
+
+	writeConfig(t, path, `{"server": {"port": 9090}, "cache": {"poolSize": 20}}`)
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, serverChanges, []string{"8080->9090"})
+	expect(t, cacheChanges, []string{"5->10"})
+}
+
+func TestStoreWatchTheConfigAndTheSecretFile(t *testing.T) {
+
+	dir := t.TempDir()
+	secretPath := filepath.Join(dir, "password")
+	writeConfig(t, secretPath, "v1")
+
+	store, path := newTestStore(t, `{"database": {"password": "file:`+secretPath+`"}}`)
+
+	var mutex sync.Mutex
+	var passwords []string
+	var errs []error
+
+	Subscribe(store, func(c *Config) Database { return c.Database }, func(old, new Database) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		passwords = append(passwords, new.Password.Value())
+	})
+
+	store.OnError(func(err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		errs = append(errs, err)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go store.Watch(ctx, 5*time.Millisecond)
+
+	lastPassword := func() string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if len(passwords) == 0 {
+			return ""
+		}
+		return passwords[len(passwords)-1]
+	}
+
+	// the secret file is changed without changing the config file
+	writeConfig(t, secretPath, "v2")
+	waitUntil(t, func() bool { return lastPassword() == "v2" })
+
+	// the invalid update is reported once and the config is kept
+	writeConfig(t, path, `{"database": {"password": "file:`+secretPath+`"}, "cache": {"mode": "ring"}}`)
+	waitUntil(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(errs) > 0
+	})
+
+	time.Sleep(30 * time.Millisecond)
+
+	mutex.Lock()
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "config update is rejected") {
+		t.Fatalf("errors are %v", errs)
+	}
+	mutex.Unlock()
+
+	expect(t, store.Get().Cache.Mode, "")
+	expect(t, store.Get().Database.Password.Value(), "v2")
+
+	// the config file refer to the other secret file
+	otherPath := filepath.Join(dir, "other-password")
+	writeConfig(t, otherPath, "v3")
+	writeConfig(t, path, `{"database": {"password": "file:`+otherPath+`"}}`)
+	waitUntil(t, func() bool { return lastPassword() == "v3" })
+
+	writeConfig(t, otherPath, "v4")
+	waitUntil(t, func() bool { return lastPassword() == "v4" })
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	expect(t, passwords, []string{"v2", "v3", "v4"})
+}
+
+func waitUntil(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition is not reached in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
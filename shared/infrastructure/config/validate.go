@@ -0,0 +1,340 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The rule is written in the validate tag and separated by the comma
+//
+//	Port   int      `json:"port" validate:"required,min=1,max=65535"`
+//	Mode   string   `json:"mode" validate:"oneof=standalone sentinel cluster"`
+//	Hosts  []string `json:"hosts" validate:"min=1,hostport"`
+//	Master string   `json:"master" validate:"required_if=mode sentinel"`
+//
+// required                  the value is not zero
+// required_if=<field> <v>   required when the sibling field has the value v
+// required_with=<field>     required when the sibling field is set
+// required_without=<field>  required when the sibling field is not set
+// min=<n>, max=<n>          the number range or the length of the string and the list, "5s" for the Duration
+// oneof=<a> <b>             one of the value, the empty value is allowed unless it is required
+// hostport                  the "host:port" address
+// url                       the absolute url
+//
+// The sibling field is the json name. The hostport, url and oneof rule is checked for every item of the list.
+// The rule which can not be written in the tag is put in the Validate method of the struct
+
+// Violation is one invalid value, the Path is the dotted json path like "cache.addresses[1]"
+type Violation struct {
+	Path    string `json:"path"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+
+	// Source is where the invalid value come from, it is filled by Load
+	Source string `json:"source,omitempty"`
+}
+
+func (v Violation) String() string {
+	if v.Source != "" {
+		return fmt.Sprintf("%s %s (from %s)", v.Path, v.Message, v.Source)
+	}
+	return fmt.Sprintf("%s %s", v.Path, v.Message)
+}
+
+// ValidationError has all the violations of the config
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		lines = append(lines, "  "+v.String())
+	}
+	return fmt.Sprintf("config has %d invalid value:\n%s", len(e.Violations), strings.Join(lines, "\n"))
+}
+
+// Validator is implemented by the struct which has the cross field rule. The Path is relative to the struct
+type Validator interface {
+	Validate() []Violation
+}
+
+// Validate check the validate tag of the struct and return the *ValidationError with all the violations
+func Validate(obj any) error {
+
+	value := reflect.Indirect(reflect.ValueOf(obj))
+	if value.Kind() != reflect.Struct {
+		return fmt.Errorf("config must be the struct")
+	}
+
+	var violations []Violation
+	validateStruct(value, "", &violations)
+
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+
+	return nil
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+func joinPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func validateStruct(value reflect.Value, prefix string, violations *[]Violation) {
+
+	t := value.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name, skip := jsonName(field)
+		if skip {
+			continue
+		}
+
+		fieldValue := value.Field(i)
+
+		path := joinPath(prefix, name)
+		if field.Anonymous && field.Tag.Get("json") == "" {
+			path = prefix
+		}
+
+		for _, r := range parseRules(field.Tag.Get("validate")) {
+			found := r.check(fieldValue, value, path)
+			*violations = append(*violations, found...)
+
+			// the missing value does not need the other rule
+			if len(found) > 0 && strings.HasPrefix(r.name, "required") {
+				break
+			}
+		}
+
+		validateNested(fieldValue, path, violations)
+	}
+
+	if validator, ok := asValidator(value); ok {
+		for _, v := range validator.Validate() {
+			v.Path = joinPath(prefix, v.Path)
+			*violations = append(*violations, v)
+		}
+	}
+}
+
+func validateNested(value reflect.Value, path string, violations *[]Violation) {
+
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		if !reflect.PointerTo(value.Type()).Implements(jsonUnmarshalerType) {
+			validateStruct(value, path, violations)
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			validateNested(value.Index(i), fmt.Sprintf("%s[%d]", path, i), violations)
+		}
+	}
+}
+
+func asValidator(value reflect.Value) (Validator, bool) {
+	if value.CanAddr() {
+		if validator, ok := value.Addr().Interface().(Validator); ok {
+			return validator, true
+		}
+	}
+	validator, ok := value.Interface().(Validator)
+	return validator, ok
+}
+
+type rule struct {
+	name  string
+	param string
+}
+
+func parseRules(tag string) []rule {
+	var rules []rule
+	for _, text := range strings.Split(tag, ",") {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		name, param, _ := strings.Cut(text, "=")
+		rules = append(rules, rule{name: name, param: param})
+	}
+	return rules
+}
+
+func (r rule) violation(path, format string, args ...any) Violation {
+	return Violation{Path: path, Rule: r.name, Message: fmt.Sprintf(format, args...)}
+}
+
+func (r rule) check(value, parent reflect.Value, path string) []Violation {
+
+	switch r.name {
+	case "required":
+		if isZero(value) {
+			return []Violation{r.violation(path, "is required")}
+		}
+
+	case "required_if":
+		siblingName, expected, _ := strings.Cut(r.param, " ")
+		sibling, ok := fieldByJSONName(parent, siblingName)
+		if ok && fmt.Sprint(sibling.Interface()) == expected && isZero(value) {
+			return []Violation{r.violation(path, "is required when %s is %s", siblingName, expected)}
+		}
+
+	case "required_with":
+		sibling, ok := fieldByJSONName(parent, r.param)
+		if ok && !isZero(sibling) && isZero(value) {
+			return []Violation{r.violation(path, "is required when %s is set", r.param)}
+		}
+
+	case "required_without":
+		sibling, ok := fieldByJSONName(parent, r.param)
+		if ok && isZero(sibling) && isZero(value) {
+			return []Violation{r.violation(path, "is required when %s is not set", r.param)}
+		}
+
+	case "min", "max":
+		return r.checkRange(value, path)
+
+	case "oneof", "hostport", "url":
+		return r.checkEach(value, path)
+
+	default:
+		return []Violation{r.violation(path, "has unknown validate rule %s", r.name)}
+	}
+
+	return nil
+}
+
+func (r rule) checkRange(value reflect.Value, path string) []Violation {
+
+	var actual, limit float64
+	var unit string
+
+	switch value.Kind() {
+	case reflect.String:
+		actual = float64(utf8.RuneCountInString(value.String()))
+		unit = " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		actual = float64(value.Len())
+		unit = " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		actual = float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		actual = float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		actual = value.Float()
+	default:
+		return []Violation{r.violation(path, "does not support the %s rule", r.name)}
+	}
+
+	if value.Type() == durationType {
+		duration, err := time.ParseDuration(r.param)
+		if err != nil {
+			return []Violation{r.violation(path, "has invalid %s duration %s", r.name, r.param)}
+		}
+		limit = float64(duration)
+	} else {
+		number, err := strconv.ParseFloat(r.param, 64)
+		if err != nil {
+			return []Violation{r.violation(path, "has invalid %s number %s", r.name, r.param)}
+		}
+		limit = number
+	}
+
+	if r.name == "min" && actual < limit {
+		return []Violation{r.violation(path, "must be at least %s%s", r.param, unit)}
+	}
+
+	if r.name == "max" && actual > limit {
+		return []Violation{r.violation(path, "must be at most %s%s", r.param, unit)}
+	}
+
+	return nil
+}
+
+// checkEach check the single value or every item of the list. The empty value is left to the required rule
+func (r rule) checkEach(value reflect.Value, path string) []Violation {
+
+	if value.Kind() == reflect.Slice || value.Kind() == reflect.Array {
+		var violations []Violation
+		for i := 0; i < value.Len(); i++ {
+			violations = append(violations, r.checkEach(value.Index(i), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return violations
+	}
+
+	if value.IsZero() {
+		return nil
+	}
+
+	text := fmt.Sprint(value.Interface())
+
+	switch r.name {
+	case "oneof":
+		options := strings.Fields(r.param)
+		for _, option := range options {
+			if text == option {
+				return nil
+			}
+		}
+		return []Violation{r.violation(path, "must be one of %s but got %q", strings.Join(options, ", "), text)}
+
+	case "hostport":
+		host, port, err := net.SplitHostPort(text)
+		if err != nil || host == "" {
+			return []Violation{r.violation(path, "must be host:port but got %q", text)}
+		}
+		number, err := strconv.Atoi(port)
+		if err != nil || number < 1 || number > 65535 {
+			return []Violation{r.violation(path, "has invalid port in %q", text)}
+		}
+
+	case "url":
+		u, err := url.Parse(text)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return []Violation{r.violation(path, "must be the absolute url but got %q", text)}
+		}
+	}
+
+	return nil
+}
+
+// isZero use the IsZero method of the type like Secret before the zero value of the go type
+func isZero(value reflect.Value) bool {
+	if zeroer, ok := value.Interface().(interface{ IsZero() bool }); ok {
+		return zeroer.IsZero()
+	}
+	return value.IsZero()
+}
+
+func fieldByJSONName(parent reflect.Value, name string) (reflect.Value, bool) {
+	t := parent.Type()
+	for i := 0; i < t.NumField(); i++ {
+		fieldName, skip := jsonName(t.Field(i))
+		if !skip && fieldName == name {
+			return parent.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
@@ -0,0 +1,214 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type validateAddress struct {
+	Host string `json:"host" validate:"required"`
+	Port int    `json:"port" validate:"min=1,max=65535"`
+}
+
+type validateTarget struct {
+	Name     string            `json:"name,omitempty" validate:"required,min=2,max=5"`
+	Mode     string            `json:"mode,omitempty" validate:"oneof=standalone sentinel"`
+	Master   string            `json:"master,omitempty" validate:"required_if=mode sentinel"`
+	Username string            `json:"username,omitempty"`
+	Password Secret            `json:"password,omitempty" validate:"required_with=username"`
+	Token    string            `json:"token,omitempty" validate:"required_without=password"`
+	Hosts    []string          `json:"hosts,omitempty" validate:"min=1,max=2,hostport"`
+	Endpoint string            `json:"endpoint,omitempty" validate:"url"`
+	Ratio    float64           `json:"ratio,omitempty" validate:"min=0.5,max=1"`
+	Timeout  Duration          `json:"timeout,omitempty" validate:"min=100ms,max=1m"`
+	Primary  validateAddress   `json:"primary" validate:"required"`
+	Replicas []validateAddress `json:"replicas,omitempty"`
+	Backup   *validateAddress  `json:"backup,omitempty"`
+	Ignored  string            `json:"-" validate:"required"`
+}
+
+// validTarget return the target without the violation, the test case break one field of it
+func validTarget() validateTarget {
+	return validateTarget{
+		Name:     "order",
+		Mode:     "standalone",
+		Token:    "t",
+		Hosts:    []string{"a:6379"},
+		Endpoint: "https://example.com/api",
+		Ratio:    0.8,
+		Timeout:  Duration(5 * time.Second),
+		Primary:  validateAddress{Host: "a", Port: 1},
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	type violation struct{ path, rule string }
+
+	testCases := []struct {
+		name   string
+		change func(v *validateTarget)
+		want   []violation
+	}{
+		{name: "valid", change: func(v *validateTarget) {}},
+		{name: "required", change: func(v *validateTarget) { v.Name = "" }, want: []violation{{"name", "required"}}},
+		{name: "min of the string", change: func(v *validateTarget) { v.Name = "a" }, want: []violation{{"name", "min"}}},
+		{name: "max of the string", change: func(v *validateTarget) { v.Name = "abcdef" }, want: []violation{{"name", "max"}}},
+		{name: "oneof", change: func(v *validateTarget) { v.Mode = "cluster" }, want: []violation{{"mode", "oneof"}}},
+		{name: "oneof allow the empty value", change: func(v *validateTarget) { v.Mode = "" }},
+		{
+			name:   "required_if is missing",
+			change: func(v *validateTarget) { v.Mode = "sentinel" },
+			want:   []violation{{"master", "required_if"}},
+		},
+		{name: "required_if is set", change: func(v *validateTarget) { v.Mode = "sentinel"; v.Master = "main" }},
+		{
+			name:   "required_with is missing",
+			change: func(v *validateTarget) { v.Username = "admin" },
+			want:   []violation{{"password", "required_with"}},
+		},
+		{
+			name:   "required_with is set",
+			change: func(v *validateTarget) { v.Username = "admin"; v.Password = NewSecret("p") },
+		},
+		{
+			name:   "required_without is missing",
+			change: func(v *validateTarget) { v.Token = "" },
+			want:   []violation{{"token", "required_without"}},
+		},
+		{name: "required_without is not needed", change: func(v *validateTarget) { v.Token = ""; v.Password = NewSecret("p") }},
+		{name: "min of the list", change: func(v *validateTarget) { v.Hosts = nil }, want: []violation{{"hosts", "min"}}},
+		{
+			name:   "max of the list",
+			change: func(v *validateTarget) { v.Hosts = []string{"a:1", "b:2", "c:3"} },
+			want:   []violation{{"hosts", "max"}},
+		},
+		{
+			name:   "hostport of every item",
+			change: func(v *validateTarget) { v.Hosts = []string{"a", "b:70000"} },
+			want:   []violation{{"hosts[0]", "hostport"}, {"hosts[1]", "hostport"}},
+		},
+		{name: "url", change: func(v *validateTarget) { v.Endpoint = "/api" }, want: []violation{{"endpoint", "url"}}},
+		{name: "min of the number", change: func(v *validateTarget) { v.Ratio = 0.1 }, want: []violation{{"ratio", "min"}}},
+		{name: "max of the number", change: func(v *validateTarget) { v.Ratio = 1.5 }, want: []violation{{"ratio", "max"}}},
+		{
+			name:   "min of the duration",
+			change: func(v *validateTarget) { v.Timeout = Duration(50 * time.Millisecond) },
+			want:   []violation{{"timeout", "min"}},
+		},
+		{
+			name:   "max of the duration",
+			change: func(v *validateTarget) { v.Timeout = Duration(2 * time.Minute) },
+			want:   []violation{{"timeout", "max"}},
+		},
+		{
+			name:   "required nested struct report the missing struct and its field",
+			change: func(v *validateTarget) { v.Primary = validateAddress{} },
+			want:   []violation{{"primary", "required"}, {"primary.host", "required"}, {"primary.port", "min"}},
+		},
+		{
+			name:   "nested struct",
+			change: func(v *validateTarget) { v.Primary.Port = 70000 },
+			want:   []violation{{"primary.port", "max"}},
+		},
+		{
+			name:   "struct in the list",
+			change: func(v *validateTarget) { v.Replicas = []validateAddress{{Host: "b", Port: 1}, {Port: 1}} },
+			want:   []violation{{"replicas[1].host", "required"}},
+		},
+		{
+			name:   "struct pointer",
+			change: func(v *validateTarget) { v.Backup = &validateAddress{Host: "c"} },
+			want:   []violation{{"backup.port", "min"}},
+		},
+		{
+			name:   "all the violations are returned",
+			change: func(v *validateTarget) { v.Name = ""; v.Mode = "cluster"; v.Endpoint = "x" },
+			want:   []violation{{"name", "required"}, {"mode", "oneof"}, {"endpoint", "url"}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+
+			target := validTarget()
+			testCase.change(&target)
+
+			err := Validate(&target)
+
+			var got []violation
+			var validationErr *ValidationError
+			if errors.As(err, &validationErr) {
+				for _, v := range validationErr.Violations {
+					got = append(got, violation{v.Path, v.Rule})
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			expect(t, got, testCase.want)
+		})
+	}
+}
+
+type validateInvalidRule struct {
+	Port    int      `json:"port" validate:"min=one"`
+	Timeout Duration `json:"timeout" validate:"max=soon"`
+	Enabled bool     `json:"enabled" validate:"min=1"`
+	Name    string   `json:"name" validate:"unique"`
+}
+
+func TestValidateInvalidRule(t *testing.T) {
+
+	err := Validate(validateInvalidRule{})
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("validate return %v", err)
+	}
+
+	var messages []string
+	for _, v := range validationErr.Violations {
+		messages = append(messages, v.String())
+	}
+
+	expect(t, messages, []string{
+		"port has invalid min number one",
+		"timeout has invalid max duration soon",
+		"enabled does not support the min rule",
+		"name has unknown validate rule unique",
+	})
+
+	if err := Validate("text"); err == nil {
+		t.Fatal("validate of the string is accepted")
+	}
+}
+
+type validateCrossField struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+func (v validateCrossField) Validate() []Violation {
+	if v.Min > v.Max {
+		return []Violation{{Path: "min", Rule: "range", Message: "must not be more than max"}}
+	}
+	return nil
+}
+
+func TestValidateCallTheValidator(t *testing.T) {
+
+	target := struct {
+		Range validateCrossField `json:"range"`
+	}{Range: validateCrossField{Min: 2, Max: 1}}
+
+	err := Validate(target)
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || len(validationErr.Violations) != 1 {
+		t.Fatalf("validate return %v", err)
+	}
+
+	expect(t, validationErr.Violations[0].String(), "range.min must not be more than max")
+}
@@ -7,9 +7,11 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"infrastructure/shared/infrastructure/config"
 	"reflect"
 	"regexp"
 	"strings"
+	"time"
 )
 
 type GetAllParam struct {
@@ -79,6 +81,14 @@ type DeleteRepo[T any] interface {
 	Delete(filter map[string]any) error
 }
 
+// UpdateIfRepo is the conditional update used by the optimistic lock
+type UpdateIfRepo[T any] interface {
+
+	// UpdateIf replace the stored obj only when it also match the filter, like UPDATE ... WHERE version = ?.
+	// The obj is inserted when there is no stored obj with the same ID. It return false when the stored obj does not match
+	UpdateIf(filter map[string]any, obj *T) (bool, error)
+}
+
 type Repository[T any] interface {
 	InsertOrUpdateRepo[T]
 	InsertManyRepo[T]
@@ -139,6 +149,70 @@ func NewDatabase(databaseName string) *mongo.Database {
 
 }
 
+// NewMongoClient connect to the uri in the config and ping the primary.
+// The empty connect timeout is 10 second
+func NewMongoClient(cfg config.Mongo) (*mongo.Client, error) {
+
+	opts, connectTimeout := mongoClientOptions(cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Ping(ctx, readpref.Primary())
+	if err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// mongoClientOptions return the client option and the connect timeout of the config
+func mongoClientOptions(cfg config.Mongo) (*options.ClientOptions, time.Duration) {
+
+	uri := cfg.URI.Value()
+	if uri == "" {
+		uri = "mongodb://localhost:27017/?readPreference=primary&ssl=false"
+	}
+
+	connectTimeout := 10 * time.Second
+	if cfg.ConnectTimeout > 0 {
+		connectTimeout = cfg.ConnectTimeout.Duration()
+	}
+
+	opts := options.Client().ApplyURI(uri).SetConnectTimeout(connectTimeout)
+
+	if cfg.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(cfg.MaxPoolSize)
+	}
+
+	if cfg.MinPoolSize > 0 {
+		opts.SetMinPoolSize(cfg.MinPoolSize)
+	}
+
+	return opts, connectTimeout
+}
+
+// NewDatabaseFromConfig is NewDatabase with the connection from the config, it returns the error instead of panic
+func NewDatabaseFromConfig(cfg config.Mongo) (*mongo.Database, error) {
+
+	if cfg.Database == "" {
+		return nil, fmt.Errorf("mongo database name must not empty")
+	}
+
+	client, err := NewMongoClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Database(cfg.Database), nil
+}
+
 func (g *MongoGateway[T]) GetTypeName() string {
 	var x T
 	return snakeCase(reflect.TypeOf(x).Name())
@@ -175,6 +249,38 @@ func (g *MongoGateway[T]) InsertOrUpdate(obj *T) error {
 	return nil
 }
 
+func (g *MongoGateway[T]) UpdateIf(filter map[string]any, obj *T) (bool, error) {
+
+	sf, exist := reflect.TypeOf(obj).Elem().FieldByName("ID")
+	if !exist {
+		return false, fmt.Errorf("field ID as primary key is not found in %s", reflect.TypeOf(obj).Name())
+	}
+
+	tagValue, exist := sf.Tag.Lookup("bson")
+	if !exist || tagValue != "_id" {
+		return false, fmt.Errorf("field ID must have tag `bson:\"_id\"`")
+	}
+
+	condition := bson.M{"_id": reflect.ValueOf(obj).Elem().FieldByName("ID").Interface()}
+	for k, v := range filter {
+		condition[k] = v
+	}
+
+	opts := options.Replace().SetUpsert(true)
+
+	// the stored obj which does not match the filter make the upsert insert the duplicate id
+	coll := g.Database.Collection(g.GetTypeName())
+	_, err := coll.ReplaceOne(context.TODO(), condition, obj, opts)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
 func (g *MongoGateway[T]) InsertMany(objs ...*T) error {
 
 	if len(objs) == 0 {
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"infrastructure/shared/infrastructure/config"
+	"testing"
+	"time"
+)
+
+func TestMongoClientOptions(t *testing.T) {
+
+	opts, connectTimeout := mongoClientOptions(config.Mongo{})
+	if opts.GetURI() != "mongodb://localhost:27017/?readPreference=primary&ssl=false" || connectTimeout != 10*time.Second {
+		t.Fatalf("default option is %s %s", opts.GetURI(), connectTimeout)
+	}
+
+	opts, connectTimeout = mongoClientOptions(config.Mongo{
+		URI:            config.NewSecret("mongodb://db:27017"),
+		ConnectTimeout: config.Duration(time.Second),
+		MaxPoolSize:    20,
+		MinPoolSize:    2,
+	})
+
+	if opts.GetURI() != "mongodb://db:27017" || connectTimeout != time.Second || *opts.ConnectTimeout != time.Second {
+		t.Fatalf("option is %s %s", opts.GetURI(), connectTimeout)
+	}
+
+	if *opts.MaxPoolSize != 20 || *opts.MinPoolSize != 2 {
+		t.Fatalf("pool size is %d to %d", *opts.MinPoolSize, *opts.MaxPoolSize)
+	}
+}
+
+func TestNewDatabaseFromConfigError(t *testing.T) {
+
+	if _, err := NewDatabaseFromConfig(config.Mongo{URI: config.NewSecret("mongodb://127.0.0.1:1")}); err == nil {
+		t.Fatal("database without the name is accepted")
+	}
+
+	// the server which is not reachable return the error within the connect timeout instead of panic
+	start := time.Now()
+
+	_, err := NewDatabaseFromConfig(config.Mongo{
+		URI:            config.NewSecret("mongodb://127.0.0.1:1/?serverSelectionTimeoutMS=5000"),
+		Database:       "order",
+		ConnectTimeout: config.Duration(200 * time.Millisecond),
+	})
+	if err == nil {
+		t.Fatal("unreachable server is connected")
+	}
+
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("connect take %s", elapsed)
+	}
+}
@@ -0,0 +1,82 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotAcquired is returned by TryAcquire when the lock is held by the other owner
+	ErrNotAcquired = errors.New("lock not acquired")
+
+	// ErrLockLost is returned when the lock is expired or taken by the other owner
+	ErrLockLost = errors.New("lock lost")
+
+	// ErrInvalidTTL is returned when the ttl is less than 1ms, the smallest expiration of redis
+	ErrInvalidTTL = errors.New("lock ttl must be at least 1ms")
+)
+
+// Locker give the mutual exclusion across the instances
+type Locker interface {
+
+	// Acquire wait until the lock is acquired or the ctx is done
+	Acquire(ctx context.Context, key string, ttl time.Duration) (Lock, error)
+
+	// TryAcquire return ErrNotAcquired immediately if the lock is held
+	TryAcquire(ctx context.Context, key string, ttl time.Duration) (Lock, error)
+}
+
+// Lock is the acquired lock. It is renewed in the background until Release is called or it is lost
+type Lock interface {
+	Key() string
+
+	// Token is the fencing token, it always increase for every acquire of the same key.
+	// Give it to the storage so the write from the older owner can be rejected
+	Token() int64
+
+	// Refresh extend the lock to the ttl, return ErrLockLost if the lock is not owned anymore
+	Refresh(ctx context.Context, ttl time.Duration) error
+
+	// Release stop the renewal then release the lock, return ErrLockLost if the lock is not owned anymore
+	Release(ctx context.Context) error
+
+	// Done is closed when the lock is released or lost
+	Done() <-chan struct{}
+
+	// Err return ErrLockLost after the renewal is failed, nil otherwise
+	Err() error
+}
+
+// WithLock run the fn while holding the lock. The ctx given to the fn is canceled when the lock is lost
+//
+//	err := lock.WithLock(ctx, locker, "migration", 30*time.Second, func(ctx context.Context) error {
+//		return migrate(ctx)
+//	})
+func WithLock(ctx context.Context, locker Locker, key string, ttl time.Duration, fn func(ctx context.Context) error) error {
+
+	l, err := locker.Acquire(ctx, key, ttl)
+	if err != nil {
+		return err
+	}
+
+	lockCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-l.Done():
+			cancel()
+		case <-lockCtx.Done():
+		}
+	}()
+
+	fnErr := fn(lockCtx)
+
+	releaseErr := l.Release(context.Background())
+	if fnErr != nil {
+		return fnErr
+	}
+
+	return releaseErr
+}
@@ -0,0 +1,211 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// newLockerFunc create the locker and the function that take the lock away from its owner
+type newLockerFunc func(t *testing.T, option LockerOption) (Locker, func(key string))
+
+func newTestMemoryLocker(t *testing.T, option LockerOption) (Locker, func(key string)) {
+
+	l := NewMemoryLocker(option)
+	backend := l.(*locker).backend.(*memoryBackend)
+
+	return l, func(key string) {
+		backend.mutex.Lock()
+		defer backend.mutex.Unlock()
+		backend.holders[option.Prefix+key] = memoryHolder{owner: "other", expireAt: time.Now().Add(time.Hour)}
+	}
+}
+
+func newTestRedisLocker(t *testing.T, option LockerOption) (Locker, func(key string)) {
+
+	server := miniredis.RunT(t)
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	return NewRedisLocker(client, option), func(key string) {
+		lockKey, _ := fencingKey(option.Prefix + key)
+		server.Set(lockKey, "other")
+	}
+}
+
+var lockers = map[string]newLockerFunc{
+	"memory": newTestMemoryLocker,
+	"redis":  newTestRedisLocker,
+}
+
+func TestFencingTokenIncrease(t *testing.T) {
+	for name, newLocker := range lockers {
+		t.Run(name, func(t *testing.T) {
+
+			locker, _ := newLocker(t, NewDefaultLockerOption().SetAutoRenew(false))
+			ctx := context.Background()
+
+			first, err := locker.TryAcquire(ctx, "job", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := locker.TryAcquire(ctx, "job", time.Minute); !errors.Is(err, ErrNotAcquired) {
+				t.Fatalf("acquire of the held lock return %v", err)
+			}
+
+			if err := first.Release(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			second, err := locker.TryAcquire(ctx, "job", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if second.Token() <= first.Token() {
+				t.Fatalf("token %d is not more than %d", second.Token(), first.Token())
+			}
+
+			// the other key has its own lock
+			if _, err := locker.TryAcquire(ctx, "other-job", time.Minute); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestInvalidTTL(t *testing.T) {
+	for name, newLocker := range lockers {
+		t.Run(name, func(t *testing.T) {
+
+			locker, _ := newLocker(t, NewDefaultLockerOption())
+			ctx := context.Background()
+
+			for _, ttl := range []time.Duration{0, -time.Second, 500 * time.Microsecond} {
+				if _, err := locker.TryAcquire(ctx, "job", ttl); !errors.Is(err, ErrInvalidTTL) {
+					t.Fatalf("try acquire with %s return %v", ttl, err)
+				}
+				if _, err := locker.Acquire(ctx, "job", ttl); !errors.Is(err, ErrInvalidTTL) {
+					t.Fatalf("acquire with %s return %v", ttl, err)
+				}
+			}
+
+			l, err := locker.TryAcquire(ctx, "job", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Release(ctx)
+
+			if err := l.Refresh(ctx, 0); !errors.Is(err, ErrInvalidTTL) {
+				t.Fatalf("refresh with 0 return %v", err)
+			}
+		})
+	}
+}
+
+func TestLostLockIsDetected(t *testing.T) {
+	for name, newLocker := range lockers {
+		t.Run(name, func(t *testing.T) {
+
+			locker, takeAway := newLocker(t, NewDefaultLockerOption())
+			ctx := context.Background()
+
+			l, err := locker.TryAcquire(ctx, "job", 30*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			takeAway("job")
+
+			select {
+			case <-l.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("lost lock is not detected by the renewal")
+			}
+
+			if !errors.Is(l.Err(), ErrLockLost) || !errors.Is(l.Release(ctx), ErrLockLost) {
+				t.Fatalf("lost lock has %v", l.Err())
+			}
+		})
+	}
+}
+
+func TestMemoryLockIsRenewed(t *testing.T) {
+
+	locker := NewMemoryLocker()
+	ctx := context.Background()
+
+	l, err := locker.TryAcquire(ctx, "job", 60*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the lock would be expired 3 times without the renewal
+	time.Sleep(200 * time.Millisecond)
+
+	if _, err := locker.TryAcquire(ctx, "job", time.Minute); !errors.Is(err, ErrNotAcquired) {
+		t.Fatalf("renewed lock is acquired by the other, %v", err)
+	}
+
+	if err := l.Release(ctx); err != nil || l.Err() != nil {
+		t.Fatalf("release return %v, err %v", err, l.Err())
+	}
+
+	if _, err := locker.TryAcquire(ctx, "job", time.Minute); err != nil {
+		t.Fatalf("released lock is not acquired, %v", err)
+	}
+}
+
+func TestReleaseIsNotLostByTheRenewal(t *testing.T) {
+	for name, newLocker := range lockers {
+		t.Run(name, func(t *testing.T) {
+
+			locker, _ := newLocker(t, NewDefaultLockerOption())
+			ctx := context.Background()
+
+			// the renewal run every 5ms so it often run together with the release
+			for i := 0; i < 50; i++ {
+
+				l, err := locker.TryAcquire(ctx, "job", 15*time.Millisecond)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				time.Sleep(time.Duration(i%4) * 2 * time.Millisecond)
+
+				if err := l.Release(ctx); err != nil {
+					t.Fatal(err)
+				}
+
+				time.Sleep(2 * time.Millisecond)
+
+				if l.Err() != nil {
+					t.Fatalf("released lock has %v", l.Err())
+				}
+			}
+		})
+	}
+}
+
+func TestWithLockCancelTheContextWhenTheLockIsLost(t *testing.T) {
+
+	locker, takeAway := newTestMemoryLocker(t, NewDefaultLockerOption())
+
+	err := WithLock(context.Background(), locker, "job", 30*time.Millisecond, func(ctx context.Context) error {
+		takeAway("job")
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("with lock return %v", err)
+	}
+}
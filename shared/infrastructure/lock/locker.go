@@ -0,0 +1,280 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"infrastructure/shared/util"
+)
+
+// LockerOption start from NewDefaultLockerOption then override the value with the setter
+type LockerOption struct {
+
+	// Prefix is put in front of every lock key
+	Prefix string
+
+	// RetryInterval is how often Acquire try again while the lock is held
+	RetryInterval time.Duration
+
+	// AutoRenew refresh the lock at the third of the ttl until it is released
+	AutoRenew bool
+}
+
+func NewDefaultLockerOption() LockerOption {
+	return LockerOption{
+		Prefix:        "lock:",
+		RetryInterval: 100 * time.Millisecond,
+		AutoRenew:     true,
+	}
+}
+
+func (l LockerOption) SetPrefix(prefix string) LockerOption {
+	l.Prefix = prefix
+	return l
+}
+
+func (l LockerOption) SetRetryInterval(retryInterval time.Duration) LockerOption {
+	l.RetryInterval = retryInterval
+	return l
+}
+
+func (l LockerOption) SetAutoRenew(autoRenew bool) LockerOption {
+	l.AutoRenew = autoRenew
+	return l
+}
+
+func getLockerOption(option []LockerOption) LockerOption {
+	if len(option) > 0 {
+		return option[0]
+	}
+	return NewDefaultLockerOption()
+}
+
+// backend is the storage specific part, the owner is the random value which identify the lock holder
+type backend interface {
+	tryAcquire(ctx context.Context, key, owner string, ttl time.Duration) (token int64, acquired bool, err error)
+	refresh(ctx context.Context, key, owner string, ttl time.Duration) (bool, error)
+	release(ctx context.Context, key, owner string) (bool, error)
+}
+
+type locker struct {
+	backend backend
+	option  LockerOption
+}
+
+func validateTTL(ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return fmt.Errorf("%w, got %s", ErrInvalidTTL, ttl)
+	}
+	return nil
+}
+
+func (r *locker) TryAcquire(ctx context.Context, key string, ttl time.Duration) (Lock, error) {
+
+	if err := validateTTL(ttl); err != nil {
+		return nil, err
+	}
+
+	owner := util.GenerateID(20)
+
+	token, acquired, err := r.backend.tryAcquire(ctx, r.option.Prefix+key, owner, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	if !acquired {
+		return nil, ErrNotAcquired
+	}
+
+	l := &heldLock{
+		backend: r.backend,
+		key:     key,
+		fullKey: r.option.Prefix + key,
+		owner:   owner,
+		token:   token,
+		ttl:     ttl,
+		done:    make(chan struct{}),
+		stop:    make(chan struct{}),
+	}
+
+	if r.option.AutoRenew {
+		l.renewDone = make(chan struct{})
+		go l.renew()
+	}
+
+	return l, nil
+}
+
+func (r *locker) Acquire(ctx context.Context, key string, ttl time.Duration) (Lock, error) {
+
+	if err := validateTTL(ttl); err != nil {
+		return nil, err
+	}
+
+	ticker := time.NewTicker(r.option.RetryInterval)
+	defer ticker.Stop()
+
+	for {
+		l, err := r.TryAcquire(ctx, key, ttl)
+		if err != ErrNotAcquired {
+			return l, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+type heldLock struct {
+	backend backend
+	key     string
+	fullKey string
+	owner   string
+	token   int64
+
+	mutex    sync.Mutex
+	ttl      time.Duration
+	err      error
+	done     chan struct{}
+	doneOnce sync.Once
+
+	// stop end the renew goroutine, renewDone is closed when it return. renewDone is nil without AutoRenew
+	stop      chan struct{}
+	stopOnce  sync.Once
+	renewDone chan struct{}
+}
+
+func (l *heldLock) Key() string {
+	return l.key
+}
+
+func (l *heldLock) Token() int64 {
+	return l.token
+}
+
+func (l *heldLock) Done() <-chan struct{} {
+	return l.done
+}
+
+func (l *heldLock) Err() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.err
+}
+
+func (l *heldLock) finish(err error) {
+	l.doneOnce.Do(func() {
+		l.mutex.Lock()
+		l.err = err
+		l.mutex.Unlock()
+		close(l.done)
+	})
+}
+
+func (l *heldLock) Refresh(ctx context.Context, ttl time.Duration) error {
+
+	if err := validateTTL(ttl); err != nil {
+		return err
+	}
+
+	ok, err := l.backend.refresh(ctx, l.fullKey, l.owner, ttl)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		l.finish(ErrLockLost)
+		return ErrLockLost
+	}
+
+	l.mutex.Lock()
+	l.ttl = ttl
+	l.mutex.Unlock()
+
+	return nil
+}
+
+func (l *heldLock) Release(ctx context.Context) error {
+
+	// the refresh of the renew goroutine which fail after the release must not mark the lock lost
+	l.stopRenew()
+
+	select {
+	case <-l.done:
+		return l.Err()
+	default:
+	}
+
+	ok, err := l.backend.release(ctx, l.fullKey, l.owner)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		l.finish(ErrLockLost)
+		return ErrLockLost
+	}
+
+	l.finish(nil)
+
+	return nil
+}
+
+// stopRenew stop the renew goroutine and wait until it return
+func (l *heldLock) stopRenew() {
+
+	l.stopOnce.Do(func() {
+		close(l.stop)
+	})
+
+	if l.renewDone != nil {
+		<-l.renewDone
+	}
+}
+
+// renew keep refreshing the lock. The temporary error is retried until the lock is expired
+func (l *heldLock) renew() {
+
+	defer close(l.renewDone)
+
+	l.mutex.Lock()
+	ttl := l.ttl
+	l.mutex.Unlock()
+
+	expireAt := time.Now().Add(ttl)
+
+	for {
+		interval := ttl / 3
+
+		select {
+		case <-l.done:
+			return
+		case <-l.stop:
+			return
+		case <-time.After(interval):
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := l.Refresh(ctx, ttl)
+		cancel()
+
+		if err == ErrLockLost {
+			return
+		}
+
+		if err == nil {
+			expireAt = time.Now().Add(ttl)
+			continue
+		}
+
+		if !time.Now().Before(expireAt) {
+			l.finish(ErrLockLost)
+			return
+		}
+	}
+}
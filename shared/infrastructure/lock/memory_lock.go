@@ -0,0 +1,78 @@
+package lock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryHolder struct {
+	owner    string
+	expireAt time.Time
+}
+
+type memoryBackend struct {
+	mutex   sync.Mutex
+	holders map[string]memoryHolder
+	tokens  map[string]int64
+}
+
+// NewMemoryLocker create the Locker for the single process and the test
+func NewMemoryLocker(option ...LockerOption) Locker {
+	return &locker{
+		backend: &memoryBackend{
+			holders: map[string]memoryHolder{},
+			tokens:  map[string]int64{},
+		},
+		option: getLockerOption(option),
+	}
+}
+
+// owned check the key is held by the owner and not expired. The caller must hold the mutex
+func (r *memoryBackend) owned(key, owner string, now time.Time) bool {
+	holder, exist := r.holders[key]
+	return exist && holder.owner == owner && now.Before(holder.expireAt)
+}
+
+func (r *memoryBackend) tryAcquire(ctx context.Context, key, owner string, ttl time.Duration) (int64, bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+
+	if holder, exist := r.holders[key]; exist && now.Before(holder.expireAt) {
+		return 0, false, nil
+	}
+
+	r.holders[key] = memoryHolder{owner: owner, expireAt: now.Add(ttl)}
+	r.tokens[key]++
+
+	return r.tokens[key], true, nil
+}
+
+func (r *memoryBackend) refresh(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	if !r.owned(key, owner, now) {
+		return false, nil
+	}
+
+	r.holders[key] = memoryHolder{owner: owner, expireAt: now.Add(ttl)}
+
+	return true, nil
+}
+
+func (r *memoryBackend) release(ctx context.Context, key, owner string) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.owned(key, owner, time.Now()) {
+		return false, nil
+	}
+
+	delete(r.holders, key)
+
+	return true, nil
+}
@@ -0,0 +1,90 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// acquireScript set the lock then increase the fencing token in one step.
+// The fencing counter has no expiration so the token keep increasing after the lock is expired
+var acquireScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return false
+`)
+
+// refreshScript and releaseScript only touch the lock which is still owned by the caller
+var refreshScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+type redisBackend struct {
+	client redis.UniversalClient
+}
+
+// NewRedisLocker create the Locker with SET NX PX. It is safe for the single redis instance,
+// use the fencing token when the correctness must survive the failover
+func NewRedisLocker(client redis.UniversalClient, option ...LockerOption) Locker {
+	return &locker{
+		backend: &redisBackend{client: client},
+		option:  getLockerOption(option),
+	}
+}
+
+// fencingKey keep the lock and the counter in the same cluster slot
+func fencingKey(key string) (string, string) {
+	return "{" + key + "}", "{" + key + "}:fencing"
+}
+
+func (r *redisBackend) tryAcquire(ctx context.Context, key, owner string, ttl time.Duration) (int64, bool, error) {
+
+	lockKey, counterKey := fencingKey(key)
+
+	token, err := acquireScript.Run(ctx, r.client, []string{lockKey, counterKey}, owner, ttl.Milliseconds()).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return token, true, nil
+}
+
+func (r *redisBackend) refresh(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+
+	lockKey, _ := fencingKey(key)
+
+	result, err := refreshScript.Run(ctx, r.client, []string{lockKey}, owner, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return false, err
+	}
+
+	return result == 1, nil
+}
+
+func (r *redisBackend) release(ctx context.Context, key, owner string) (bool, error) {
+
+	lockKey, _ := fencingKey(key)
+
+	result, err := releaseScript.Run(ctx, r.client, []string{lockKey}, owner).Int64()
+	if err != nil {
+		return false, err
+	}
+
+	return result == 1, nil
+}
@@ -8,9 +8,92 @@ import (
 	"strings"
 )
 
+// Logger print the message formatted with the args like fmt.Sprintf.
+// The key value pairs from With and from the KV at the end of the args are written as the separate fields
+//
+//	log := logger.NewLogrusLog(appData).With("topic", topic, "orderID", order.ID)
+//	log.Info(ctx, "order is received")
+//	log.Warn(ctx, "retry %d", attempt, logger.KV("delay", delay, "reason", err.Error()))
 type Logger interface {
+	Debug(ctx context.Context, message string, args ...any)
 	Info(ctx context.Context, message string, args ...any)
+	Warn(ctx context.Context, message string, args ...any)
 	Error(ctx context.Context, message string, args ...any)
+
+	// Fatal print the message then exit the process with status 1
+	Fatal(ctx context.Context, message string, args ...any)
+
+	// With return the child logger which write the key value pairs in every log.
+	// The key is the string, the last key without the value is written as the value of the !BADKEY field
+	With(keyValues ...any) Logger
+}
+
+// the name of the fields written in every log
+const (
+	FieldTraceID       = "traceID"
+	FieldSpanID        = "spanID"
+	FieldCaller        = "caller"
+	FieldAppName       = "appName"
+	FieldAppInstanceID = "appInstanceID"
+)
+
+// Fields is the key value pairs of one log, it is put at the end of the args and it is not used by the format
+type Fields map[string]any
+
+// KV return the Fields of the key value pairs in the same form as With
+func KV(keyValues ...any) Fields {
+	return toFields(keyValues)
+}
+
+// splitFields take the Fields from the end of the args, the rest is the args of the format
+func splitFields(args []any) ([]any, Fields) {
+
+	var fields Fields
+
+	for len(args) > 0 {
+		last, ok := args[len(args)-1].(Fields)
+		if !ok {
+			break
+		}
+
+		if fields == nil {
+			fields = Fields{}
+		}
+
+		// the later Fields is taken first and win like the later key of With
+		for k, v := range last {
+			if _, exist := fields[k]; !exist {
+				fields[k] = v
+			}
+		}
+
+		args = args[:len(args)-1]
+	}
+
+	return args, fields
+}
+
+// toFields turn the key value pairs into the map. The key which is not the string is formatted with fmt.Sprint
+func toFields(keyValues []any) map[string]any {
+
+	fields := make(map[string]any, len(keyValues)/2)
+
+	for i := 0; i < len(keyValues); i += 2 {
+
+		if i+1 == len(keyValues) {
+			fields["!BADKEY"] = keyValues[i]
+			break
+		}
+
+		key, ok := keyValues[i].(string)
+		if !ok {
+			key = fmt.Sprint(keyValues[i])
+		}
+
+		fields[key] = keyValues[i+1]
+	}
+
+	return fields
 }
 
 type traceDataType int
@@ -35,6 +118,27 @@ func GetTraceID(ctx context.Context) string {
 	return traceID
 }
 
+type spanDataType int
+
+const spanDataKey spanDataType = 1
+
+// SetSpanID put the id of the current span, it is used as the parent span by the next outgoing call
+func SetSpanID(ctx context.Context, spanID string) context.Context {
+	return context.WithValue(ctx, spanDataKey, spanID)
+}
+
+// GetSpanID return empty string if there is no span in the context
+func GetSpanID(ctx context.Context) string {
+
+	if ctx != nil {
+		if v := ctx.Value(spanDataKey); v != nil {
+			return v.(string)
+		}
+	}
+
+	return ""
+}
+
 // getFileLocationInfo get the function information like filename and line number
 // skip is the parameter that need to adjust if we add new method layer
 func getFileLocationInfo(skip int) string {
@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"infrastructure/shared/gogen"
+	"infrastructure/shared/infrastructure/config"
+	"io"
+	"os"
 	"time"
 
 	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
@@ -11,23 +14,113 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// NewLogrusLog create the logger writing to the stderr and the rotated file. It panic when the log file can not be created,
+// use NewLogrusLogFromConfig to get the error
 func NewLogrusLog(appData gogen.ApplicationData) Logger {
+	theLogger, err := newLogrus(appData, config.Logging{})
+	if err != nil {
+		panic(err.Error())
+	}
+	return &logrusLog{
+		appData:   appData,
+		theLogger: theLogger,
+	}
+}
+
+// NewLogrusLogFromConfig create the logger with the level, format, outputs and file rotation in the config.
+// The empty value use the same default as NewLogrusLog
+func NewLogrusLogFromConfig(appData gogen.ApplicationData, cfg config.Logging) (Logger, error) {
+
+	theLogger, err := newLogrus(appData, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logrusLog{
+		appData:   appData,
+		theLogger: theLogger,
+	}, nil
+}
+
+func newLogrus(appData gogen.ApplicationData, cfg config.Logging) (*logrus.Logger, error) {
+
+	theLogger := logrus.New()
+
+	if cfg.Level != "" {
+		level, err := logrus.ParseLevel(cfg.Level)
+		if err != nil {
+			return nil, err
+		}
+		theLogger.SetLevel(level)
+	}
+
+	if cfg.Format == "text" {
+		theLogger.SetFormatter(&logrus.TextFormatter{
+			TimestampFormat: "0102 150405.000",
+			FullTimestamp:   true,
+		})
+	} else {
+		theLogger.SetFormatter(&logrus.JSONFormatter{
+			TimestampFormat: "0102 150405.000",
+		})
+	}
+
+	outputs := cfg.Outputs
+	if len(outputs) == 0 {
+		outputs = []string{"stderr", "file"}
+	}
+
+	writers := make([]io.Writer, 0)
+	toFile := false
+
+	for _, output := range outputs {
+		switch output {
+		case "stdout":
+			writers = append(writers, os.Stdout)
+		case "stderr":
+			writers = append(writers, os.Stderr)
+		case "file":
+			toFile = true
+		default:
+			return nil, fmt.Errorf("unknown log output %s", output)
+		}
+	}
+
+	theLogger.SetOutput(io.MultiWriter(writers...))
+
+	if !toFile {
+		return theLogger, nil
+	}
 
 	path := "."
+	if cfg.File.Path != "" {
+		path = cfg.File.Path
+	}
+
 	name := appData.AppName
-	maxAge := 1
+	if cfg.File.Name != "" {
+		name = cfg.File.Name
+	}
+
+	maxAge := 24 * time.Hour
+	if cfg.File.MaxAge > 0 {
+		maxAge = cfg.File.MaxAge.Duration()
+	}
 
-	writer, _ := rotatelogs.New(
+	rotationTime := 24 * time.Hour
+	if cfg.File.RotationTime > 0 {
+		rotationTime = cfg.File.RotationTime.Duration()
+	}
+
+	writer, err := rotatelogs.New(
 		fmt.Sprintf("%s/logs/%s.log.%s", path, name, "%Y%m%d"),
 		rotatelogs.WithLinkName(fmt.Sprintf("%s/%s.log", path, name)),
-		rotatelogs.WithMaxAge(time.Duration(maxAge*24)*time.Hour),
-		rotatelogs.WithRotationTime(time.Duration(1*24)*time.Hour),
+		rotatelogs.WithMaxAge(maxAge),
+		rotatelogs.WithRotationTime(rotationTime),
 	)
-
-	theLogger := logrus.New()
-	theLogger.SetFormatter(&logrus.JSONFormatter{
-		TimestampFormat: "0102 150405.000",
-	})
+	if err != nil {
+		return nil, err
+	}
 
 	theLogger.AddHook(lfshook.NewHook(
 		lfshook.WriterMap{
@@ -41,40 +134,87 @@ func NewLogrusLog(appData gogen.ApplicationData) Logger {
 		theLogger.Formatter,
 	))
 
-	return &logrusLog{
-		appData:   appData,
-		theLogger: theLogger,
-	}
+	return theLogger, nil
 }
 
 type logrusLog struct {
 	theLogger *logrus.Logger
 	appData   gogen.ApplicationData
+
+	// fields is added by With, it is never modified after the logger is created
+	fields logrus.Fields
+}
+
+func (l logrusLog) Debug(ctx context.Context, message string, args ...any) {
+	l.printLog(ctx, logrus.DebugLevel, message, args)
 }
 
 func (l logrusLog) Info(ctx context.Context, message string, args ...any) {
-	messageWithArgs := fmt.Sprintf(message, args...)
-	l.printLog(ctx, "INFO", messageWithArgs)
+	l.printLog(ctx, logrus.InfoLevel, message, args)
+}
+
+func (l logrusLog) Warn(ctx context.Context, message string, args ...any) {
+	l.printLog(ctx, logrus.WarnLevel, message, args)
 }
 
 func (l logrusLog) Error(ctx context.Context, message string, args ...any) {
-	messageWithArgs := fmt.Sprintf(message, args...)
-	l.printLog(ctx, "ERROR", messageWithArgs)
+	l.printLog(ctx, logrus.ErrorLevel, message, args)
 }
 
-func (l logrusLog) printLog(ctx context.Context, flag string, data any) {
-	traceID := GetTraceID(ctx)
+func (l logrusLog) Fatal(ctx context.Context, message string, args ...any) {
+	l.printLog(ctx, logrus.FatalLevel, message, args)
+	l.theLogger.Exit(1)
+}
 
-	msg := fmt.Sprintf("%-5s %s %-60v %s\n", flag, traceID, data, getFileLocationInfo(3))
+func (l logrusLog) With(keyValues ...any) Logger {
 
-	if flag == "INFO" {
-		l.theLogger.Info(msg)
-		return
+	fields := make(logrus.Fields, len(l.fields)+len(keyValues)/2)
+	for k, v := range l.fields {
+		fields[k] = v
 	}
 
-	if flag == "ERROR" {
-		l.theLogger.Error(msg)
+	for k, v := range toFields(keyValues) {
+		fields[k] = v
+	}
+
+	return &logrusLog{
+		theLogger: l.theLogger,
+		appData:   l.appData,
+		fields:    fields,
+	}
+}
+
+// printLog write the message with the fields. The trace id, caller, app name and instance id are the separate fields
+// and they can not be replaced by the field from With or KV. The field from KV replace the one from With
+func (l logrusLog) printLog(ctx context.Context, level logrus.Level, message string, args []any) {
+
+	if !l.theLogger.IsLevelEnabled(level) {
 		return
 	}
 
+	args, callFields := splitFields(args)
+
+	fields := make(logrus.Fields, len(l.fields)+len(callFields)+5)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+
+	for k, v := range callFields {
+		fields[k] = v
+	}
+
+	fields[FieldTraceID] = GetTraceID(ctx)
+	fields[FieldCaller] = getFileLocationInfo(3)
+	fields[FieldAppName] = l.appData.AppName
+	fields[FieldAppInstanceID] = l.appData.AppInstanceID
+
+	if spanID := GetSpanID(ctx); spanID != "" {
+		fields[FieldSpanID] = spanID
+	}
+
+	if len(args) > 0 {
+		message = fmt.Sprintf(message, args...)
+	}
+
+	l.theLogger.WithFields(fields).Log(level, message)
 }
@@ -0,0 +1,198 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"infrastructure/shared/gogen"
+	"infrastructure/shared/infrastructure/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testAppData = gogen.ApplicationData{AppName: "order", AppInstanceID: "I1"}
+
+// newBufferLog create the json logger writing into the buffer
+func newBufferLog(t *testing.T) (Logger, *bytes.Buffer) {
+
+	log, err := NewLogrusLogFromConfig(testAppData, config.Logging{Level: "debug", Outputs: []string{"stdout"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := &bytes.Buffer{}
+	log.(*logrusLog).theLogger.SetOutput(buffer)
+
+	return log, buffer
+}
+
+// readLogs decode every json line in the buffer
+func readLogs(t *testing.T, buffer *bytes.Buffer) []map[string]any {
+
+	var logs []map[string]any
+
+	for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("log %q is not json, %v", line, err)
+		}
+		logs = append(logs, fields)
+	}
+
+	return logs
+}
+
+func TestLogHasTheFixedFields(t *testing.T) {
+
+	log, buffer := newBufferLog(t)
+
+	ctx := SetSpanID(SetTraceID(context.Background(), "T1"), "S1")
+	log.Info(ctx, "order %s is created", "O1")
+
+	fields := readLogs(t, buffer)[0]
+
+	expect := map[string]any{
+		"msg":              "order O1 is created",
+		"level":            "info",
+		FieldTraceID:       "T1",
+		FieldSpanID:        "S1",
+		FieldAppName:       "order",
+		FieldAppInstanceID: "I1",
+	}
+
+	for key, value := range expect {
+		if fields[key] != value {
+			t.Fatalf("field %s is %v, want %v", key, fields[key], value)
+		}
+	}
+
+	// the caller is the function which call the logger
+	if caller, _ := fields[FieldCaller].(string); !strings.HasPrefix(caller, "logger.TestLogHasTheFixedFields:") {
+		t.Fatalf("caller is %v", fields[FieldCaller])
+	}
+
+	// the log without the trace has the default trace id and no span id
+	log.Debug(context.Background(), "no trace")
+
+	fields = readLogs(t, buffer)[1]
+	if fields[FieldTraceID] != "0000000000000000" || fields[FieldSpanID] != nil {
+		t.Fatalf("log without the trace has %v %v", fields[FieldTraceID], fields[FieldSpanID])
+	}
+}
+
+func TestLogFieldsCanNotOverrideTheFixedFields(t *testing.T) {
+
+	log, buffer := newBufferLog(t)
+
+	child := log.With(FieldTraceID, "fake", FieldAppName, "fake", "topic", "orders", "attempt", 1)
+	child.Warn(SetTraceID(context.Background(), "T1"), "retry %d", 2, KV(FieldCaller, "fake", FieldAppInstanceID, "fake", "attempt", 2, "delay", "5s"))
+
+	fields := readLogs(t, buffer)[0]
+
+	if fields[FieldTraceID] != "T1" || fields[FieldAppName] != "order" || fields[FieldAppInstanceID] != "I1" || fields[FieldCaller] == "fake" {
+		t.Fatalf("fixed field is replaced, %v", fields)
+	}
+
+	// the field of the call replace the one from With and the format use only the args before KV
+	if fields["msg"] != "retry 2" || fields["topic"] != "orders" || fields["attempt"] != float64(2) || fields["delay"] != "5s" {
+		t.Fatalf("fields are %v", fields)
+	}
+
+	// the parent logger does not have the field of the child
+	log.Info(context.Background(), "parent")
+
+	if fields := readLogs(t, buffer)[1]; fields["topic"] != nil {
+		t.Fatalf("parent has the field of the child, %v", fields)
+	}
+}
+
+func TestSplitFields(t *testing.T) {
+
+	args, fields := splitFields([]any{"O1", 2, KV("a", 1, "b", 2), KV("b", 3, "odd")})
+
+	if len(args) != 2 || fields["a"] != 1 || fields["b"] != 3 || fields["!BADKEY"] != "odd" {
+		t.Fatalf("args %v fields %v", args, fields)
+	}
+
+	// the Fields which is not at the end is the format arg
+	args, fields = splitFields([]any{KV("a", 1), "O1"})
+	if len(args) != 2 || fields != nil {
+		t.Fatalf("args %v fields %v", args, fields)
+	}
+}
+
+func TestNewLogrusLogFromConfigWriteTheFile(t *testing.T) {
+
+	dir := t.TempDir()
+
+	log, err := NewLogrusLogFromConfig(testAppData, config.Logging{
+		Level:   "warn",
+		Outputs: []string{"file"},
+		File:    config.LogFile{Path: dir, Name: "order-service"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log.Info(context.Background(), "info is below the level")
+	log.Warn(context.Background(), "stock of %s is low", "P1")
+
+	// the link point to the file of today
+	bytes, err := os.ReadFile(filepath.Join(dir, "order-service.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := string(bytes)
+	if strings.Contains(text, "below the level") || !strings.Contains(text, `"msg":"stock of P1 is low"`) {
+		t.Fatalf("log file has %s", text)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "logs", "order-service.log.*"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("rotated files are %v %v", files, err)
+	}
+}
+
+func TestNewLogrusLogFromConfigError(t *testing.T) {
+
+	testCases := []struct {
+		name string
+		cfg  config.Logging
+	}{
+		{name: "unknown level", cfg: config.Logging{Level: "loud", Outputs: []string{"stdout"}}},
+		{name: "unknown output", cfg: config.Logging{Outputs: []string{"syslog"}}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if _, err := NewLogrusLogFromConfig(testAppData, testCase.cfg); err == nil {
+				t.Fatal("invalid config is accepted")
+			}
+		})
+	}
+}
+
+func TestNewLogrusLogFromConfigTextFormat(t *testing.T) {
+
+	dir := t.TempDir()
+
+	log, err := NewLogrusLogFromConfig(testAppData, config.Logging{Format: "text", Outputs: []string{"file"}, File: config.LogFile{Path: dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log.Info(context.Background(), "order is created")
+
+	// the empty file name is the app name
+	bytes, err := os.ReadFile(filepath.Join(dir, "order.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if text := string(bytes); !strings.Contains(text, `msg="order is created"`) || !strings.Contains(text, "appName=order") {
+		t.Fatalf("log file has %s", text)
+	}
+}
@@ -0,0 +1,326 @@
+package messaging
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"infrastructure/shared/model/payload"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Codec encode the payload into the message body and decode it back.
+// The ContentType is written in the message header so the subscriber can pick the right Codec
+type Codec interface {
+	ContentType() string
+	Marshal(data payload.Payload) ([]byte, error)
+	Unmarshal(body []byte, data *payload.Payload) error
+}
+
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeMsgPack  = "application/msgpack"
+	ContentTypeCBOR     = "application/cbor"
+	ContentTypeProtobuf = "application/x-protobuf"
+
+	// contentTypeText is the content type used by the old publisher, the body is a json
+	contentTypeText = "text/plain"
+
+	ContentEncodingGzip = "gzip"
+)
+
+var codecs = struct {
+	sync.RWMutex
+	items map[string]Codec
+}{
+	items: map[string]Codec{
+		ContentTypeJSON:     JSONCodec{},
+		ContentTypeMsgPack:  MsgPackCodec{},
+		ContentTypeCBOR:     CBORCodec{},
+		ContentTypeProtobuf: ProtobufCodec{},
+	},
+}
+
+// RegisterCodec add the new codec or replace the existing one with the same content type
+func RegisterCodec(codec Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.items[codec.ContentType()] = codec
+}
+
+func getCodec(contentType string) (Codec, error) {
+
+	if contentType == "" || contentType == contentTypeText {
+		return JSONCodec{}, nil
+	}
+
+	codecs.RLock()
+	defer codecs.RUnlock()
+
+	codec, exist := codecs.items[contentType]
+	if !exist {
+		return nil, fmt.Errorf("codec for content type %s is not registered", contentType)
+	}
+
+	return codec, nil
+}
+
+// =======================================
+
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (JSONCodec) Marshal(data payload.Payload) ([]byte, error) {
+	return json.Marshal(data)
+}
+
+func (JSONCodec) Unmarshal(body []byte, data *payload.Payload) error {
+	return json.Unmarshal(body, data)
+}
+
+// =======================================
+
+// MsgPackCodec use the json tag as the field name so the message has the same shape as the JSONCodec
+type MsgPackCodec struct{}
+
+func (MsgPackCodec) ContentType() string {
+	return ContentTypeMsgPack
+}
+
+func (MsgPackCodec) Marshal(data payload.Payload) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	err := enc.Encode(data)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (MsgPackCodec) Unmarshal(body []byte, data *payload.Payload) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(body))
+	dec.SetCustomStructTag("json")
+	// the map is decoded as map[string]any like the json so DecodeData can marshal it again
+	dec.SetMapDecoder(func(d *msgpack.Decoder) (any, error) {
+		return d.DecodeMap()
+	})
+	return dec.Decode(data)
+}
+
+// =======================================
+
+// CBORCodec use the json tag as the field name like the MsgPackCodec, the map in the Data is decoded as map[string]any
+type CBORCodec struct{}
+
+var cborDecMode = func() cbor.DecMode {
+	mode, err := cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]any{})}.DecMode()
+	if err != nil {
+		panic(err.Error())
+	}
+	return mode
+}()
+
+func (CBORCodec) ContentType() string {
+	return ContentTypeCBOR
+}
+
+func (CBORCodec) Marshal(data payload.Payload) ([]byte, error) {
+	return cbor.Marshal(data)
+}
+
+func (CBORCodec) Unmarshal(body []byte, data *payload.Payload) error {
+	return cborDecMode.Unmarshal(body, data)
+}
+
+// =======================================
+
+// ProtobufCodec encode the payload as google.protobuf.Struct since the Data has no fixed schema.
+// Every number in google.protobuf.Struct is a double, so Marshal reject the integer bigger than 2^53
+// which can not be kept exactly. Send the big id like int64 snowflake as the string
+type ProtobufCodec struct{}
+
+// maxExactInteger is the biggest integer that a float64 can hold without losing precision
+const maxExactInteger = 1 << 53
+
+func (ProtobufCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (ProtobufCodec) Marshal(data payload.Payload) ([]byte, error) {
+
+	jsonInBytes, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(jsonInBytes))
+	decoder.UseNumber()
+
+	var obj map[string]any
+	err = decoder.Decode(&obj)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := toExactFloat(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := structpb.NewStruct(value.(map[string]any))
+	if err != nil {
+		return nil, err
+	}
+
+	return proto.Marshal(message)
+}
+
+func (ProtobufCodec) Unmarshal(body []byte, data *payload.Payload) error {
+
+	var message structpb.Struct
+	err := proto.Unmarshal(body, &message)
+	if err != nil {
+		return err
+	}
+
+	jsonInBytes, err := json.Marshal(message.AsMap())
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(jsonInBytes, data)
+}
+
+// toExactFloat replace every json.Number with float64 and return the error if the number lose the precision
+func toExactFloat(value any) (any, error) {
+
+	switch v := value.(type) {
+
+	case map[string]any:
+		for key, item := range v {
+			converted, err := toExactFloat(item)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = converted
+		}
+		return v, nil
+
+	case []any:
+		for i, item := range v {
+			converted, err := toExactFloat(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = converted
+		}
+		return v, nil
+
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			if i > maxExactInteger || i < -maxExactInteger {
+				return nil, fmt.Errorf("protobuf codec can not keep the integer %s exactly, send it as the string", v)
+			}
+			return float64(i), nil
+		}
+
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("protobuf codec can not keep the number %s exactly, send it as the string", v)
+		}
+		return f, nil
+	}
+
+	return value, nil
+}
+
+// =======================================
+
+// encodeMessage marshal the payload with the codec and gzip it if the size is above the threshold.
+// threshold 0 means never compress
+func encodeMessage(codec Codec, compressThreshold int, data payload.Payload) (body []byte, contentEncoding string, err error) {
+
+	body, err = codec.Marshal(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if compressThreshold <= 0 || len(body) < compressThreshold {
+		return body, "", nil
+	}
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+
+	_, err = writer.Write(body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), ContentEncodingGzip, nil
+}
+
+// decodeMessage pick the codec by the content type and decompress the body if needed.
+// The decompressed body bigger than maxBodySize is rejected so the small gzip bomb can not take all the memory, 0 means no limit
+func decodeMessage(body []byte, contentType, contentEncoding string, maxBodySize int64) (payload.Payload, error) {
+
+	var data payload.Payload
+
+	codec, err := getCodec(contentType)
+	if err != nil {
+		return data, err
+	}
+
+	switch contentEncoding {
+	case "":
+	case ContentEncodingGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return data, err
+		}
+		body, err = readAllLimit(reader, maxBodySize)
+		if err != nil {
+			return data, err
+		}
+	default:
+		return data, fmt.Errorf("content encoding %s is not supported", contentEncoding)
+	}
+
+	err = codec.Unmarshal(body, &data)
+	return data, err
+}
+
+// readAllLimit read one more byte than the limit to know the body is too big
+func readAllLimit(reader io.Reader, limit int64) ([]byte, error) {
+
+	if limit <= 0 {
+		return io.ReadAll(reader)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("decompressed body is bigger than %d bytes", limit)
+	}
+
+	return body, nil
+}
@@ -0,0 +1,108 @@
+package messaging
+
+import (
+	"bytes"
+	"infrastructure/shared/driver"
+	"infrastructure/shared/model/payload"
+	"strings"
+	"testing"
+)
+
+type orderCreated struct {
+	OrderID string   `json:"orderID"`
+	Amount  float64  `json:"amount"`
+	Items   []string `json:"items"`
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+
+	data := payload.Payload{
+		ID:        "M1",
+		Data:      orderCreated{OrderID: "O1", Amount: 12.5, Items: []string{"A", "B"}},
+		EventType: "order.created",
+		Publisher: driver.ApplicationData{AppName: "order"},
+		TraceID:   "4bf92f3577b34da6a3ce929d0e0e4736",
+	}
+
+	for _, codec := range []Codec{JSONCodec{}, MsgPackCodec{}, CBORCodec{}, ProtobufCodec{}} {
+		for _, compressThreshold := range []int{0, 1} {
+			t.Run(codec.ContentType(), func(t *testing.T) {
+
+				body, contentEncoding, err := encodeMessage(codec, compressThreshold, data)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if (contentEncoding == ContentEncodingGzip) != (compressThreshold > 0) {
+					t.Fatalf("content encoding is %q", contentEncoding)
+				}
+
+				received, err := decodeMessage(body, codec.ContentType(), contentEncoding, defaultMaxBodySize)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if received.ID != data.ID || received.EventType != data.EventType || received.TraceID != data.TraceID || received.Publisher.AppName != "order" {
+					t.Fatalf("received %+v", received)
+				}
+
+				// the Data is decoded as the generic map then into the concrete type
+				if _, ok := received.Data.(map[string]any); !ok {
+					t.Fatalf("data is %T", received.Data)
+				}
+
+				var event orderCreated
+				if err := received.DecodeData(&event); err != nil {
+					t.Fatal(err)
+				}
+
+				if event.OrderID != "O1" || event.Amount != 12.5 || len(event.Items) != 2 {
+					t.Fatalf("event is %+v", event)
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeMessageLimitTheDecompressedSize(t *testing.T) {
+
+	// the big body of the same byte is compressed into a few kilobytes
+	data := payload.Payload{Data: strings.Repeat("a", 1<<20)}
+
+	body, contentEncoding, err := encodeMessage(JSONCodec{}, 1, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(body) > 1<<14 {
+		t.Fatalf("compressed body is %d bytes", len(body))
+	}
+
+	if _, err := decodeMessage(body, ContentTypeJSON, contentEncoding, 1<<16); err == nil || !strings.Contains(err.Error(), "bigger than") {
+		t.Fatalf("decode of the big body return %v", err)
+	}
+
+	if _, err := decodeMessage(body, ContentTypeJSON, contentEncoding, 2<<20); err != nil {
+		t.Fatalf("decode within the limit return %v", err)
+	}
+
+	if _, err := decodeMessage(body, ContentTypeJSON, contentEncoding, 0); err != nil {
+		t.Fatalf("decode without the limit return %v", err)
+	}
+}
+
+func TestDecodeMessageOfTheOldPublisher(t *testing.T) {
+
+	received, err := decodeMessage([]byte(`{"data": {"id": "O1"}, "traceId": "T1"}`), "text/plain", "", defaultMaxBodySize)
+	if err != nil || orderID(received) != "O1" || received.TraceID != "T1" {
+		t.Fatalf("received %+v %v", received, err)
+	}
+
+	if _, err := decodeMessage([]byte("{}"), "application/unknown", "", defaultMaxBodySize); err == nil {
+		t.Fatal("unknown content type is decoded")
+	}
+
+	if _, err := decodeMessage(bytes.Repeat([]byte("x"), 10), ContentTypeJSON, "br", defaultMaxBodySize); err == nil {
+		t.Fatal("unknown content encoding is decoded")
+	}
+}
@@ -1,16 +1,25 @@
 package messaging
 
 import (
+	"context"
+	"errors"
 	"infrastructure/shared/model/payload"
 )
 
 type Publisher interface {
-	Publish(topic string, delayInMS int, payload payload.Payload) error
+	Publish(ctx context.Context, topic string, delayInMS int, payload payload.Payload) error
 }
 
-type HandleFunc func(payload payload.Payload, err error)
+// HandleFunc receive the context with the trace id of the publisher.
+// Returning error tell the broker that the message is failed to be handled
+type HandleFunc func(ctx context.Context, payload payload.Payload, err error) error
+
+// ErrRequeue is wrapped by the handler error when the message must be handled again, like the conflict of the optimistic lock.
+// RabbitMQ put the message back to the queue instead of the dead letter exchange, the other broker already redeliver the failed message
+var ErrRequeue = errors.New("requeue the message")
 
 type Subscriber interface {
-	Handle(topic string, onReceived HandleFunc)
+	Handle(topic string, onReceived HandleFunc, option ...HandleOption)
+	Use(middlewares ...Middleware)
 	Run(url string)
 }
@@ -0,0 +1,190 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"infrastructure/shared/model/payload"
+	"reflect"
+	"sync"
+)
+
+// eventRegistry map the event type name to the go type and vice versa
+type eventRegistry struct {
+	mutex      sync.RWMutex
+	typeByName map[string]reflect.Type
+	nameByType map[reflect.Type]string
+}
+
+var registry = &eventRegistry{
+	typeByName: map[string]reflect.Type{},
+	nameByType: map[reflect.Type]string{},
+}
+
+// EventTyper is implemented by the event which give its own name without RegisterEvent
+//
+//	func (OrderCreated) EventType() string { return "order.created" }
+type EventTyper interface {
+	EventType() string
+}
+
+// RegisterEvent give the name to the event struct. The name is written into the payload EventType when it is published.
+// Put the version in the name to carry several version of the same event in one topic.
+// The pointer of the event has the same name as the event
+//
+//	messaging.RegisterEvent[OrderCreatedV1]("order.created.v1")
+//	messaging.RegisterEvent[OrderCreatedV2]("order.created.v2")
+func RegisterEvent[T any](eventType string) {
+
+	theType := eventGoType[T]()
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if existing, exist := registry.typeByName[eventType]; exist && existing != theType {
+		panic(fmt.Sprintf("event type %s is already registered for %s", eventType, existing.String()))
+	}
+
+	registry.typeByName[eventType] = theType
+	registry.nameByType[theType] = eventType
+}
+
+// EventTypeOf return the registered name of T, the name from its EventType method,
+// or the go type name with the package path like "example.com/order/domain.OrderCreated"
+func EventTypeOf[T any]() string {
+
+	theType := eventGoType[T]()
+
+	registry.mutex.RLock()
+	name, exist := registry.nameByType[theType]
+	registry.mutex.RUnlock()
+
+	if exist {
+		return name
+	}
+
+	if typer, ok := reflect.New(theType).Interface().(EventTyper); ok {
+		return typer.EventType()
+	}
+
+	// the type without the name like map[string]any
+	if theType.Name() == "" {
+		return theType.String()
+	}
+
+	return theType.PkgPath() + "." + theType.Name()
+}
+
+// eventGoType return T without the pointer
+func eventGoType[T any]() reflect.Type {
+	theType := reflect.TypeOf((*T)(nil)).Elem()
+	for theType.Kind() == reflect.Pointer {
+		theType = theType.Elem()
+	}
+	return theType
+}
+
+// DecodeEvent decode the payload Data into the new instance of the go type registered for the payload EventType.
+// The returned value is a pointer to that type
+func DecodeEvent(data payload.Payload) (any, error) {
+
+	registry.mutex.RLock()
+	theType, exist := registry.typeByName[data.EventType]
+	registry.mutex.RUnlock()
+
+	if !exist {
+		return nil, fmt.Errorf("event type %s is not registered", data.EventType)
+	}
+
+	obj := reflect.New(theType).Interface()
+
+	err := data.DecodeData(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+// Publish send the event with the EventType taken from the registered name of T
+func Publish[T any](ctx context.Context, publisher Publisher, topic string, delayInMS int, event T) error {
+	return publisher.Publish(ctx, topic, delayInMS, payload.Payload{
+		Data:      event,
+		EventType: EventTypeOf[T](),
+	})
+}
+
+// TypedHandleFunc receive the event that is already decoded from the payload Data
+type TypedHandleFunc[T any] func(ctx context.Context, event T, payload payload.Payload, err error) error
+
+// Handle register the typed handler as the only handler of the topic.
+// Payload without EventType (published by the old publisher) is decoded into T as well.
+// Use EventRouter to handle several event types in one topic
+func Handle[T any](subscriber Subscriber, topic string, onReceived TypedHandleFunc[T], option ...HandleOption) {
+	subscriber.Handle(topic, func(ctx context.Context, data payload.Payload, err error) error {
+
+		if err == nil && data.EventType != "" && data.EventType != EventTypeOf[T]() {
+			err = fmt.Errorf("unexpected event type %s in topic %s", data.EventType, topic)
+		}
+
+		event, err := decodeEvent[T](data, err)
+		return onReceived(ctx, event, data, err)
+
+	}, option...)
+}
+
+func decodeEvent[T any](data payload.Payload, err error) (T, error) {
+	var event T
+	if err != nil {
+		return event, err
+	}
+	err = data.DecodeData(&event)
+	return event, err
+}
+
+// EventRouter dispatch the message of one topic to the typed handler by the payload EventType
+//
+//	router := messaging.NewEventRouter()
+//	messaging.On(router, onOrderCreatedV1)
+//	messaging.On(router, onOrderCreatedV2)
+//	subscriber.Handle("order", router.HandleFunc)
+type EventRouter struct {
+	handlers       map[string]HandleFunc
+	defaultHandler HandleFunc
+}
+
+func NewEventRouter() *EventRouter {
+	return &EventRouter{
+		handlers: map[string]HandleFunc{},
+	}
+}
+
+// On register the typed handler for the event type of T
+func On[T any](router *EventRouter, onReceived TypedHandleFunc[T]) {
+	router.handlers[EventTypeOf[T]()] = func(ctx context.Context, data payload.Payload, err error) error {
+		event, err := decodeEvent[T](data, err)
+		return onReceived(ctx, event, data, err)
+	}
+}
+
+// Default register the handler for the message which has no matching typed handler.
+// Without the default handler that message is ignored
+func (r *EventRouter) Default(onReceived HandleFunc) *EventRouter {
+	r.defaultHandler = onReceived
+	return r
+}
+
+// HandleFunc is the HandleFunc to be registered to Subscriber
+func (r *EventRouter) HandleFunc(ctx context.Context, data payload.Payload, err error) error {
+
+	if err == nil {
+		if handler, exist := r.handlers[data.EventType]; exist {
+			return handler(ctx, data, err)
+		}
+	}
+
+	if r.defaultHandler != nil {
+		return r.defaultHandler(ctx, data, err)
+	}
+
+	return err
+}
@@ -0,0 +1,90 @@
+package messaging
+
+import (
+	"context"
+	"infrastructure/shared/model/payload"
+	"testing"
+)
+
+type orderPaid struct {
+	OrderID string `json:"orderID"`
+}
+
+type orderShipped struct {
+	OrderID string `json:"orderID"`
+}
+
+func (orderShipped) EventType() string {
+	return "order.shipped"
+}
+
+type orderCancelled struct {
+	OrderID string `json:"orderID"`
+}
+
+func TestEventTypeOf(t *testing.T) {
+
+	RegisterEvent[*orderCancelled]("order.cancelled.v1")
+
+	const pkgPath = "infrastructure/shared/infrastructure/messaging"
+
+	testCases := []struct {
+		name   string
+		actual string
+		want   string
+	}{
+		{name: "go type with the package path", actual: EventTypeOf[orderPaid](), want: pkgPath + ".orderPaid"},
+		{name: "pointer has the name of the type", actual: EventTypeOf[*orderPaid](), want: pkgPath + ".orderPaid"},
+		{name: "EventType method", actual: EventTypeOf[orderShipped](), want: "order.shipped"},
+		{name: "EventType method of the pointer", actual: EventTypeOf[*orderShipped](), want: "order.shipped"},
+		{name: "registered pointer", actual: EventTypeOf[orderCancelled](), want: "order.cancelled.v1"},
+		{name: "unnamed type", actual: EventTypeOf[map[string]any](), want: "map[string]interface {}"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.actual != testCase.want {
+				t.Fatalf("event type is %q, want %q", testCase.actual, testCase.want)
+			}
+		})
+	}
+}
+
+func TestHandleThePointerEvent(t *testing.T) {
+
+	broker := NewInProcessBroker()
+
+	received := make(chan *orderShipped, 1)
+	Handle(broker, "orders", func(ctx context.Context, event *orderShipped, data payload.Payload, err error) error {
+		if err == nil {
+			received <- event
+		}
+		return err
+	})
+
+	if err := Publish(context.Background(), broker, "orders", 0, orderShipped{OrderID: "O1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if event := <-received; event == nil || event.OrderID != "O1" {
+		t.Fatalf("event is %+v", event)
+	}
+}
+
+func TestDecodeEvent(t *testing.T) {
+
+	RegisterEvent[orderCancelled]("order.cancelled.v1")
+
+	event, err := DecodeEvent(payload.Payload{EventType: "order.cancelled.v1", Data: map[string]any{"orderID": "O1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cancelled, ok := event.(*orderCancelled); !ok || cancelled.OrderID != "O1" {
+		t.Fatalf("event is %#v", event)
+	}
+
+	if _, err := DecodeEvent(payload.Payload{EventType: "order.unknown"}); err == nil {
+		t.Fatal("unknown event type is decoded")
+	}
+}
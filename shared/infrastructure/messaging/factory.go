@@ -0,0 +1,189 @@
+package messaging
+
+import (
+	"fmt"
+	"infrastructure/shared/infrastructure/cache"
+	"infrastructure/shared/infrastructure/config"
+)
+
+// NewMessagingFromConfig create the publisher and the subscriber of the broker in the config.
+// The inprocess broker is created for each call and returned as both so the published message reach the handler
+//
+//	publisher, subscriber, err := messaging.NewMessagingFromConfig(cfg.Messaging)
+//	subscriber.Handle("order.created", onOrderCreated, messaging.HandleOptionFromConfig(cfg.Messaging))
+func NewMessagingFromConfig(cfg config.Messaging, option ...PublishOption) (Publisher, Subscriber, error) {
+
+	if cfg.Broker == config.BrokerInProcess {
+		opt, err := publishOptionFromConfig(cfg, getPublishOption(option))
+		if err != nil {
+			return nil, nil, err
+		}
+		broker := NewInProcessBroker(opt)
+		return broker, broker, nil
+	}
+
+	publisher, err := NewPublisherFromConfig(cfg, option...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	subscriber, err := NewSubscriberFromConfig(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return publisher, subscriber, nil
+}
+
+// NewPublisherFromConfig create the publisher of the broker in the config.
+// The option is used as the base, the codec and the compress threshold in the config override it.
+// The inprocess broker need the subscriber of the same broker so it is created by NewMessagingFromConfig
+//
+//	cfg, _, err := config.LoadConfig(config.NewDefaultLoadOption())
+//	publisher, err := messaging.NewPublisherFromConfig(cfg.Messaging)
+func NewPublisherFromConfig(cfg config.Messaging, option ...PublishOption) (Publisher, error) {
+
+	opt, err := publishOptionFromConfig(cfg, getPublishOption(option))
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Broker {
+
+	case config.BrokerRabbitMQ:
+		publisher, err := NewPublisher(cfg.URL.Value(), opt)
+		if err != nil {
+			return nil, err
+		}
+		publisher.topology = topologyFromConfig(cfg.Topology)
+		return publisher, nil
+
+	case config.BrokerNSQ:
+		return newPublisherNSQ(cfg.URL.Value(), opt)
+
+	case config.BrokerKafka:
+		return NewPublisherKafka(cfg.URL.Value(), opt), nil
+
+	case config.BrokerRedis:
+		client, err := cache.NewRedisClient(cfg.Redis)
+		if err != nil {
+			return nil, err
+		}
+		return NewPublisherRedisStream(client, cfg.StreamMaxLen, opt), nil
+
+	case config.BrokerInProcess:
+		return nil, errInProcessFromConfig
+	}
+
+	return nil, fmt.Errorf("unknown messaging broker %q", cfg.Broker)
+}
+
+// NewSubscriberFromConfig create the subscriber of the broker in the config with the group as the queue name, channel or group id.
+// Run it with the url in the config
+//
+//	subscriber, err := messaging.NewSubscriberFromConfig(cfg.Messaging)
+//	subscriber.Handle("order.created", onOrderCreated, messaging.HandleOptionFromConfig(cfg.Messaging))
+//	subscriber.Run(cfg.Messaging.URL.Value())
+func NewSubscriberFromConfig(cfg config.Messaging) (Subscriber, error) {
+
+	switch cfg.Broker {
+
+	case config.BrokerRabbitMQ:
+		return &subscriberImpl{
+			queueName: cfg.Group,
+			topology:  topologyFromConfig(cfg.Topology),
+			topicMap:  map[string]HandleFunc{},
+			optionMap: map[string]HandleOption{},
+		}, nil
+
+	case config.BrokerNSQ:
+		return NewSubscriberNSQ(cfg.Group), nil
+
+	case config.BrokerKafka:
+		return NewSubscriberKafka(cfg.Group), nil
+
+	case config.BrokerRedis:
+		client, err := cache.NewRedisClient(cfg.Redis)
+		if err != nil {
+			return nil, err
+		}
+		return NewSubscriberRedisStream(client, cfg.Group), nil
+
+	case config.BrokerInProcess:
+		return nil, errInProcessFromConfig
+	}
+
+	return nil, fmt.Errorf("unknown messaging broker %q", cfg.Broker)
+}
+
+var errInProcessFromConfig = fmt.Errorf("inprocess broker must be created by NewMessagingFromConfig")
+
+// publishOptionFromConfig override the codec and the compress threshold of the option with the value in the config
+func publishOptionFromConfig(cfg config.Messaging, opt PublishOption) (PublishOption, error) {
+
+	if cfg.Codec != "" {
+		codec, err := getCodec(cfg.Codec)
+		if err != nil {
+			return PublishOption{}, err
+		}
+		opt.Codec = codec
+	}
+
+	if cfg.CompressThreshold > 0 {
+		opt.CompressThreshold = cfg.CompressThreshold
+	}
+
+	return opt, nil
+}
+
+// HandleOptionFromConfig return the default handle option with the concurrency and the prefetch in the config
+func HandleOptionFromConfig(cfg config.Messaging) HandleOption {
+
+	opt := NewDefaultHandleOption()
+
+	if cfg.Concurrency > 0 {
+		opt = opt.SetConcurrency(cfg.Concurrency)
+	}
+
+	if cfg.Prefetch > 0 {
+		opt = opt.SetPrefetch(cfg.Prefetch)
+	}
+
+	return getHandleOption([]HandleOption{opt})
+}
+
+// topologyFromConfig keep the default topology for the value which is not set in the config
+func topologyFromConfig(cfg config.Topology) config.Topology {
+
+	topology := defaultTopology()
+
+	if cfg.Exchange != "" {
+		topology.Exchange = cfg.Exchange
+	}
+
+	if cfg.ExchangeType != "" {
+		topology.ExchangeType = cfg.ExchangeType
+	}
+
+	if cfg.DurableQueue {
+		topology.DurableQueue = true
+	}
+
+	if cfg.DeadLetterExchange != "" {
+		topology.DeadLetterExchange = cfg.DeadLetterExchange
+	}
+
+	return topology
+}
+
+// newPublisherNSQ is NewPublisherNSQ returning the error instead of panic
+func newPublisherNSQ(url string, option PublishOption) (publisher Publisher, err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("cannot create nsq producer: %v", r)
+		}
+	}()
+
+	return NewPublisherNSQ(url, option), nil
+}
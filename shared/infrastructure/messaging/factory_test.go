@@ -0,0 +1,162 @@
+package messaging
+
+import (
+	"context"
+	"infrastructure/shared/infrastructure/config"
+	"infrastructure/shared/model/payload"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestNewMessagingFromConfigInProcess(t *testing.T) {
+
+	cfg := config.Messaging{Broker: config.BrokerInProcess, Codec: ContentTypeMsgPack, CompressThreshold: 10}
+
+	publisher, subscriber, err := NewMessagingFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker, ok := publisher.(*InProcessBroker)
+	if !ok || subscriber != Subscriber(broker) {
+		t.Fatalf("publisher %T and subscriber %T are not the same broker", publisher, subscriber)
+	}
+
+	if broker.option.Codec.ContentType() != ContentTypeMsgPack || broker.option.CompressThreshold != 10 {
+		t.Fatalf("option of the config is not used, %+v", broker.option)
+	}
+
+	// each call has its own broker so the option of the other call is not shared
+	other, _, err := NewMessagingFromConfig(config.Messaging{Broker: config.BrokerInProcess})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if other == publisher || other.(*InProcessBroker).option.Codec.ContentType() != ContentTypeJSON {
+		t.Fatal("inprocess broker is shared between the calls")
+	}
+
+	received := make(chan payload.Payload, 1)
+	subscriber.Handle("orders", func(ctx context.Context, data payload.Payload, err error) error {
+		received <- data
+		return err
+	})
+
+	if err := other.Publish(context.Background(), "orders", 0, payload.Payload{Data: map[string]any{"id": "O0"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := publisher.Publish(context.Background(), "orders", 0, payload.Payload{Data: map[string]any{"id": "O1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if data := <-received; orderID(data) != "O1" {
+		t.Fatalf("received %v", data)
+	}
+}
+
+func TestNewFromConfigRejectTheSeparateInProcess(t *testing.T) {
+
+	cfg := config.Messaging{Broker: config.BrokerInProcess}
+
+	if _, err := NewPublisherFromConfig(cfg); err == nil {
+		t.Fatal("inprocess publisher is created without its subscriber")
+	}
+
+	if _, err := NewSubscriberFromConfig(cfg); err == nil {
+		t.Fatal("inprocess subscriber is created without its publisher")
+	}
+}
+
+func TestNewMessagingFromConfigRedis(t *testing.T) {
+
+	server := miniredis.RunT(t)
+
+	cfg := config.Messaging{Broker: config.BrokerRedis, Group: "order", StreamMaxLen: 100}
+	cfg.Redis.Address = server.Addr()
+
+	publisher, subscriber, err := NewMessagingFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redisPublisher, ok := publisher.(*publisherRedisStreamImpl)
+	if !ok || redisPublisher.maxLen != 100 {
+		t.Fatalf("publisher is %T %+v", publisher, publisher)
+	}
+
+	if redisSubscriber, ok := subscriber.(*subscriberRedisStreamImpl); !ok || redisSubscriber.group != "order" {
+		t.Fatalf("subscriber is %T %+v", subscriber, subscriber)
+	}
+
+	if err := publisher.Publish(context.Background(), "orders", 0, payload.Payload{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if length := redisPublisher.client.XLen(context.Background(), "orders").Val(); length != 1 {
+		t.Fatalf("stream has %d messages", length)
+	}
+}
+
+func TestNewMessagingFromConfigError(t *testing.T) {
+
+	testCases := []struct {
+		name string
+		cfg  config.Messaging
+	}{
+		{name: "unknown broker", cfg: config.Messaging{Broker: "pigeon"}},
+		{name: "unknown codec", cfg: config.Messaging{Broker: config.BrokerInProcess, Codec: "application/xml"}},
+		{name: "invalid redis", cfg: config.Messaging{Broker: config.BrokerRedis, Redis: config.Cache{Mode: "ring"}}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if _, _, err := NewMessagingFromConfig(testCase.cfg); err == nil {
+				t.Fatal("invalid config is accepted")
+			}
+		})
+	}
+}
+
+func TestTopologyFromConfig(t *testing.T) {
+
+	testCases := []struct {
+		name string
+		cfg  config.Topology
+		want config.Topology
+	}{
+		{name: "empty keep the default", cfg: config.Topology{}, want: defaultTopology()},
+		{
+			name: "set value override the default",
+			cfg:  config.Topology{Exchange: "orders", ExchangeType: "topic", DurableQueue: true, DeadLetterExchange: "orders.dlx"},
+			want: config.Topology{Exchange: "orders", ExchangeType: "topic", DurableQueue: true, DeadLetterExchange: "orders.dlx"},
+		},
+		{
+			name: "only the dead letter exchange",
+			cfg:  config.Topology{DeadLetterExchange: "orders.dlx"},
+			want: config.Topology{Exchange: exchangeName, ExchangeType: exchangeType, DeadLetterExchange: "orders.dlx"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if topology := topologyFromConfig(testCase.cfg); topology != testCase.want {
+				t.Fatalf("topology is %+v, want %+v", topology, testCase.want)
+			}
+		})
+	}
+}
+
+func TestHandleOptionFromConfig(t *testing.T) {
+
+	opt := HandleOptionFromConfig(config.Messaging{Concurrency: 4, Prefetch: 20})
+	if opt.Concurrency != 4 || opt.Prefetch != 20 {
+		t.Fatalf("option is %+v", opt)
+	}
+
+	defaults := HandleOptionFromConfig(config.Messaging{})
+	if defaults.Concurrency != NewDefaultHandleOption().Concurrency || defaults.MaxBodySize != defaultMaxBodySize {
+		t.Fatalf("default option is %+v", defaults)
+	}
+}
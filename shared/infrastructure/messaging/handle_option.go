@@ -0,0 +1,113 @@
+package messaging
+
+import (
+	"infrastructure/shared/model/payload"
+)
+
+// HandleOption control how the messages of one topic are consumed.
+// Start from NewDefaultHandleOption then override the value with the setter
+//
+//	opt := messaging.NewDefaultHandleOption().
+//		SetConcurrency(4).
+//		SetPartitionKey(func(p payload.Payload) string {
+//			var event OrderCreated
+//			_ = p.DecodeData(&event)
+//			return event.OrderID
+//		})
+//
+//	subscriber.Handle("order.created", onOrderCreated, opt)
+type HandleOption struct {
+
+	// Concurrency is the number of worker that run the handler in parallel
+	Concurrency int
+
+	// Prefetch is the maximum number of message that is pulled from the broker but not yet handled.
+	// When all the workers are busy the broker will stop delivering new message
+	Prefetch int
+
+	// PartitionKey (optional) extract the key from the payload.
+	// Messages with the same key are always handled by the same worker in the order they are received
+	PartitionKey func(payload payload.Payload) string
+
+	// Middlewares only wrap the handler of this topic, after the middlewares registered by Subscriber.Use
+	Middlewares []Middleware
+
+	// MaxBodySize is the maximum size in bytes of the decompressed message body.
+	// The bigger message is given to the handler with the decode error. 0 means no limit
+	MaxBodySize int64
+}
+
+// defaultMaxBodySize is also used to decode the rpc reply which has no HandleOption
+const defaultMaxBodySize = 32 << 20
+
+func NewDefaultHandleOption() HandleOption {
+	return HandleOption{
+		Concurrency: 1,
+		Prefetch:    10,
+		MaxBodySize: defaultMaxBodySize,
+	}
+}
+
+func (h HandleOption) SetConcurrency(concurrency int) HandleOption {
+	h.Concurrency = concurrency
+	return h
+}
+
+func (h HandleOption) SetPrefetch(prefetch int) HandleOption {
+	h.Prefetch = prefetch
+	return h
+}
+
+func (h HandleOption) SetPartitionKey(partitionKey func(payload payload.Payload) string) HandleOption {
+	h.PartitionKey = partitionKey
+	return h
+}
+
+func (h HandleOption) SetMiddlewares(middlewares ...Middleware) HandleOption {
+	h.Middlewares = middlewares
+	return h
+}
+
+func (h HandleOption) SetMaxBodySize(maxBodySize int64) HandleOption {
+	h.MaxBodySize = maxBodySize
+	return h
+}
+
+// getHandleOption return the first option or the default one and fix the invalid value
+func getHandleOption(options []HandleOption) HandleOption {
+
+	opt := NewDefaultHandleOption()
+	if len(options) > 0 {
+		opt = options[0]
+	}
+
+	if opt.Concurrency < 1 {
+		opt.Concurrency = 1
+	}
+
+	if opt.Prefetch < opt.Concurrency {
+		opt.Prefetch = opt.Concurrency
+	}
+
+	return opt
+}
+
+func newWorkerPoolFromOption(option HandleOption) *workerPool {
+	return newWorkerPool(option.Concurrency, option.Prefetch, option.PartitionKey != nil)
+}
+
+// partitionKey return the key of the payload or empty string if the option has no partition key.
+// The PartitionKey which panic on the unexpected payload give the empty key instead of stopping the consumer
+func partitionKey(option HandleOption, data payload.Payload, err error) (key string) {
+	if option.PartitionKey == nil || err != nil {
+		return ""
+	}
+
+	defer func() {
+		if recover() != nil {
+			key = ""
+		}
+	}()
+
+	return option.PartitionKey(data)
+}
@@ -0,0 +1,169 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"infrastructure/shared/model/payload"
+	"sync"
+	"time"
+)
+
+// inProcessReplyTo is the reply address of the request sent through InProcessBroker
+const inProcessReplyTo = "inprocess.reply"
+
+// InProcessBroker deliver the message in memory through the same codec and middleware as the real broker.
+// It implements Publisher, Subscriber, Requester and Replier so the code using messaging can be tested without RabbitMQ or NSQ.
+// The message without delay is handled synchronously inside Publish
+//
+//	broker := messaging.NewInProcessBroker()
+//	broker.Handle("user.get", messaging.HandleRPC(broker, onUserGet))
+//	user, err := messaging.Request[GetUserRequest, User](ctx, broker, "user.get", time.Second, GetUserRequest{ID: "1"})
+type InProcessBroker struct {
+	option PublishOption
+
+	mutex       sync.RWMutex
+	topicMap    map[string]HandleFunc
+	optionMap   map[string]HandleOption
+	middlewares []Middleware
+	pending     map[string]chan payload.Payload
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewInProcessBroker(option ...PublishOption) *InProcessBroker {
+	return &InProcessBroker{
+		option:    getPublishOption(option),
+		topicMap:  map[string]HandleFunc{},
+		optionMap: map[string]HandleOption{},
+		pending:   map[string]chan payload.Payload{},
+		done:      make(chan struct{}),
+	}
+}
+
+func (r *InProcessBroker) Handle(topic string, onReceived HandleFunc, option ...HandleOption) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.topicMap[topic] = onReceived
+	r.optionMap[topic] = getHandleOption(option)
+}
+
+func (r *InProcessBroker) Use(middlewares ...Middleware) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+// Run only block until Close is called, the url is ignored
+func (r *InProcessBroker) Run(url string) {
+	<-r.done
+}
+
+// Close release the Run
+func (r *InProcessBroker) Close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+	})
+}
+
+func (r *InProcessBroker) Publish(ctx context.Context, topic string, delayInMS int, data payload.Payload) error {
+	return r.publish(ctx, topic, delayInMS, data, "", "")
+}
+
+func (r *InProcessBroker) Request(ctx context.Context, topic string, timeout time.Duration, request payload.Payload) (payload.Payload, error) {
+
+	correlationID := randomHex(16)
+	replyChan := make(chan payload.Payload, 1)
+
+	r.mutex.Lock()
+	r.pending[correlationID] = replyChan
+	r.mutex.Unlock()
+
+	defer func() {
+		r.mutex.Lock()
+		delete(r.pending, correlationID)
+		r.mutex.Unlock()
+	}()
+
+	err := r.publish(ctx, topic, 0, request, inProcessReplyTo, correlationID)
+	if err != nil {
+		return payload.Payload{}, err
+	}
+
+	return waitReply(ctx, timeout, replyChan)
+}
+
+func (r *InProcessBroker) Reply(ctx context.Context, response payload.Payload) error {
+
+	replyTo, correlationID := getReplyTo(ctx)
+	if replyTo != inProcessReplyTo {
+		return fmt.Errorf("reply address is not found in context")
+	}
+
+	response = stampPayload(ctx, r.option.ApplicationData, response)
+
+	body, contentEncoding, err := encodeMessage(r.option.Codec, r.option.CompressThreshold, response)
+	if err != nil {
+		return err
+	}
+
+	data, err := decodeMessage(body, r.option.Codec.ContentType(), contentEncoding, defaultMaxBodySize)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.RLock()
+	replyChan, exist := r.pending[correlationID]
+	r.mutex.RUnlock()
+
+	// the late reply of the timeout request is dropped
+	if exist {
+		select {
+		case replyChan <- data:
+		default:
+		}
+	}
+
+	return nil
+}
+
+func (r *InProcessBroker) publish(ctx context.Context, topic string, delayInMS int, data payload.Payload, replyTo, correlationID string) error {
+
+	data = stampPayload(ctx, r.option.ApplicationData, data)
+
+	body, contentEncoding, err := encodeMessage(r.option.Codec, r.option.CompressThreshold, data)
+	if err != nil {
+		return err
+	}
+
+	headers := traceHeaders(data)
+
+	deliver := func() {
+
+		r.mutex.RLock()
+		onReceived, exist := r.topicMap[topic]
+		opt := r.optionMap[topic]
+		middlewares := append(append([]Middleware{}, r.middlewares...), opt.Middlewares...)
+		r.mutex.RUnlock()
+
+		if !exist {
+			return
+		}
+
+		received, err := decodeMessage(body, r.option.Codec.ContentType(), contentEncoding, opt.MaxBodySize)
+
+		ctx := setTopic(restoreContext(&received, headers), topic)
+		ctx = setReplyTo(ctx, replyTo, correlationID)
+
+		_ = chainRecovered(onReceived, middlewares...)(ctx, received, err)
+	}
+
+	if delayInMS > 0 {
+		time.AfterFunc(time.Duration(delayInMS)*time.Millisecond, deliver)
+		return nil
+	}
+
+	deliver()
+
+	return nil
+}
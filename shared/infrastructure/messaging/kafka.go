@@ -0,0 +1,463 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"infrastructure/shared/infrastructure/logger"
+	"infrastructure/shared/model/payload"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	headerContentType     = "content-type"
+	headerContentEncoding = "content-encoding"
+
+	// headerDeliverAt is the unix millisecond when the delayed message may be handled
+	headerDeliverAt = "x-deliver-at"
+
+	// headerOriginalTopic and headerError is added to the message sent to the dead letter topic
+	headerOriginalTopic = "x-original-topic"
+	headerError         = "x-error"
+)
+
+// kafkaWriter and kafkaReader is the part of kafka-go used here, so it can be replaced in the test
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+type kafkaReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+type publisherKafkaImpl struct {
+	writer kafkaWriter
+	option PublishOption
+}
+
+// NewPublisherKafka is
+// url is the comma separated broker address "localhost:9092,localhost:9093"
+func NewPublisherKafka(url string, option ...PublishOption) *publisherKafkaImpl {
+	return &publisherKafkaImpl{
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(strings.Split(url, ",")...),
+			Balancer:               &kafka.Hash{},
+			RequiredAcks:           kafka.RequireAll,
+			AllowAutoTopicCreation: true,
+		},
+		option: getPublishOption(option),
+	}
+}
+
+// Publish is
+// Kafka has no delayed message, the delay is kept in the header and the subscriber park the message until the time comes
+func (m *publisherKafkaImpl) Publish(ctx context.Context, topic string, delayInMS int, data payload.Payload) error {
+
+	data = stampPayload(ctx, m.option.ApplicationData, data)
+
+	dataInBytes, contentEncoding, err := encodeMessage(m.option.Codec, m.option.CompressThreshold, data)
+	if err != nil {
+		return err
+	}
+
+	headers := []kafka.Header{
+		{Key: headerContentType, Value: []byte(m.option.Codec.ContentType())},
+		{Key: headerContentEncoding, Value: []byte(contentEncoding)},
+	}
+
+	for k, v := range traceHeaders(data) {
+		headers = append(headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+
+	if delayInMS > 0 {
+		deliverAt := time.Now().Add(time.Duration(delayInMS) * time.Millisecond).UnixMilli()
+		headers = append(headers, kafka.Header{Key: headerDeliverAt, Value: []byte(strconv.FormatInt(deliverAt, 10))})
+	}
+
+	var key []byte
+	if m.option.PartitionKey != nil {
+		key = []byte(m.option.PartitionKey(data))
+	}
+
+	return m.writer.WriteMessages(ctx, kafka.Message{
+		Topic:   topic,
+		Key:     key,
+		Value:   dataInBytes,
+		Headers: headers,
+	})
+}
+
+type subscriberKafkaImpl struct {
+	groupID     string
+	topicMap    map[string]HandleFunc
+	optionMap   map[string]HandleOption
+	middlewares []Middleware
+
+	// MaxDeliveries is how many times the handler is called for one message before it is sent to the dead letter topic
+	MaxDeliveries int
+
+	// RetryInterval is the wait before the failed message is handled again and before the failed fetch is repeated.
+	// It is doubled on every retry of the same message
+	RetryInterval time.Duration
+
+	// DeadLetterSuffix is added to the topic name to get the dead letter topic.
+	// Empty means the message which still fails after MaxDeliveries is dropped
+	DeadLetterSuffix string
+
+	// Log (optional) print the fetch error and the message sent to the dead letter topic
+	Log logger.Logger
+
+	// newReader and newWriter can be replaced in the test
+	newReader func(url, groupID, topic string, option HandleOption) kafkaReader
+	newWriter func(url string) kafkaWriter
+}
+
+// NewSubscriberKafka is
+// groupID is the consumer group, it has the same role as the queueName in RabbitMQ and the channel in NSQ
+func NewSubscriberKafka(groupID string) *subscriberKafkaImpl {
+	return &subscriberKafkaImpl{
+		groupID:          groupID,
+		topicMap:         map[string]HandleFunc{},
+		optionMap:        map[string]HandleOption{},
+		MaxDeliveries:    3,
+		RetryInterval:    time.Second,
+		DeadLetterSuffix: ".dlq",
+		newReader: func(url, groupID, topic string, option HandleOption) kafkaReader {
+			return kafka.NewReader(kafka.ReaderConfig{
+				Brokers:       strings.Split(url, ","),
+				GroupID:       groupID,
+				Topic:         topic,
+				QueueCapacity: option.Prefetch,
+			})
+		},
+		newWriter: func(url string) kafkaWriter {
+			return &kafka.Writer{
+				Addr:                   kafka.TCP(strings.Split(url, ",")...),
+				Balancer:               &kafka.Hash{},
+				RequiredAcks:           kafka.RequireAll,
+				AllowAutoTopicCreation: true,
+			}
+		},
+	}
+}
+
+func (r *subscriberKafkaImpl) Handle(topic string, onReceived HandleFunc, option ...HandleOption) {
+	r.topicMap[topic] = onReceived
+	r.optionMap[topic] = getHandleOption(option)
+}
+
+func (r *subscriberKafkaImpl) Use(middlewares ...Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+// Run is
+// url is the comma separated broker address "localhost:9092,localhost:9093"
+func (r *subscriberKafkaImpl) Run(url string) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var deadLetter kafkaWriter
+	if r.DeadLetterSuffix != "" {
+		deadLetter = r.newWriter(url)
+		defer func() {
+			_ = deadLetter.Close()
+		}()
+	}
+
+	var wg sync.WaitGroup
+
+	for topic, onReceived := range r.topicMap {
+
+		opt := r.optionMap[topic]
+		handler := chainRecovered(onReceived, append(r.middlewares, opt.Middlewares...)...)
+		reader := r.newReader(url, r.groupID, topic, opt)
+
+		wg.Add(1)
+		go func(topic string) {
+			defer wg.Done()
+			r.consume(ctx, reader, deadLetter, topic, opt, handler)
+		}(topic)
+	}
+
+	termChan := make(chan os.Signal, 1)
+	signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM)
+	<-termChan
+
+	cancel()
+	wg.Wait()
+}
+
+// consume fetch the message until the ctx is done or the reader is closed.
+// Every message is committed once it is handled, either successfully or by sending it to the dead letter topic,
+// so the failed message never block the commit of the later message in the same partition.
+// The delayed message is parked outside the worker until its time so it does not block the later message of the partition,
+// the offset after it is committed only when it is handled
+func (r *subscriberKafkaImpl) consume(ctx context.Context, reader kafkaReader, deadLetter kafkaWriter, topic string, opt HandleOption, handler HandleFunc) {
+
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	// without the partition key the message of the same kafka partition still go to the same worker to keep the order
+	pool := newWorkerPool(opt.Concurrency, opt.Prefetch, true)
+	defer pool.Stop()
+
+	// the parked message is dropped without commit when the consume stop, it is fetched again after the restart
+	parkCtx, cancelPark := context.WithCancel(ctx)
+	var parked sync.WaitGroup
+	defer func() {
+		cancelPark()
+		parked.Wait()
+	}()
+
+	tracker := newOffsetTracker()
+
+	fetchBackoff := r.RetryInterval
+	if fetchBackoff <= 0 {
+		fetchBackoff = time.Second
+	}
+
+	for {
+		m, err := reader.FetchMessage(ctx)
+		if ctx.Err() != nil || errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) {
+			return
+		}
+
+		if err != nil {
+			r.logError(ctx, "fetch kafka topic %s: %v", topic, err)
+			if !sleepContext(ctx, fetchBackoff) {
+				return
+			}
+			continue
+		}
+
+		headers := map[string]string{}
+		for _, h := range m.Headers {
+			headers[h.Key] = string(h.Value)
+		}
+
+		data, err := decodeMessage(m.Value, headers[headerContentType], headers[headerContentEncoding], opt.MaxBodySize)
+
+		handlerCtx := setTopic(restoreContext(&data, headers), topic)
+
+		key := strconv.Itoa(m.Partition)
+		if opt.PartitionKey != nil {
+			key = partitionKey(opt, data, err)
+		}
+
+		tracked := tracker.Add(m)
+
+		job := func() {
+
+			if !r.handle(ctx, handlerCtx, deadLetter, topic, m, data, err, handler) {
+				return
+			}
+
+			if commit, ok := tracker.Done(tracked); ok {
+				_ = reader.CommitMessages(context.Background(), commit)
+			}
+		}
+
+		wait := untilDeliverAt(headers[headerDeliverAt])
+		if wait <= 0 {
+			pool.Submit(key, job)
+			continue
+		}
+
+		parked.Add(1)
+		go func() {
+			defer parked.Done()
+			if sleepContext(parkCtx, wait) {
+				pool.Submit(key, job)
+			}
+		}()
+	}
+}
+
+// handle call the handler until it succeed or MaxDeliveries is reached then send the message to the dead letter topic.
+// It returns false only if the ctx is done before the message is resolved, then the message is not committed
+func (r *subscriberKafkaImpl) handle(ctx, handlerCtx context.Context, deadLetter kafkaWriter, topic string, m kafka.Message, data payload.Payload, errDecode error, handler HandleFunc) bool {
+
+	wait := r.RetryInterval
+
+	var errHandle error
+	for delivery := 1; ; delivery++ {
+
+		errHandle = handler(handlerCtx, data, errDecode)
+		if errHandle == nil {
+			return true
+		}
+
+		// the message which can not be decoded will fail again
+		if errDecode != nil || delivery >= r.MaxDeliveries {
+			break
+		}
+
+		if !sleepContext(ctx, wait) {
+			return false
+		}
+		wait *= 2
+	}
+
+	if deadLetter == nil || r.DeadLetterSuffix == "" {
+		r.logError(ctx, "drop kafka message %s/%d/%d: %v", topic, m.Partition, m.Offset, errHandle)
+		return true
+	}
+
+	headers := append([]kafka.Header{}, m.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: headerOriginalTopic, Value: []byte(topic)},
+		kafka.Header{Key: headerError, Value: []byte(errHandle.Error())},
+	)
+
+	message := kafka.Message{
+		Topic:   topic + r.DeadLetterSuffix,
+		Key:     m.Key,
+		Value:   m.Value,
+		Headers: headers,
+	}
+
+	wait = r.RetryInterval
+
+	// the message is only committed after it is kept in the dead letter topic
+	for {
+		err := deadLetter.WriteMessages(ctx, message)
+		if err == nil {
+			r.logError(ctx, "kafka message %s/%d/%d is sent to %s: %v", topic, m.Partition, m.Offset, message.Topic, errHandle)
+			return true
+		}
+
+		r.logError(ctx, "write kafka dead letter %s: %v", message.Topic, err)
+
+		if !sleepContext(ctx, wait) {
+			return false
+		}
+		if wait < time.Minute {
+			wait *= 2
+		}
+	}
+}
+
+func (r *subscriberKafkaImpl) logError(ctx context.Context, message string, args ...any) {
+	if r.Log != nil {
+		r.Log.Error(ctx, message, args...)
+	}
+}
+
+// sleepContext return false if the ctx is done before the duration
+func sleepContext(ctx context.Context, duration time.Duration) bool {
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// untilDeliverAt return how long the delayed message must wait, 0 for the message which can be handled now
+func untilDeliverAt(deliverAt string) time.Duration {
+
+	if deliverAt == "" {
+		return 0
+	}
+
+	unixMilli, err := strconv.ParseInt(deliverAt, 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return time.Until(time.UnixMilli(unixMilli))
+}
+
+// offsetTracker decide which offset is safe to commit when the messages of one partition are handled by many workers.
+// The offset is only committed after all the message before it are done.
+// The message which is not done when the subscriber stop is redelivered after the restart or rebalance.
+//
+// The kafka-go Reader does not report the rebalance, it is detected when the offset of the partition go back
+// because the partition is assigned again from the committed offset. Then the message fetched before is never committed
+// since the new delivery of the same offset will be
+type offsetTracker struct {
+	mutex      sync.Mutex
+	pending    map[int][]*trackedMessage
+	lastOffset map[int]int64
+}
+
+type trackedMessage struct {
+	message kafka.Message
+	done    bool
+	revoked bool
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		pending:    map[int][]*trackedMessage{},
+		lastOffset: map[int]int64{},
+	}
+}
+
+// Add must be called in the fetch order
+func (t *offsetTracker) Add(m kafka.Message) *trackedMessage {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if last, exist := t.lastOffset[m.Partition]; exist && m.Offset <= last {
+		t.reset(m.Partition)
+	}
+	t.lastOffset[m.Partition] = m.Offset
+
+	tracked := &trackedMessage{message: m}
+	t.pending[m.Partition] = append(t.pending[m.Partition], tracked)
+
+	return tracked
+}
+
+// reset forget the pending message of the partition after the rebalance
+func (t *offsetTracker) reset(partition int) {
+	for _, tracked := range t.pending[partition] {
+		tracked.revoked = true
+	}
+	delete(t.pending, partition)
+}
+
+// Done mark the message and return the latest message that can be committed
+func (t *offsetTracker) Done(tracked *trackedMessage) (kafka.Message, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	tracked.done = true
+
+	if tracked.revoked {
+		return kafka.Message{}, false
+	}
+
+	queue := t.pending[tracked.message.Partition]
+
+	var commit *trackedMessage
+	for len(queue) > 0 && queue[0].done {
+		commit = queue[0]
+		queue = queue[1:]
+	}
+
+	t.pending[tracked.message.Partition] = queue
+
+	if commit == nil {
+		return kafka.Message{}, false
+	}
+
+	return commit.message, true
+}
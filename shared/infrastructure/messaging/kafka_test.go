@@ -0,0 +1,405 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"infrastructure/shared/model/payload"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeKafka is the in-process stand-in of one kafka topic. It is the writer used by the publisher and the dead letter,
+// and the reader used by the subscriber. The partition is chosen by the key length so the test can control it
+type fakeKafka struct {
+	mutex      sync.Mutex
+	partitions int
+	offsets    map[int]int64
+	messages   chan kafka.Message
+	closed     bool
+
+	// fetchErrors is returned by FetchMessage before any message
+	fetchErrors []error
+
+	// writeErrors is returned by WriteMessages before the message is accepted
+	writeErrors []error
+
+	written   []kafka.Message
+	committed []kafka.Message
+	onCommit  func(m kafka.Message)
+}
+
+func newFakeKafka(partitions int) *fakeKafka {
+	return &fakeKafka{
+		partitions: partitions,
+		offsets:    map[int]int64{},
+		messages:   make(chan kafka.Message, 100),
+	}
+}
+
+func (f *fakeKafka) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if len(f.writeErrors) > 0 {
+		err := f.writeErrors[0]
+		f.writeErrors = f.writeErrors[1:]
+		return err
+	}
+
+	for _, m := range msgs {
+		m.Partition = len(m.Key) % f.partitions
+		m.Offset = f.offsets[m.Partition]
+		f.offsets[m.Partition]++
+		f.written = append(f.written, m)
+		f.messages <- m
+	}
+
+	return nil
+}
+
+func (f *fakeKafka) FetchMessage(ctx context.Context) (kafka.Message, error) {
+
+	f.mutex.Lock()
+	if len(f.fetchErrors) > 0 {
+		err := f.fetchErrors[0]
+		f.fetchErrors = f.fetchErrors[1:]
+		f.mutex.Unlock()
+		return kafka.Message{}, err
+	}
+	f.mutex.Unlock()
+
+	select {
+	case m, ok := <-f.messages:
+		if !ok {
+			return kafka.Message{}, io.EOF
+		}
+		return m, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (f *fakeKafka) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, m := range msgs {
+		if f.onCommit != nil {
+			f.onCommit(m)
+		}
+		f.committed = append(f.committed, m)
+	}
+
+	return nil
+}
+
+func (f *fakeKafka) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if !f.closed {
+		f.closed = true
+		close(f.messages)
+	}
+
+	return nil
+}
+
+// lastCommitted return the committed offset of the partition, -1 if nothing is committed
+func (f *fakeKafka) lastCommitted(partition int) int64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	offset := int64(-1)
+	for _, m := range f.committed {
+		if m.Partition == partition {
+			offset = m.Offset
+		}
+	}
+	return offset
+}
+
+func (f *fakeKafka) deadLetters() []kafka.Message {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]kafka.Message{}, f.written...)
+}
+
+func newTestSubscriberKafka() *subscriberKafkaImpl {
+	sub := NewSubscriberKafka("group")
+	sub.RetryInterval = time.Millisecond
+	return sub
+}
+
+// runConsume start consume and return the function that stop it and wait until it return
+func runConsume(t *testing.T, sub *subscriberKafkaImpl, reader kafkaReader, deadLetter kafkaWriter, opt HandleOption, handler HandleFunc) func() {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		sub.consume(ctx, reader, deadLetter, "orders", getHandleOption([]HandleOption{opt}), handler)
+	}()
+
+	return func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("consume does not return after the ctx is cancelled")
+		}
+	}
+}
+
+func waitUntil(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition is not reached in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func publishOrders(t *testing.T, publisher *publisherKafkaImpl, delayInMS int, ids ...string) {
+	for _, id := range ids {
+		err := publisher.Publish(context.Background(), "orders", delayInMS, payload.Payload{Data: map[string]any{"id": id}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func orderID(data payload.Payload) string {
+	obj, _ := data.Data.(map[string]any)
+	id, _ := obj["id"].(string)
+	return id
+}
+
+func TestKafkaCommitInOrder(t *testing.T) {
+
+	broker := newFakeKafka(1)
+	publisher := &publisherKafkaImpl{writer: broker, option: NewDefaultPublishOption()}
+
+	var mutex sync.Mutex
+	handled := map[string]bool{}
+
+	// the commit of the offset must wait all the message before it even when they are handled by the other worker
+	broker.onCommit = func(m kafka.Message) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for i := int64(0); i <= m.Offset; i++ {
+			if !handled[fmt.Sprintf("O%d", i)] {
+				t.Errorf("offset %d is committed before O%d is handled", m.Offset, i)
+			}
+		}
+	}
+
+	// the partition key spread the message of the same kafka partition to the different workers
+	opt := NewDefaultHandleOption().SetConcurrency(4).SetPartitionKey(orderID)
+
+	stop := runConsume(t, newTestSubscriberKafka(), broker, nil, opt, func(ctx context.Context, data payload.Payload, err error) error {
+		if err != nil {
+			return err
+		}
+		if orderID(data) == "O0" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		mutex.Lock()
+		handled[orderID(data)] = true
+		mutex.Unlock()
+		return nil
+	})
+	defer stop()
+
+	publishOrders(t, publisher, 0, "O0", "O1", "O2", "O3", "O4")
+
+	waitUntil(t, func() bool { return broker.lastCommitted(0) == 4 })
+}
+
+func TestKafkaDelayedMessage(t *testing.T) {
+
+	broker := newFakeKafka(1)
+	publisher := &publisherKafkaImpl{writer: broker, option: NewDefaultPublishOption()}
+
+	handledAt := make(chan time.Time, 1)
+
+	stop := runConsume(t, newTestSubscriberKafka(), broker, nil, NewDefaultHandleOption(), func(ctx context.Context, data payload.Payload, err error) error {
+		handledAt <- time.Now()
+		return err
+	})
+	defer stop()
+
+	start := time.Now()
+	publishOrders(t, publisher, 100, "O0")
+
+	select {
+	case at := <-handledAt:
+		// the deliver time is kept in millisecond
+		if at.Sub(start) < 99*time.Millisecond {
+			t.Fatalf("delayed message is handled after %s", at.Sub(start))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("delayed message is not handled")
+	}
+
+	waitUntil(t, func() bool { return broker.lastCommitted(0) == 0 })
+}
+
+func TestKafkaDelayedMessageDoesNotBlockThePartition(t *testing.T) {
+
+	broker := newFakeKafka(1)
+	publisher := &publisherKafkaImpl{writer: broker, option: NewDefaultPublishOption()}
+
+	handled := make(chan string, 2)
+
+	stop := runConsume(t, newTestSubscriberKafka(), broker, nil, NewDefaultHandleOption(), func(ctx context.Context, data payload.Payload, err error) error {
+		handled <- orderID(data)
+		return err
+	})
+	defer stop()
+
+	publishOrders(t, publisher, 200, "O0")
+	publishOrders(t, publisher, 0, "O1")
+
+	for _, want := range []string{"O1", "O0"} {
+		select {
+		case id := <-handled:
+			if id != want {
+				t.Fatalf("handled %s, want %s", id, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s is not handled", want)
+		}
+
+		// the offset of O1 is only committed after the delayed O0 before it is handled
+		if want == "O1" && broker.lastCommitted(0) != -1 {
+			t.Fatalf("offset %d is committed before the delayed message", broker.lastCommitted(0))
+		}
+	}
+
+	waitUntil(t, func() bool { return broker.lastCommitted(0) == 1 })
+}
+
+func TestOffsetTrackerResetOnRebalance(t *testing.T) {
+
+	tracker := newOffsetTracker()
+
+	before := tracker.Add(kafka.Message{Partition: 0, Offset: 5})
+	tracker.Add(kafka.Message{Partition: 0, Offset: 6})
+
+	// the partition is assigned again from the committed offset
+	after := tracker.Add(kafka.Message{Partition: 0, Offset: 5})
+
+	if _, ok := tracker.Done(before); ok {
+		t.Fatal("message fetched before the rebalance is committed")
+	}
+
+	commit, ok := tracker.Done(after)
+	if !ok || commit.Offset != 5 {
+		t.Fatalf("commit after the rebalance is %d %v", commit.Offset, ok)
+	}
+}
+
+func TestKafkaFailedMessageGoesToDeadLetter(t *testing.T) {
+
+	broker := newFakeKafka(1)
+	deadLetter := newFakeKafka(1)
+	publisher := &publisherKafkaImpl{writer: broker, option: NewDefaultPublishOption()}
+
+	// the first write to the dead letter topic fail, the message is committed only after it is written
+	deadLetter.writeErrors = []error{errors.New("broker is not available")}
+
+	var mutex sync.Mutex
+	deliveries := map[string]int{}
+
+	stop := runConsume(t, newTestSubscriberKafka(), broker, deadLetter, NewDefaultHandleOption(), func(ctx context.Context, data payload.Payload, err error) error {
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		deliveries[orderID(data)]++
+		mutex.Unlock()
+		if orderID(data) == "O1" {
+			return errors.New("payment is rejected")
+		}
+		return nil
+	})
+	defer stop()
+
+	publishOrders(t, publisher, 0, "O0", "O1", "O2")
+
+	// the failed message does not block the commit of the later message
+	waitUntil(t, func() bool { return broker.lastCommitted(0) == 2 })
+
+	mutex.Lock()
+	if deliveries["O1"] != 3 {
+		t.Fatalf("failed message is delivered %d times, want 3", deliveries["O1"])
+	}
+	mutex.Unlock()
+
+	letters := deadLetter.deadLetters()
+	if len(letters) != 1 {
+		t.Fatalf("dead letter has %d messages, want 1", len(letters))
+	}
+
+	headers := map[string]string{}
+	for _, h := range letters[0].Headers {
+		headers[h.Key] = string(h.Value)
+	}
+
+	if letters[0].Topic != "orders.dlq" || headers[headerOriginalTopic] != "orders" || headers[headerError] != "payment is rejected" {
+		t.Fatalf("unexpected dead letter %s %v", letters[0].Topic, headers)
+	}
+
+	data, err := decodeMessage(letters[0].Value, headers[headerContentType], headers[headerContentEncoding], 0)
+	if err != nil || orderID(data) != "O1" {
+		t.Fatalf("dead letter body is %v %v", data, err)
+	}
+}
+
+func TestKafkaFetchErrorBackoff(t *testing.T) {
+
+	broker := newFakeKafka(1)
+	broker.fetchErrors = []error{errors.New("leader not available"), errors.New("leader not available")}
+	publisher := &publisherKafkaImpl{writer: broker, option: NewDefaultPublishOption()}
+
+	sub := newTestSubscriberKafka()
+	sub.RetryInterval = 20 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	start := time.Now()
+
+	go func() {
+		defer close(done)
+		sub.consume(ctx, broker, nil, "orders", NewDefaultHandleOption(), func(ctx context.Context, data payload.Payload, err error) error {
+			return err
+		})
+	}()
+
+	publishOrders(t, publisher, 0, "O0")
+
+	waitUntil(t, func() bool { return broker.lastCommitted(0) == 0 })
+
+	if time.Since(start) < 40*time.Millisecond {
+		t.Fatalf("fetch is retried without waiting")
+	}
+
+	// the closed reader stop the consume without the ctx
+	_ = broker.Close()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("consume does not return after the reader is closed")
+	}
+}
@@ -0,0 +1,217 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"infrastructure/shared/infrastructure/cache"
+	"infrastructure/shared/infrastructure/logger"
+	"infrastructure/shared/model/payload"
+	"runtime/debug"
+	"time"
+)
+
+// Middleware wrap the HandleFunc to add the cross-cutting concern like logging or panic recovery.
+// Register it for all topics with Subscriber.Use or for one topic with HandleOption.SetMiddlewares
+type Middleware func(next HandleFunc) HandleFunc
+
+// Chain wrap the handler with the middlewares. The first middleware is the outermost one
+func Chain(handler HandleFunc, middlewares ...Middleware) HandleFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// chainRecovered is Chain with the panic turned into the error, so the panic of any handler does not stop the consumer
+// and the message is retried like the failed one. Add Recover to also log the stack
+func chainRecovered(handler HandleFunc, middlewares ...Middleware) HandleFunc {
+	return recoverPanic(Chain(handler, middlewares...))
+}
+
+func recoverPanic(next HandleFunc) HandleFunc {
+	return func(ctx context.Context, data payload.Payload, err error) (errResult error) {
+
+		defer func() {
+			if p := recover(); p != nil {
+				errResult = fmt.Errorf("panic on topic %s: %v", GetTopic(ctx), p)
+			}
+		}()
+
+		return next(ctx, data, err)
+	}
+}
+
+type topicDataType int
+
+const topicDataKey topicDataType = 1
+
+func setTopic(ctx context.Context, topic string) context.Context {
+	return context.WithValue(ctx, topicDataKey, topic)
+}
+
+// GetTopic return the topic of the message that is being handled
+func GetTopic(ctx context.Context) string {
+	if v := ctx.Value(topicDataKey); v != nil {
+		return v.(string)
+	}
+	return ""
+}
+
+// Recover log the panic in the handler with the stack and turn it into an error.
+// The panic is already recovered by every subscriber, this middleware only add the log
+func Recover(log logger.Logger) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, data payload.Payload, err error) (errResult error) {
+
+			defer func() {
+				if p := recover(); p != nil {
+					log.With("topic", GetTopic(ctx), "stack", string(debug.Stack())).Error(ctx, "panic: %v", p)
+					errResult = fmt.Errorf("panic on topic %s: %v", GetTopic(ctx), p)
+				}
+			}()
+
+			return next(ctx, data, err)
+		}
+	}
+}
+
+// Logging print the received message and the handling result
+func Logging(log logger.Logger) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, data payload.Payload, err error) error {
+
+			log := log.With("topic", GetTopic(ctx), "eventType", data.EventType)
+
+			log.Info(ctx, "recv")
+
+			start := time.Now()
+
+			errResult := next(ctx, data, err)
+			if errResult != nil {
+				log.With("duration", time.Since(start).String(), "error", errResult.Error()).Error(ctx, "fail")
+				return errResult
+			}
+
+			log.With("duration", time.Since(start).String()).Info(ctx, "done")
+
+			return nil
+		}
+	}
+}
+
+// Timeout give the handler a context with deadline.
+// The handler must respect the context cancellation and return the context error when it gives up.
+// The handler which finish successfully after the deadline is still success since its side effect is already applied
+func Timeout(duration time.Duration) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, data payload.Payload, err error) error {
+
+			ctx, cancel := context.WithTimeout(ctx, duration)
+			defer cancel()
+
+			return next(ctx, data, err)
+		}
+	}
+}
+
+// MetricsRecorder receive the result of every handled message
+type MetricsRecorder interface {
+	ObserveMessage(topic string, duration time.Duration, err error)
+}
+
+// Metrics measure the handling duration and the result
+func Metrics(recorder MetricsRecorder) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, data payload.Payload, err error) error {
+			start := time.Now()
+			errResult := next(ctx, data, err)
+			recorder.ObserveMessage(GetTopic(ctx), time.Since(start), errResult)
+			return errResult
+		}
+	}
+}
+
+// dedupClaimTTL limit how long the key of the message being handled block the other delivery when the handler crash
+const dedupClaimTTL = time.Minute
+
+const (
+	dedupHandling = "handling"
+	dedupDone     = "done"
+)
+
+// Dedup skip the message that is already handled within the expiration time.
+// The key is claimed for dedupClaimTTL before the handler run, the concurrent delivery of the same message fail with ErrRequeue
+// so it is delivered again later. The key is marked done only after the handler succeed, and released when the handler fail.
+// keyFunc is optional, by default the message is identified by the payload ID.
+// The message with the empty key, like the one from the publisher without the ID, is always handled
+func Dedup(c cache.Cache, exp time.Duration, keyFunc func(payload payload.Payload) string) Middleware {
+
+	if keyFunc == nil {
+		keyFunc = func(data payload.Payload) string {
+			return data.ID
+		}
+	}
+
+	claimTTL := dedupClaimTTL
+	if exp > 0 && exp < claimTTL {
+		claimTTL = exp
+	}
+
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, data payload.Payload, err error) error {
+
+			if err != nil {
+				return next(ctx, data, err)
+			}
+
+			id := keyFunc(data)
+			if id == "" {
+				return next(ctx, data, err)
+			}
+
+			key := fmt.Sprintf("dedup:%s:%s", GetTopic(ctx), id)
+
+			claimed, errCache := c.SetNX(ctx, key, []byte(dedupHandling), claimTTL)
+			if errCache != nil {
+				return errCache
+			}
+
+			if !claimed {
+				value, errCache := c.Get(ctx, key)
+				if errCache == nil && string(value) == dedupDone {
+					return nil
+				}
+				return fmt.Errorf("message %s is being handled: %w", id, ErrRequeue)
+			}
+
+			// the handler ctx may be already cancelled
+			errResult := next(ctx, data, err)
+			if errResult != nil {
+				_ = c.Del(context.Background(), key)
+				return errResult
+			}
+
+			return c.Set(context.Background(), key, []byte(dedupDone), exp)
+		}
+	}
+}
+
+// Validate reject the message before it reach the handler.
+// The decoding error is also returned without calling the handler
+func Validate(validator func(payload payload.Payload) error) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, data payload.Payload, err error) error {
+
+			if err != nil {
+				return err
+			}
+
+			errValidate := validator(data)
+			if errValidate != nil {
+				return errValidate
+			}
+
+			return next(ctx, data, err)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"infrastructure/shared/infrastructure/cache"
+	"infrastructure/shared/model/payload"
+	"testing"
+	"time"
+)
+
+func TestDedupSkipHandledMessage(t *testing.T) {
+
+	memory := cache.NewMemoryCache()
+	defer memory.Close()
+
+	calls := 0
+	handler := Dedup(memory, time.Minute, nil)(func(ctx context.Context, data payload.Payload, err error) error {
+		calls++
+		return nil
+	})
+
+	ctx := setTopic(context.Background(), "order.created")
+
+	for i := 0; i < 2; i++ {
+		if err := handler(ctx, payload.Payload{ID: "M1"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the message without the id from the old publisher is never deduped
+	for i := 0; i < 2; i++ {
+		if err := handler(ctx, payload.Payload{TraceID: "T1"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if calls != 3 {
+		t.Fatalf("handler is called %d times, want 3", calls)
+	}
+}
+
+func TestDedupRetryFailedMessage(t *testing.T) {
+
+	memory := cache.NewMemoryCache()
+	defer memory.Close()
+
+	calls := 0
+	handler := Dedup(memory, time.Minute, nil)(func(ctx context.Context, data payload.Payload, err error) error {
+		calls++
+		if calls == 1 {
+			return errors.New("database is down")
+		}
+		return nil
+	})
+
+	ctx := setTopic(context.Background(), "order.created")
+
+	if err := handler(ctx, payload.Payload{ID: "M1"}, nil); err == nil {
+		t.Fatal("first attempt must fail")
+	}
+
+	if err := handler(ctx, payload.Payload{ID: "M1"}, nil); err != nil || calls != 2 {
+		t.Fatalf("retry return %v after %d calls", err, calls)
+	}
+}
+
+func TestDedupRequeueConcurrentDelivery(t *testing.T) {
+
+	memory := cache.NewMemoryCache()
+	defer memory.Close()
+
+	ctx := setTopic(context.Background(), "order.created")
+
+	var errConcurrent error
+	calls := 0
+
+	var handler HandleFunc
+	handler = Dedup(memory, time.Minute, nil)(func(ctx context.Context, data payload.Payload, err error) error {
+		calls++
+		// the redelivery arrive while the first delivery is still running
+		if calls == 1 {
+			errConcurrent = handler(ctx, data, nil)
+		}
+		return nil
+	})
+
+	if err := handler(ctx, payload.Payload{ID: "M1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if !errors.Is(errConcurrent, ErrRequeue) {
+		t.Fatalf("concurrent delivery return %v", errConcurrent)
+	}
+
+	// after the first delivery is done the redelivery is acknowledged without the handler
+	if err := handler(ctx, payload.Payload{ID: "M1"}, nil); err != nil || calls != 1 {
+		t.Fatalf("redelivery return %v after %d calls", err, calls)
+	}
+}
+
+func TestHandlerPanicIsRecoveredByDefault(t *testing.T) {
+
+	handler := chainRecovered(func(ctx context.Context, data payload.Payload, err error) error {
+		panic("nil map")
+	})
+
+	if err := handler(setTopic(context.Background(), "orders"), payload.Payload{}, nil); err == nil || err.Error() != "panic on topic orders: nil map" {
+		t.Fatalf("panic is returned as %v", err)
+	}
+
+	// the broker keep delivering after the panic
+	broker := NewInProcessBroker()
+
+	handled := make(chan string, 2)
+	broker.Handle("orders", func(ctx context.Context, data payload.Payload, err error) error {
+		handled <- orderID(data)
+		if orderID(data) == "O1" {
+			panic("nil map")
+		}
+		return nil
+	})
+
+	for _, id := range []string{"O1", "O2"} {
+		if err := broker.Publish(context.Background(), "orders", 0, payload.Payload{Data: map[string]any{"id": id}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if first, second := <-handled, <-handled; first != "O1" || second != "O2" {
+		t.Fatalf("handled %s and %s", first, second)
+	}
+}
+
+func TestRedisStreamRetryThePanicMessage(t *testing.T) {
+
+	client, sub := newTestRedisStream(t)
+	publisher := NewPublisherRedisStream(client, 0)
+
+	handled := &handledOrders{}
+	// the handler is wrapped like Run does
+	stop := runRedisConsume(t, sub, chainRecovered(func(ctx context.Context, data payload.Payload, err error) error {
+		if errHandle := handled.handle(ctx, data, err); errHandle != nil || handled.count() == 1 {
+			panic("handler bug")
+		}
+		return nil
+	}))
+	defer stop()
+
+	publishRedisOrders(t, publisher, 0, "O1")
+
+	// the consumer survive the panic and the message is delivered again
+	waitUntil(t, func() bool { return handled.count() == 2 && pendingCount(t, client) == 0 })
+}
+
+func TestPartitionKeyPanicGiveTheEmptyKey(t *testing.T) {
+
+	opt := NewDefaultHandleOption().SetPartitionKey(func(p payload.Payload) string {
+		return p.Data.(map[string]any)["orderID"].(string)
+	})
+
+	if key := partitionKey(opt, payload.Payload{Data: map[string]any{"orderID": "O1"}}, nil); key != "O1" {
+		t.Fatalf("key is %q", key)
+	}
+
+	if key := partitionKey(opt, payload.Payload{Data: "unexpected"}, nil); key != "" {
+		t.Fatalf("key of the unexpected payload is %q", key)
+	}
+}
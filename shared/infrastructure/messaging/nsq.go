@@ -1,20 +1,23 @@
 package messaging
 
 import (
-	"encoding/json"
+	"context"
+	"fmt"
 	"github.com/nsqio/go-nsq"
 	"infrastructure/shared/model/payload"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 type publisherNSQImpl struct {
 	producer *nsq.Producer
+	option   PublishOption
 }
 
 // NewPublisherNSQ is
-func NewPublisherNSQ(url string) *publisherNSQImpl {
+func NewPublisherNSQ(url string, option ...PublishOption) *publisherNSQImpl {
 
 	nsqConfig := nsq.NewConfig()
 	producer, err := nsq.NewProducer(url, nsqConfig)
@@ -22,47 +25,98 @@ func NewPublisherNSQ(url string) *publisherNSQImpl {
 		panic(err.Error())
 	}
 
-	return &publisherNSQImpl{producer: producer}
+	return &publisherNSQImpl{
+		producer: producer,
+		option:   getPublishOption(option),
+	}
 }
 
 // Publish is
-func (m *publisherNSQImpl) Publish(topic string, data payload.Payload) error {
-	dataInBytes, err := json.Marshal(data)
+func (m *publisherNSQImpl) Publish(ctx context.Context, topic string, delayInMS int, data payload.Payload) error {
+
+	data = stampPayload(ctx, m.option.ApplicationData, data)
+
+	dataInBytes, contentEncoding, err := encodeMessage(m.option.Codec, m.option.CompressThreshold, data)
+	if err != nil {
+		return err
+	}
+
+	body, err := writeNSQFrame(m.option.Codec.ContentType(), contentEncoding, dataInBytes)
 	if err != nil {
 		return err
 	}
-	return m.producer.Publish(topic, dataInBytes)
+
+	if delayInMS > 0 {
+		return m.producer.DeferredPublish(topic, time.Duration(delayInMS)*time.Millisecond, body)
+	}
+
+	return m.producer.Publish(topic, body)
 }
 
 type subscriberNSQImpl struct {
 	channel     string
 	subscribers map[string]*nsq.Consumer
+	workerPools map[string]*workerPool
+	topicMap    map[string]HandleFunc
+	optionMap   map[string]HandleOption
+	handlerMap  map[string]HandleFunc
+	middlewares []Middleware
 }
 
 func NewSubscriberNSQ(channel string) Subscriber {
 	return &subscriberNSQImpl{
 		channel:     channel,
 		subscribers: map[string]*nsq.Consumer{},
+		workerPools: map[string]*workerPool{},
+		topicMap:    map[string]HandleFunc{},
+		optionMap:   map[string]HandleOption{},
+		handlerMap:  map[string]HandleFunc{},
 	}
 }
 
-func (r *subscriberNSQImpl) Handle(topic string, onReceived HandleFunc) {
+func (r *subscriberNSQImpl) Use(middlewares ...Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+func (r *subscriberNSQImpl) Handle(topic string, onReceived HandleFunc, option ...HandleOption) {
+
+	opt := getHandleOption(option)
 
 	nsqConfig := nsq.NewConfig()
+	nsqConfig.MaxInFlight = opt.Prefetch
 
 	con, err := nsq.NewConsumer(topic, r.channel, nsqConfig)
 	if err != nil {
 		panic(err.Error())
 	}
 
+	pool := newWorkerPoolFromOption(opt)
+
 	con.AddHandler(nsq.HandlerFunc(func(m *nsq.Message) error {
-		var data payload.Payload
-		err := json.Unmarshal(m.Body, &data)
-		onReceived(data, err)
+
+		// the message is finished by the worker after the handler is done,
+		// so MaxInFlight limit the number of message waiting for the worker
+		m.DisableAutoResponse()
+
+		data, err := readNSQFrame(m.Body, opt.MaxBodySize)
+		ctx := setTopic(restoreContext(&data, nil), topic)
+
+		pool.Submit(partitionKey(opt, data, err), func() {
+			if errHandle := r.handlerMap[topic](ctx, data, err); errHandle != nil {
+				// nsqd will give up after the consumer MaxAttempts
+				m.Requeue(-1)
+				return
+			}
+			m.Finish()
+		})
+
 		return nil
 	}))
 
 	r.subscribers[topic] = con
+	r.workerPools[topic] = pool
+	r.topicMap[topic] = onReceived
+	r.optionMap[topic] = opt
 }
 
 func (r *subscriberNSQImpl) Run(url string) {
@@ -70,6 +124,11 @@ func (r *subscriberNSQImpl) Run(url string) {
 	termChan := make(chan os.Signal, 1)
 	signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// the middlewares is applied here since Use may be called after Handle
+	for topic, onReceived := range r.topicMap {
+		r.handlerMap[topic] = chainRecovered(onReceived, append(r.middlewares, r.optionMap[topic].Middlewares...)...)
+	}
+
 	for _, con := range r.subscribers {
 		if err := con.ConnectToNSQD(url); err != nil {
 			panic(err.Error())
@@ -84,4 +143,54 @@ func (r *subscriberNSQImpl) Run(url string) {
 	for _, con := range r.subscribers {
 		<-con.StopChan
 	}
+	for _, pool := range r.workerPools {
+		pool.Stop()
+	}
+}
+
+// nsqFrameMarker is the first byte of the framed body. The old publisher send a plain json which never start with this byte
+const nsqFrameMarker = 0x00
+
+// writeNSQFrame put the content type and content encoding in front of the body since NSQ message has no header
+//
+//	| marker | len | content type | len | content encoding | body |
+//
+// The uncompressed json is sent without the frame so the old subscriber still can read it
+func writeNSQFrame(contentType, contentEncoding string, body []byte) ([]byte, error) {
+
+	if contentType == ContentTypeJSON && contentEncoding == "" {
+		return body, nil
+	}
+
+	if len(contentType) > 255 || len(contentEncoding) > 255 {
+		return nil, fmt.Errorf("content type and content encoding must not longer than 255 bytes")
+	}
+
+	frame := make([]byte, 0, 3+len(contentType)+len(contentEncoding)+len(body))
+	frame = append(frame, nsqFrameMarker, byte(len(contentType)))
+	frame = append(frame, contentType...)
+	frame = append(frame, byte(len(contentEncoding)))
+	frame = append(frame, contentEncoding...)
+	return append(frame, body...), nil
+}
+
+// readNSQFrame decode the framed body or the plain json body from the old publisher
+func readNSQFrame(frame []byte, maxBodySize int64) (payload.Payload, error) {
+
+	if len(frame) == 0 || frame[0] != nsqFrameMarker {
+		return decodeMessage(frame, ContentTypeJSON, "", maxBodySize)
+	}
+
+	headers := make([]string, 0, 2)
+
+	pos := 1
+	for i := 0; i < 2; i++ {
+		if pos >= len(frame) || pos+1+int(frame[pos]) > len(frame) {
+			return payload.Payload{}, fmt.Errorf("invalid nsq message frame")
+		}
+		headers = append(headers, string(frame[pos+1:pos+1+int(frame[pos])]))
+		pos += 1 + int(frame[pos])
+	}
+
+	return decodeMessage(frame[pos:], headers[0], headers[1], maxBodySize)
 }
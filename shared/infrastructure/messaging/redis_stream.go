@@ -0,0 +1,520 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"infrastructure/shared/model/payload"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	fieldBody            = "body"
+	fieldContentType     = "content-type"
+	fieldContentEncoding = "content-encoding"
+	fieldDelayID         = "delay-id"
+
+	// fieldOriginalTopic and fieldDeliveryCount is added to the message moved to the dead letter stream
+	fieldOriginalTopic = "x-original-topic"
+	fieldDeliveryCount = "x-delivery-count"
+)
+
+// delayedKey is the sorted set which keep the delayed message of the topic, the score is the unix millisecond to deliver.
+// The topic is the hash tag so the sorted set and the stream are in the same cluster slot for moveDelayedScript
+func delayedKey(topic string) string {
+	return "delayed:{" + topic + "}"
+}
+
+// moveDelayedScript move the due message from the sorted set into the stream atomically so only one subscriber move it.
+// The member is made by encodeDelayedMember, the first value is the maxLen of the publisher and the rest is the stream fields
+var moveDelayedScript = redis.NewScript(`
+local items = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, item in ipairs(items) do
+	local values = {}
+	local pos = 1
+	while pos <= #item do
+		local sep = string.find(item, ':', pos, true)
+		local size = tonumber(string.sub(item, pos, sep - 1))
+		table.insert(values, string.sub(item, sep + 1, sep + size))
+		pos = sep + size + 1
+	end
+	local maxLen = table.remove(values, 1)
+	redis.call('ZREM', KEYS[1], item)
+	if tonumber(maxLen) > 0 then
+		redis.call('XADD', KEYS[2], 'MAXLEN', '~', maxLen, '*', unpack(values))
+	else
+		redis.call('XADD', KEYS[2], '*', unpack(values))
+	end
+end
+return #items
+`)
+
+// encodeDelayedMember join the values with the length prefix, "5:hello3:foo", so the lua script can split it without any library
+func encodeDelayedMember(maxLen int64, fields []string) string {
+	var sb strings.Builder
+	for _, value := range append([]string{strconv.FormatInt(maxLen, 10)}, fields...) {
+		sb.WriteString(strconv.Itoa(len(value)))
+		sb.WriteByte(':')
+		sb.WriteString(value)
+	}
+	return sb.String()
+}
+
+type publisherRedisStreamImpl struct {
+	client redis.UniversalClient
+	maxLen int64
+	option PublishOption
+}
+
+// NewPublisherRedisStream is
+// maxLen is the approximate maximum length of the stream, the oldest entry is trimmed. 0 means no trimming
+//
+//	client, err := cache.NewRedisClient(cfg.Cache)
+//	publisher := messaging.NewPublisherRedisStream(client, 100000)
+func NewPublisherRedisStream(client redis.UniversalClient, maxLen int64, option ...PublishOption) *publisherRedisStreamImpl {
+	return &publisherRedisStreamImpl{
+		client: client,
+		maxLen: maxLen,
+		option: getPublishOption(option),
+	}
+}
+
+// Publish is
+// the delayed message is kept in the sorted set and moved into the stream by the subscriber when the time comes
+func (m *publisherRedisStreamImpl) Publish(ctx context.Context, topic string, delayInMS int, data payload.Payload) error {
+
+	data = stampPayload(ctx, m.option.ApplicationData, data)
+
+	dataInBytes, contentEncoding, err := encodeMessage(m.option.Codec, m.option.CompressThreshold, data)
+	if err != nil {
+		return err
+	}
+
+	fields := []string{
+		fieldBody, string(dataInBytes),
+		fieldContentType, m.option.Codec.ContentType(),
+		fieldContentEncoding, contentEncoding,
+	}
+
+	for k, v := range traceHeaders(data) {
+		fields = append(fields, k, v)
+	}
+
+	if delayInMS > 0 {
+
+		// the delay id make every member unique.
+		// The maxLen is kept with the message since the stream is trimmed when the subscriber move it
+		member := encodeDelayedMember(m.maxLen, append(fields, fieldDelayID, randomHex(8)))
+
+		deliverAt := time.Now().Add(time.Duration(delayInMS) * time.Millisecond).UnixMilli()
+
+		return m.client.ZAdd(ctx, delayedKey(topic), &redis.Z{
+			Score:  float64(deliverAt),
+			Member: member,
+		}).Err()
+	}
+
+	values := make([]any, 0, len(fields))
+	for _, field := range fields {
+		values = append(values, field)
+	}
+
+	return m.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: topic,
+		MaxLen: m.maxLen,
+		Approx: true,
+		Values: values,
+	}).Err()
+}
+
+type subscriberRedisStreamImpl struct {
+	client      redis.UniversalClient
+	group       string
+	consumer    string
+	topicMap    map[string]HandleFunc
+	optionMap   map[string]HandleOption
+	middlewares []Middleware
+
+	// ClaimMinIdle is how long the message is pending before it is reclaimed from the dead consumer
+	ClaimMinIdle time.Duration
+
+	// RetryDelay is how long the message failed by the handler of this consumer wait before it is delivered again.
+	// 0 means the failed message wait ClaimMinIdle like the message of the dead consumer
+	RetryDelay time.Duration
+
+	// PollInterval is how often the delayed message and the pending message are checked
+	PollInterval time.Duration
+
+	// MaxDeliveries is how many times the message is delivered before it is moved to the dead letter stream.
+	// The handler running longer than ClaimMinIdle also add one delivery on every poll
+	MaxDeliveries int64
+
+	// DeadLetterSuffix is added to the topic name to get the dead letter stream.
+	// Empty means the message which reach MaxDeliveries is acknowledged and dropped
+	DeadLetterSuffix string
+}
+
+// NewSubscriberRedisStream is
+// group is the consumer group, it has the same role as the queueName in RabbitMQ and the channel in NSQ
+func NewSubscriberRedisStream(client redis.UniversalClient, group string) *subscriberRedisStreamImpl {
+
+	hostname, _ := os.Hostname()
+
+	return &subscriberRedisStreamImpl{
+		client:           client,
+		group:            group,
+		consumer:         hostname + "-" + randomHex(4),
+		topicMap:         map[string]HandleFunc{},
+		optionMap:        map[string]HandleOption{},
+		ClaimMinIdle:     time.Minute,
+		RetryDelay:       5 * time.Second,
+		PollInterval:     time.Second,
+		MaxDeliveries:    5,
+		DeadLetterSuffix: ".dlq",
+	}
+}
+
+func (r *subscriberRedisStreamImpl) Handle(topic string, onReceived HandleFunc, option ...HandleOption) {
+	r.topicMap[topic] = onReceived
+	r.optionMap[topic] = getHandleOption(option)
+}
+
+func (r *subscriberRedisStreamImpl) Use(middlewares ...Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+// Run is
+// url is not used, the connection is taken from the redis client
+func (r *subscriberRedisStreamImpl) Run(url string) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+
+	for topic, onReceived := range r.topicMap {
+
+		err := r.client.XGroupCreateMkStream(ctx, topic, r.group, "$").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			panic(err.Error())
+		}
+
+		opt := r.optionMap[topic]
+		handler := chainRecovered(onReceived, append(r.middlewares, opt.Middlewares...)...)
+
+		wg.Add(1)
+		go func(topic string) {
+			defer wg.Done()
+			r.consume(ctx, topic, opt, handler)
+		}(topic)
+	}
+
+	termChan := make(chan os.Signal, 1)
+	signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM)
+	<-termChan
+
+	cancel()
+	wg.Wait()
+}
+
+func (r *subscriberRedisStreamImpl) consume(ctx context.Context, topic string, opt HandleOption, handler HandleFunc) {
+
+	pool := newWorkerPoolFromOption(opt)
+	defer pool.Stop()
+
+	// inFlight is the message handled by this consumer, XAUTOCLAIM also return it when the handler take longer than ClaimMinIdle
+	inFlight := newInFlightSet()
+
+	// retry is the message failed by the handler of this consumer
+	retry := newRetrySet()
+
+	submit := func(messages []redis.XMessage) {
+		for _, message := range messages {
+			if !inFlight.Add(message.ID) {
+				continue
+			}
+			r.submit(ctx, pool, topic, opt, handler, message, inFlight, retry)
+		}
+	}
+
+	lastPoll := time.Time{}
+
+	for ctx.Err() == nil {
+
+		if time.Since(lastPoll) >= r.PollInterval {
+			lastPoll = time.Now()
+			r.moveDelayed(ctx, topic)
+			submit(r.deadLetter(ctx, topic, inFlight.Exclude(r.reclaim(ctx, topic, opt))))
+			submit(r.deadLetter(ctx, topic, inFlight.Exclude(r.claimRetry(ctx, topic, retry))))
+		}
+
+		streams, err := r.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    r.group,
+			Consumer: r.consumer,
+			Streams:  []string{topic, ">"},
+			Count:    int64(opt.Prefetch),
+			Block:    r.PollInterval,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+
+		if err != nil {
+			// avoid the busy loop while redis is not reachable
+			select {
+			case <-ctx.Done():
+			case <-time.After(r.PollInterval):
+			}
+			continue
+		}
+
+		for _, stream := range streams {
+			submit(stream.Messages)
+		}
+	}
+}
+
+func (r *subscriberRedisStreamImpl) submit(ctx context.Context, pool *workerPool, topic string, opt HandleOption, handler HandleFunc, message redis.XMessage, inFlight *inFlightSet, retry *retrySet) {
+
+	headers := map[string]string{}
+	for k, v := range message.Values {
+		if s, ok := v.(string); ok {
+			headers[k] = s
+		}
+	}
+
+	data, err := decodeMessage([]byte(headers[fieldBody]), headers[fieldContentType], headers[fieldContentEncoding], opt.MaxBodySize)
+
+	handlerCtx := setTopic(restoreContext(&data, headers), topic)
+
+	pool.Submit(partitionKey(opt, data, err), func() {
+
+		defer inFlight.Remove(message.ID)
+
+		// the failed message stay in the pending list and it is claimed again after RetryDelay
+		if errHandle := handler(handlerCtx, data, err); errHandle != nil {
+			if r.RetryDelay > 0 {
+				retry.Add(message.ID, time.Now().Add(r.RetryDelay))
+			}
+			return
+		}
+
+		_ = r.client.XAck(context.Background(), topic, r.group, message.ID).Err()
+	})
+}
+
+// deadLetter move the reclaimed message which is delivered MaxDeliveries times into the dead letter stream
+// and return the rest to be handled again
+func (r *subscriberRedisStreamImpl) deadLetter(ctx context.Context, topic string, messages []redis.XMessage) []redis.XMessage {
+
+	if len(messages) == 0 || r.MaxDeliveries <= 0 {
+		return messages
+	}
+
+	// the range of the ids can have the other pending message in between, so every id is asked separately
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.XPendingExtCmd, 0, len(messages))
+	for _, message := range messages {
+		cmds = append(cmds, pipe.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: topic,
+			Group:  r.group,
+			Start:  message.ID,
+			End:    message.ID,
+			Count:  1,
+		}))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return messages
+	}
+
+	deliveries := map[string]int64{}
+	for _, cmd := range cmds {
+		for _, p := range cmd.Val() {
+			deliveries[p.ID] = p.RetryCount
+		}
+	}
+
+	result := make([]redis.XMessage, 0, len(messages))
+
+	for _, message := range messages {
+
+		// the claim by XAUTOCLAIM is also counted
+		count := deliveries[message.ID]
+		if count <= r.MaxDeliveries {
+			result = append(result, message)
+			continue
+		}
+
+		if r.DeadLetterSuffix != "" {
+
+			values := make([]any, 0, 2*len(message.Values)+4)
+			for k, v := range message.Values {
+				values = append(values, k, v)
+			}
+			values = append(values, fieldOriginalTopic, topic, fieldDeliveryCount, count-1)
+
+			err := r.client.XAdd(ctx, &redis.XAddArgs{
+				Stream: topic + r.DeadLetterSuffix,
+				Values: values,
+			}).Err()
+			if err != nil {
+				// try again on the next poll
+				continue
+			}
+		}
+
+		_ = r.client.XAck(ctx, topic, r.group, message.ID).Err()
+	}
+
+	return result
+}
+
+// moveDelayed put the due delayed message into the stream
+func (r *subscriberRedisStreamImpl) moveDelayed(ctx context.Context, topic string) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	_ = moveDelayedScript.Run(ctx, r.client, []string{delayedKey(topic), topic}, now, 100).Err()
+}
+
+// reclaim take over the message which is pending too long, either from the dead consumer or the failed handling.
+// XAUTOCLAIM is sent as the raw command since go-redis v8 can not read the 3 elements reply of redis 7
+func (r *subscriberRedisStreamImpl) reclaim(ctx context.Context, topic string, opt HandleOption) []redis.XMessage {
+
+	reply, err := r.client.Do(ctx, "XAUTOCLAIM", topic, r.group, r.consumer, r.ClaimMinIdle.Milliseconds(), "0-0", "COUNT", opt.Prefetch).Slice()
+	if err != nil || len(reply) < 2 {
+		return nil
+	}
+
+	entries, _ := reply[1].([]any)
+
+	messages := make([]redis.XMessage, 0, len(entries))
+	for _, entry := range entries {
+
+		// the entry that is already deleted from the stream is nil in redis 6.2
+		item, ok := entry.([]any)
+		if !ok || len(item) < 2 {
+			continue
+		}
+
+		id, _ := item[0].(string)
+		fields, _ := item[1].([]any)
+
+		values := map[string]any{}
+		for i := 0; i+1 < len(fields); i += 2 {
+			if k, ok := fields[i].(string); ok {
+				values[k] = fields[i+1]
+			}
+		}
+
+		messages = append(messages, redis.XMessage{ID: id, Values: values})
+	}
+
+	return messages
+}
+
+// claimRetry take the failed message of this consumer whose RetryDelay is over.
+// XCLAIM count the delivery like XAUTOCLAIM so the message reach MaxDeliveries too
+func (r *subscriberRedisStreamImpl) claimRetry(ctx context.Context, topic string, retry *retrySet) []redis.XMessage {
+
+	ids := retry.Due(time.Now())
+	if len(ids) == 0 {
+		return nil
+	}
+
+	messages, err := r.client.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   topic,
+		Group:    r.group,
+		Consumer: r.consumer,
+		MinIdle:  r.RetryDelay,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		// the message is still in the pending list and it is reclaimed after ClaimMinIdle
+		return nil
+	}
+
+	return messages
+}
+
+// retrySet keep the id of the failed message by the time it is delivered again
+type retrySet struct {
+	mutex sync.Mutex
+	ids   map[string]time.Time
+}
+
+func newRetrySet() *retrySet {
+	return &retrySet{
+		ids: map[string]time.Time{},
+	}
+}
+
+func (s *retrySet) Add(id string, at time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ids[id] = at
+}
+
+// Due remove and return the id whose time is over
+func (s *retrySet) Due(now time.Time) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var ids []string
+	for id, at := range s.ids {
+		if !now.Before(at) {
+			ids = append(ids, id)
+			delete(s.ids, id)
+		}
+	}
+	return ids
+}
+
+// inFlightSet keep the id of the message which is being handled by this consumer
+type inFlightSet struct {
+	mutex sync.Mutex
+	ids   map[string]struct{}
+}
+
+func newInFlightSet() *inFlightSet {
+	return &inFlightSet{
+		ids: map[string]struct{}{},
+	}
+}
+
+// Add return false if the id is already in flight
+func (s *inFlightSet) Add(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exist := s.ids[id]; exist {
+		return false
+	}
+
+	s.ids[id] = struct{}{}
+	return true
+}
+
+func (s *inFlightSet) Remove(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.ids, id)
+}
+
+// Exclude return the message which is not in flight
+func (s *inFlightSet) Exclude(messages []redis.XMessage) []redis.XMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	result := make([]redis.XMessage, 0, len(messages))
+	for _, message := range messages {
+		if _, exist := s.ids[message.ID]; !exist {
+			result = append(result, message)
+		}
+	}
+	return result
+}
@@ -0,0 +1,253 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"infrastructure/shared/model/payload"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func newTestRedisStream(t *testing.T) (redis.UniversalClient, *subscriberRedisStreamImpl) {
+
+	server := miniredis.RunT(t)
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	sub := NewSubscriberRedisStream(client, "group")
+	sub.PollInterval = 10 * time.Millisecond
+	sub.RetryDelay = 10 * time.Millisecond
+	sub.ClaimMinIdle = time.Hour
+
+	return client, sub
+}
+
+// runRedisConsume create the group like Run and return the function that stop the consume and wait until it return
+func runRedisConsume(t *testing.T, sub *subscriberRedisStreamImpl, handler HandleFunc) func() {
+
+	err := sub.client.XGroupCreateMkStream(context.Background(), "orders", sub.group, "0").Err()
+	if err != nil && err.Error() != "BUSYGROUP Consumer Group name already exists" {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		sub.consume(ctx, "orders", NewDefaultHandleOption(), handler)
+	}()
+
+	return func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("consume does not return after the ctx is cancelled")
+		}
+	}
+}
+
+func publishRedisOrders(t *testing.T, publisher *publisherRedisStreamImpl, delayInMS int, ids ...string) {
+	for _, id := range ids {
+		err := publisher.Publish(context.Background(), "orders", delayInMS, payload.Payload{Data: map[string]any{"id": id}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func pendingCount(t *testing.T, client redis.UniversalClient) int64 {
+	pending, err := client.XPending(context.Background(), "orders", "group").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pending.Count
+}
+
+// handledOrders collect the handled order id, the handler fail while fail return true
+type handledOrders struct {
+	mutex sync.Mutex
+	ids   []string
+	fail  func(id string, calls int) bool
+}
+
+func (h *handledOrders) handle(ctx context.Context, data payload.Payload, err error) error {
+	if err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.ids = append(h.ids, orderID(data))
+
+	if h.fail != nil && h.fail(orderID(data), len(h.ids)) {
+		return errors.New("handler failed")
+	}
+	return nil
+}
+
+func (h *handledOrders) count() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.ids)
+}
+
+func TestRedisStreamAck(t *testing.T) {
+
+	client, sub := newTestRedisStream(t)
+	publisher := NewPublisherRedisStream(client, 0)
+
+	handled := &handledOrders{}
+	stop := runRedisConsume(t, sub, handled.handle)
+	defer stop()
+
+	publishRedisOrders(t, publisher, 0, "O1", "O2")
+
+	waitUntil(t, func() bool { return handled.count() == 2 && pendingCount(t, client) == 0 })
+}
+
+func TestRedisStreamRetryFailedMessageAfterRetryDelay(t *testing.T) {
+
+	client, sub := newTestRedisStream(t)
+	publisher := NewPublisherRedisStream(client, 0)
+
+	// ClaimMinIdle is one hour so only the RetryDelay can deliver it again
+	handled := &handledOrders{fail: func(id string, calls int) bool { return calls == 1 }}
+	stop := runRedisConsume(t, sub, handled.handle)
+	defer stop()
+
+	publishRedisOrders(t, publisher, 0, "O1")
+
+	waitUntil(t, func() bool { return handled.count() == 2 && pendingCount(t, client) == 0 })
+}
+
+func TestRedisStreamReclaimFromDeadConsumer(t *testing.T) {
+
+	client, sub := newTestRedisStream(t)
+	sub.ClaimMinIdle = 50 * time.Millisecond
+	publisher := NewPublisherRedisStream(client, 0)
+
+	ctx := context.Background()
+
+	if err := client.XGroupCreateMkStream(ctx, "orders", "group", "0").Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	publishRedisOrders(t, publisher, 0, "O1")
+
+	// the dead consumer read the message and never ack it
+	err := client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    "group",
+		Consumer: "dead",
+		Streams:  []string{"orders", ">"},
+	}).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handled := &handledOrders{}
+	stop := runRedisConsume(t, sub, handled.handle)
+	defer stop()
+
+	waitUntil(t, func() bool { return handled.count() == 1 && pendingCount(t, client) == 0 })
+}
+
+func TestRedisStreamDeadLetter(t *testing.T) {
+
+	client, sub := newTestRedisStream(t)
+	sub.MaxDeliveries = 2
+	publisher := NewPublisherRedisStream(client, 0)
+
+	handled := &handledOrders{fail: func(id string, calls int) bool { return id == "O1" }}
+	stop := runRedisConsume(t, sub, handled.handle)
+	defer stop()
+
+	publishRedisOrders(t, publisher, 0, "O1", "O2")
+
+	waitUntil(t, func() bool { return client.XLen(context.Background(), "orders.dlq").Val() == 1 })
+	waitUntil(t, func() bool { return pendingCount(t, client) == 0 })
+
+	messages, err := client.XRange(context.Background(), "orders.dlq", "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := messages[0].Values
+	if values[fieldOriginalTopic] != "orders" || values[fieldDeliveryCount] != "2" {
+		t.Fatalf("dead letter has %v %v", values[fieldOriginalTopic], values[fieldDeliveryCount])
+	}
+
+	data, err := decodeMessage([]byte(values[fieldBody].(string)), values[fieldContentType].(string), values[fieldContentEncoding].(string), 0)
+	if err != nil || orderID(data) != "O1" {
+		t.Fatalf("dead letter is %v %v", data, err)
+	}
+
+	// O1 is delivered MaxDeliveries times and O2 once
+	if handled.count() != 3 {
+		t.Fatalf("handler is called %d times, want 3", handled.count())
+	}
+}
+
+func TestRedisStreamDelayedMessage(t *testing.T) {
+
+	client, sub := newTestRedisStream(t)
+	publisher := NewPublisherRedisStream(client, 0)
+
+	handledAt := make(chan time.Time, 1)
+
+	stop := runRedisConsume(t, sub, func(ctx context.Context, data payload.Payload, err error) error {
+		handledAt <- time.Now()
+		return err
+	})
+	defer stop()
+
+	start := time.Now()
+	publishRedisOrders(t, publisher, 100, "O1")
+
+	select {
+	case at := <-handledAt:
+		if at.Sub(start) < 99*time.Millisecond {
+			t.Fatalf("delayed message is handled after %s", at.Sub(start))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("delayed message is not handled")
+	}
+
+	waitUntil(t, func() bool { return pendingCount(t, client) == 0 })
+}
+
+func TestRedisStreamDelayedMessageIsTrimmed(t *testing.T) {
+
+	client, sub := newTestRedisStream(t)
+	publisher := NewPublisherRedisStream(client, 2)
+
+	publishRedisOrders(t, publisher, 1, "O1", "O2", "O3")
+	time.Sleep(5 * time.Millisecond)
+
+	sub.moveDelayed(context.Background(), "orders")
+
+	messages, err := client.XRange(context.Background(), "orders", "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 2 || client.ZCard(context.Background(), delayedKey("orders")).Val() != 0 {
+		t.Fatalf("stream has %d messages", len(messages))
+	}
+
+	// the fields are moved as they are
+	values := messages[1].Values
+	data, err := decodeMessage([]byte(values[fieldBody].(string)), values[fieldContentType].(string), values[fieldContentEncoding].(string), 0)
+	if err != nil || data.ID == "" {
+		t.Fatalf("moved message is %v %v", data, err)
+	}
+}
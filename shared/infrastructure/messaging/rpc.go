@@ -0,0 +1,132 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"infrastructure/shared/model/payload"
+	"time"
+)
+
+// Requester send the request to the topic and wait for the reply.
+// The request is handled by the subscriber of the topic with the HandleFunc from HandleRPC
+type Requester interface {
+	Request(ctx context.Context, topic string, timeout time.Duration, request payload.Payload) (payload.Payload, error)
+}
+
+// Replier send the response back to the requester. The ctx must be the one received by the HandleFunc
+type Replier interface {
+	Reply(ctx context.Context, response payload.Payload) error
+}
+
+// RPCHandleFunc return the response that is sent back to the requester.
+// The returned error is sent back as well and returned by the Requester
+type RPCHandleFunc func(ctx context.Context, request payload.Payload, err error) (payload.Payload, error)
+
+// rpcErrorEventType mark the response which carry the error message of the RPCHandleFunc
+const rpcErrorEventType = "rpc.error"
+
+// ErrRPCTimeout is returned by the Requester when no reply is received within the timeout
+var ErrRPCTimeout = fmt.Errorf("rpc timeout")
+
+// RPCError is the error returned by the RPCHandleFunc on the other side
+type RPCError struct {
+	Message string
+}
+
+func (e RPCError) Error() string {
+	return e.Message
+}
+
+type replyDataType int
+
+const replyDataKey replyDataType = 1
+
+type replyData struct {
+	replyTo       string
+	correlationID string
+}
+
+func setReplyTo(ctx context.Context, replyTo, correlationID string) context.Context {
+	if replyTo == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, replyDataKey, replyData{replyTo: replyTo, correlationID: correlationID})
+}
+
+// getReplyTo return the address and the correlation id of the request, the address is empty if the message is not a request
+func getReplyTo(ctx context.Context) (string, string) {
+	if v, ok := ctx.Value(replyDataKey).(replyData); ok {
+		return v.replyTo, v.correlationID
+	}
+	return "", ""
+}
+
+// HandleRPC adapt the RPCHandleFunc into the HandleFunc which reply the response
+//
+//	subscriber.Handle("user.get", messaging.HandleRPC(publisher, func(ctx context.Context, request payload.Payload, err error) (payload.Payload, error) {
+//		...
+//		return payload.Payload{Data: user}, nil
+//	}))
+func HandleRPC(replier Replier, onRequest RPCHandleFunc) HandleFunc {
+	return func(ctx context.Context, data payload.Payload, err error) error {
+
+		if replyTo, _ := getReplyTo(ctx); replyTo == "" {
+			return fmt.Errorf("message on topic %s is not a request", GetTopic(ctx))
+		}
+
+		response, errResult := onRequest(ctx, data, err)
+		if errResult != nil {
+			response = payload.Payload{
+				Data:      errResult.Error(),
+				EventType: rpcErrorEventType,
+			}
+		}
+
+		return replier.Reply(ctx, response)
+	}
+}
+
+// Request send the typed request and decode the reply into RES
+func Request[REQ, RES any](ctx context.Context, requester Requester, topic string, timeout time.Duration, request REQ) (*RES, error) {
+
+	response, err := requester.Request(ctx, topic, timeout, payload.Payload{
+		Data:      request,
+		EventType: EventTypeOf[REQ](),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result RES
+	err = response.DecodeData(&result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// replyResult turn the error response into RPCError
+func replyResult(response payload.Payload) (payload.Payload, error) {
+	if response.EventType == rpcErrorEventType {
+		message, _ := response.Data.(string)
+		return response, RPCError{Message: message}
+	}
+	return response, nil
+}
+
+// waitReply wait until the reply arrived, the timeout passed or the ctx is done
+func waitReply(ctx context.Context, timeout time.Duration, replyChan <-chan payload.Payload) (payload.Payload, error) {
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case response := <-replyChan:
+		return replyResult(response)
+	case <-timer.C:
+		return payload.Payload{}, ErrRPCTimeout
+	case <-ctx.Done():
+		return payload.Payload{}, ctx.Err()
+	}
+}
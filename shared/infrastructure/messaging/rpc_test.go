@@ -0,0 +1,146 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"infrastructure/shared/model/payload"
+	"testing"
+	"time"
+)
+
+type getUserRequest struct {
+	ID string `json:"id"`
+}
+
+type user struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func newUserBroker() *InProcessBroker {
+
+	broker := NewInProcessBroker()
+
+	broker.Handle("user.get", HandleRPC(broker, func(ctx context.Context, request payload.Payload, err error) (payload.Payload, error) {
+		if err != nil {
+			return payload.Payload{}, err
+		}
+
+		var req getUserRequest
+		if err := request.DecodeData(&req); err != nil {
+			return payload.Payload{}, err
+		}
+
+		if req.ID != "U1" {
+			return payload.Payload{}, errors.New("user " + req.ID + " is not found")
+		}
+
+		return payload.Payload{Data: user{ID: req.ID, Name: "Alice"}}, nil
+	}))
+
+	return broker
+}
+
+func TestRequestReply(t *testing.T) {
+
+	broker := newUserBroker()
+
+	result, err := Request[getUserRequest, user](context.Background(), broker, "user.get", time.Second, getUserRequest{ID: "U1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.ID != "U1" || result.Name != "Alice" {
+		t.Fatalf("reply is %+v", result)
+	}
+}
+
+func TestRequestReturnTheHandlerError(t *testing.T) {
+
+	broker := newUserBroker()
+
+	_, err := Request[getUserRequest, user](context.Background(), broker, "user.get", time.Second, getUserRequest{ID: "U2"})
+
+	var rpcErr RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Message != "user U2 is not found" {
+		t.Fatalf("request return %v", err)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+
+	broker := NewInProcessBroker()
+
+	// the late reply is sent after the requester gave up
+	replied := make(chan error, 1)
+	broker.Handle("user.get", func(ctx context.Context, data payload.Payload, err error) error {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			replied <- broker.Reply(ctx, payload.Payload{Data: "late"})
+		}()
+		return nil
+	})
+
+	_, err := broker.Request(context.Background(), "user.get", 10*time.Millisecond, payload.Payload{})
+	if !errors.Is(err, ErrRPCTimeout) {
+		t.Fatalf("request return %v", err)
+	}
+
+	// the late reply is dropped without error
+	if err := <-replied; err != nil {
+		t.Fatalf("late reply return %v", err)
+	}
+}
+
+func TestRequestWithoutSubscriberFollowTheContext(t *testing.T) {
+
+	broker := NewInProcessBroker()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := broker.Request(ctx, "user.get", time.Minute, payload.Payload{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("request return %v", err)
+	}
+}
+
+func TestReplyWithoutWaitingCaller(t *testing.T) {
+
+	broker := NewInProcessBroker()
+
+	// the request of the unknown correlation id is already gone
+	ctx := setReplyTo(context.Background(), inProcessReplyTo, "unknown")
+	if err := broker.Reply(ctx, payload.Payload{Data: "orphan"}); err != nil {
+		t.Fatalf("reply without caller return %v", err)
+	}
+
+	// the message which is not a request has no reply address
+	if err := broker.Reply(context.Background(), payload.Payload{}); err == nil {
+		t.Fatal("reply outside the request is accepted")
+	}
+}
+
+func TestHandleRPCRejectTheNormalMessage(t *testing.T) {
+
+	broker := NewInProcessBroker()
+
+	handled := make(chan error, 1)
+	handler := HandleRPC(broker, func(ctx context.Context, request payload.Payload, err error) (payload.Payload, error) {
+		return payload.Payload{}, nil
+	})
+
+	broker.Handle("user.get", func(ctx context.Context, data payload.Payload, err error) error {
+		errHandle := handler(ctx, data, err)
+		handled <- errHandle
+		return errHandle
+	})
+
+	if err := broker.Publish(context.Background(), "user.get", 0, payload.Payload{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-handled; err == nil {
+		t.Fatal("normal message is handled as the request")
+	}
+}
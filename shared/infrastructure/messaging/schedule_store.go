@@ -0,0 +1,139 @@
+package messaging
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ScheduledJob is the message that will be published later by the Scheduler.
+// LockedUntil and FailedAt are nil when the job is not locked or not failed, so the column is nullable
+type ScheduledJob struct {
+	ID          string     `json:"id" bson:"_id" gorm:"primaryKey"`
+	Topic       string     `json:"topic" bson:"topic"`
+	Payload     string     `json:"payload" bson:"payload"`
+	Cron        string     `json:"cron" bson:"cron"`
+	NextRunAt   time.Time  `json:"nextRunAt" bson:"next_run_at" gorm:"index"`
+	LockedBy    string     `json:"lockedBy" bson:"locked_by"`
+	LockedUntil *time.Time `json:"lockedUntil" bson:"locked_until"`
+
+	// Attempts is the number of the failed publish since the last success
+	Attempts  int        `json:"attempts" bson:"attempts"`
+	LastError string     `json:"lastError" bson:"last_error"`
+	FailedAt  *time.Time `json:"failedAt" bson:"failed_at"`
+}
+
+// ScheduleStore persist the scheduled job so it survives the restart.
+// The lock make sure only one instance publish the same job
+type ScheduleStore interface {
+
+	// Save insert the new job or replace the existing job with the same ID and release its lock
+	Save(ctx context.Context, job *ScheduledJob) error
+
+	// Delete remove the job, it is not an error if the job is not found
+	Delete(ctx context.Context, id string) error
+
+	// AcquireDue lock the job that is due for the owner until now + lease.
+	// The job which is still locked by other owner or failed is skipped
+	AcquireDue(ctx context.Context, now time.Time, owner string, lease time.Duration, limit int) ([]*ScheduledJob, error)
+
+	// Complete release the lock and save the NextRunAt, Attempts, LastError and FailedAt. The job with zero NextRunAt is deleted.
+	// Nothing is changed if the lock is already taken by other owner
+	Complete(ctx context.Context, job *ScheduledJob) error
+}
+
+type scheduleStoreMemory struct {
+	mutex sync.Mutex
+	jobs  map[string]ScheduledJob
+}
+
+// NewScheduleStoreMemory is the ScheduleStore for the single instance and the test. The job is lost on restart
+func NewScheduleStoreMemory() ScheduleStore {
+	return &scheduleStoreMemory{
+		jobs: map[string]ScheduledJob{},
+	}
+}
+
+func (r *scheduleStoreMemory) Save(ctx context.Context, job *ScheduledJob) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	obj := *job
+	obj.LockedBy = ""
+	obj.LockedUntil = nil
+	r.jobs[job.ID] = obj
+
+	return nil
+}
+
+func (r *scheduleStoreMemory) Delete(ctx context.Context, id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.jobs, id)
+
+	return nil
+}
+
+func (r *scheduleStoreMemory) AcquireDue(ctx context.Context, now time.Time, owner string, lease time.Duration, limit int) ([]*ScheduledJob, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	results := make([]*ScheduledJob, 0)
+	for id, job := range r.jobs {
+
+		if job.NextRunAt.After(now) || job.FailedAt != nil || (job.LockedUntil != nil && job.LockedUntil.After(now)) {
+			continue
+		}
+
+		lockedUntil := now.Add(lease)
+		job.LockedBy = owner
+		job.LockedUntil = &lockedUntil
+		r.jobs[id] = job
+
+		obj := job
+		results = append(results, &obj)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].NextRunAt.Before(results[j].NextRunAt)
+	})
+
+	if len(results) > limit {
+		for _, job := range results[limit:] {
+			obj := r.jobs[job.ID]
+			obj.LockedBy = ""
+			obj.LockedUntil = nil
+			r.jobs[job.ID] = obj
+		}
+		results = results[:limit]
+	}
+
+	return results, nil
+}
+
+func (r *scheduleStoreMemory) Complete(ctx context.Context, job *ScheduledJob) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	existing, exist := r.jobs[job.ID]
+	if !exist || existing.LockedBy != job.LockedBy {
+		return nil
+	}
+
+	if job.NextRunAt.IsZero() {
+		delete(r.jobs, job.ID)
+		return nil
+	}
+
+	existing.NextRunAt = job.NextRunAt
+	existing.Attempts = job.Attempts
+	existing.LastError = job.LastError
+	existing.FailedAt = job.FailedAt
+	existing.LockedBy = ""
+	existing.LockedUntil = nil
+	r.jobs[job.ID] = existing
+
+	return nil
+}
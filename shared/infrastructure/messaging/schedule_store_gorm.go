@@ -0,0 +1,95 @@
+package messaging
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type scheduleStoreGorm struct {
+	db *gorm.DB
+}
+
+// NewScheduleStoreGorm store the job in the scheduled_jobs table. The table is created if not exist
+func NewScheduleStoreGorm(db *gorm.DB) ScheduleStore {
+
+	err := db.AutoMigrate(&ScheduledJob{})
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return &scheduleStoreGorm{db: db}
+}
+
+func (r *scheduleStoreGorm) Save(ctx context.Context, job *ScheduledJob) error {
+	obj := *job
+	obj.LockedBy = ""
+	obj.LockedUntil = nil
+	return r.db.WithContext(ctx).Save(&obj).Error
+}
+
+func (r *scheduleStoreGorm) Delete(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).Delete(&ScheduledJob{}, "id = ?", id).Error
+}
+
+func (r *scheduleStoreGorm) AcquireDue(ctx context.Context, now time.Time, owner string, lease time.Duration, limit int) ([]*ScheduledJob, error) {
+
+	db := r.db.WithContext(ctx)
+
+	var candidates []*ScheduledJob
+	err := db.
+		Where("next_run_at <= ? AND failed_at IS NULL AND (locked_until IS NULL OR locked_until < ?)", now, now).
+		Order("next_run_at").
+		Limit(limit).
+		Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*ScheduledJob, 0)
+	for _, job := range candidates {
+
+		// only one instance succeed to update the row, the other will get 0 affected row
+		result := db.Model(&ScheduledJob{}).
+			Where("id = ? AND (locked_until IS NULL OR locked_until < ?)", job.ID, now).
+			Updates(map[string]any{
+				"locked_by":    owner,
+				"locked_until": now.Add(lease),
+			})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		lockedUntil := now.Add(lease)
+		job.LockedBy = owner
+		job.LockedUntil = &lockedUntil
+		results = append(results, job)
+	}
+
+	return results, nil
+}
+
+func (r *scheduleStoreGorm) Complete(ctx context.Context, job *ScheduledJob) error {
+
+	db := r.db.WithContext(ctx)
+
+	if job.NextRunAt.IsZero() {
+		return db.Delete(&ScheduledJob{}, "id = ? AND locked_by = ?", job.ID, job.LockedBy).Error
+	}
+
+	return db.Model(&ScheduledJob{}).
+		Where("id = ? AND locked_by = ?", job.ID, job.LockedBy).
+		Updates(map[string]any{
+			"next_run_at":  job.NextRunAt,
+			"attempts":     job.Attempts,
+			"last_error":   job.LastError,
+			"failed_at":    job.FailedAt,
+			"locked_by":    "",
+			"locked_until": nil,
+		}).Error
+}
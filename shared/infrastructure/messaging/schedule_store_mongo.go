@@ -0,0 +1,116 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type scheduleStoreMongo struct {
+	coll *mongo.Collection
+}
+
+// NewScheduleStoreMongo store the job in the scheduled_job collection
+func NewScheduleStoreMongo(db *mongo.Database) ScheduleStore {
+	return &scheduleStoreMongo{
+		coll: db.Collection("scheduled_job"),
+	}
+}
+
+func (r *scheduleStoreMongo) Save(ctx context.Context, job *ScheduledJob) error {
+
+	obj := *job
+	obj.LockedBy = ""
+	obj.LockedUntil = nil
+
+	opts := options.Replace().SetUpsert(true)
+
+	_, err := r.coll.ReplaceOne(ctx, bson.M{"_id": job.ID}, obj, opts)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *scheduleStoreMongo) Delete(ctx context.Context, id string) error {
+
+	_, err := r.coll.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *scheduleStoreMongo) AcquireDue(ctx context.Context, now time.Time, owner string, lease time.Duration, limit int) ([]*ScheduledJob, error) {
+
+	// the nil match both the null and the missing field
+	filter := bson.M{
+		"next_run_at": bson.M{"$lte": now},
+		"failed_at":   nil,
+		"$or": bson.A{
+			bson.M{"locked_until": nil},
+			bson.M{"locked_until": bson.M{"$lt": now}},
+		},
+	}
+
+	update := bson.M{"$set": bson.M{
+		"locked_by":    owner,
+		"locked_until": now.Add(lease),
+	}}
+
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.M{"next_run_at": 1}).
+		SetReturnDocument(options.After)
+
+	results := make([]*ScheduledJob, 0)
+	for len(results) < limit {
+
+		// the update is atomic per document so only one instance acquire the job
+		var job ScheduledJob
+		err := r.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, &job)
+	}
+
+	return results, nil
+}
+
+func (r *scheduleStoreMongo) Complete(ctx context.Context, job *ScheduledJob) error {
+
+	filter := bson.M{
+		"_id":       job.ID,
+		"locked_by": job.LockedBy,
+	}
+
+	if job.NextRunAt.IsZero() {
+		_, err := r.coll.DeleteOne(ctx, filter)
+		return err
+	}
+
+	_, err := r.coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+		"next_run_at":  job.NextRunAt,
+		"attempts":     job.Attempts,
+		"last_error":   job.LastError,
+		"failed_at":    job.FailedAt,
+		"locked_by":    "",
+		"locked_until": nil,
+	}})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package messaging
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestScheduleStoreMemory(t *testing.T) {
+	testScheduleStore(t, NewScheduleStoreMemory())
+}
+
+func TestScheduleStoreGorm(t *testing.T) {
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "schedule.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testScheduleStore(t, NewScheduleStoreGorm(db))
+}
+
+// testScheduleStore check the behavior that every ScheduleStore must have.
+// The time is in the whole second UTC since sqlite compare the time as the text
+func testScheduleStore(t *testing.T, store ScheduleStore) {
+
+	ctx := context.Background()
+	now := time.Date(2030, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	acquire := func(at time.Time, owner string) []*ScheduledJob {
+		t.Helper()
+		jobs, err := store.AcquireDue(ctx, at, owner, time.Minute, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return jobs
+	}
+
+	for _, id := range []string{"J1", "J2"} {
+		err := store.Save(ctx, &ScheduledJob{ID: id, Topic: "report", Payload: "{}", NextRunAt: now})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if jobs := acquire(now.Add(-time.Second), "A"); len(jobs) != 0 {
+		t.Fatalf("%d jobs are acquired before they are due", len(jobs))
+	}
+
+	jobs, err := store.AcquireDue(ctx, now, "A", time.Minute, 1)
+	if err != nil || len(jobs) != 1 || jobs[0].LockedBy != "A" || jobs[0].LockedUntil == nil {
+		t.Fatalf("acquire with the limit return %v %v", jobs, err)
+	}
+	locked := jobs[0]
+
+	// the job locked by A is skipped by B until the lease is over
+	if jobs := acquire(now, "B"); len(jobs) != 1 || jobs[0].ID == locked.ID {
+		t.Fatalf("B acquire %v", jobs)
+	}
+	if jobs := acquire(now.Add(2*time.Minute), "B"); len(jobs) != 2 {
+		t.Fatalf("B acquire %d jobs after the lease", len(jobs))
+	}
+
+	// the complete by the previous owner is ignored
+	locked.NextRunAt = time.Time{}
+	if err := store.Complete(ctx, locked); err != nil {
+		t.Fatal(err)
+	}
+
+	retried := &ScheduledJob{
+		ID:        locked.ID,
+		NextRunAt: now.Add(time.Hour),
+		LockedBy:  "B",
+		Attempts:  2,
+		LastError: "broker is down",
+	}
+	if err := store.Complete(ctx, retried); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs = acquire(now.Add(time.Hour), "C")
+	if len(jobs) != 2 {
+		t.Fatalf("C acquire %d jobs", len(jobs))
+	}
+
+	failed := jobs[0]
+	if failed.ID != locked.ID {
+		failed = jobs[1]
+	}
+	if failed.Attempts != 2 || failed.LastError != "broker is down" {
+		t.Fatalf("retried job has %d attempts %q", failed.Attempts, failed.LastError)
+	}
+
+	// the failed job is kept but never acquired again
+	failedAt := now.Add(time.Hour)
+	failed.FailedAt = &failedAt
+	for _, job := range jobs {
+		if err := store.Complete(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if jobs := acquire(now.Add(24*time.Hour), "D"); len(jobs) != 1 || jobs[0].ID == failed.ID {
+		t.Fatalf("D acquire %v", jobs)
+	}
+
+	// the save replace the failed job
+	if err := store.Save(ctx, &ScheduledJob{ID: failed.ID, Topic: "report", Payload: "{}", NextRunAt: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs = acquire(now.Add(48*time.Hour), "E")
+	if len(jobs) != 2 {
+		t.Fatalf("E acquire %d jobs", len(jobs))
+	}
+
+	// the job with the zero NextRunAt is deleted
+	for _, job := range jobs {
+		job.NextRunAt = time.Time{}
+		if err := store.Complete(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if jobs := acquire(now.Add(72*time.Hour), "F"); len(jobs) != 0 {
+		t.Fatalf("completed jobs are acquired %v", jobs)
+	}
+}
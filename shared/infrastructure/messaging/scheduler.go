@@ -0,0 +1,223 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"infrastructure/shared/infrastructure/logger"
+	"infrastructure/shared/model/payload"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Scheduler publish the message at the given time or repeatedly by the cron expression.
+// Unlike the delayInMS in Publisher, the job is persisted in the ScheduleStore so it has no delay limit and survives the restart.
+// Many instances can run the Scheduler with the same store, the store lock make sure the job is published once
+//
+//	scheduler := messaging.NewScheduler(publisher, messaging.NewScheduleStoreGorm(db), appData.AppInstanceID, log)
+//	err := scheduler.PublishEvery(ctx, "daily-report", "report.generate", "0 1 * * *", payload.Payload{})
+//	go scheduler.Run(ctx)
+type Scheduler struct {
+	publisher Publisher
+	store     ScheduleStore
+	owner     string
+	log       logger.Logger
+
+	// PollInterval is how often the store is checked for the due job
+	PollInterval time.Duration
+
+	// LockLease is how long the job is locked by this instance. It must be longer than the time to publish the job
+	LockLease time.Duration
+
+	// BatchSize is the maximum number of job acquired in one poll
+	BatchSize int
+
+	// MaxAttempts is how many times the job is published before it gives up. The one time job is marked failed
+	// and kept in the store with the LastError, the recurring job skip to the next run. 0 means retry forever
+	MaxAttempts int
+
+	// RetryBackoff is the wait before the first retry of the failed publish, it is doubled on every attempt up to maxRetryBackoff
+	RetryBackoff time.Duration
+}
+
+const maxRetryBackoff = time.Hour
+
+// NewScheduler create the scheduler. owner identify this instance in the lock, use the application instance id
+func NewScheduler(publisher Publisher, store ScheduleStore, owner string, log logger.Logger) *Scheduler {
+	return &Scheduler{
+		publisher:    publisher,
+		store:        store,
+		owner:        owner,
+		log:          log,
+		PollInterval: time.Second,
+		LockLease:    30 * time.Second,
+		BatchSize:    100,
+		MaxAttempts:  5,
+		RetryBackoff: 10 * time.Second,
+	}
+}
+
+// PublishAt publish the payload once at the given time. The job with the same id is replaced, also the failed one
+func (r *Scheduler) PublishAt(ctx context.Context, id, topic string, at time.Time, data payload.Payload) error {
+	return r.save(ctx, id, topic, "", at, data)
+}
+
+// PublishEvery publish the payload repeatedly by the standard 5 fields cron expression ("minute hour day month weekday").
+// Calling it again with the same id on every startup is safe, it only replace the job
+func (r *Scheduler) PublishEvery(ctx context.Context, id, topic, cronExpression string, data payload.Payload) error {
+
+	schedule, err := cron.ParseStandard(cronExpression)
+	if err != nil {
+		return err
+	}
+
+	return r.save(ctx, id, topic, cronExpression, schedule.Next(time.Now()), data)
+}
+
+// Cancel remove the job
+func (r *Scheduler) Cancel(ctx context.Context, id string) error {
+	return r.store.Delete(ctx, id)
+}
+
+func (r *Scheduler) save(ctx context.Context, id, topic, cronExpression string, at time.Time, data payload.Payload) error {
+
+	if id == "" {
+		return fmt.Errorf("job id must not empty")
+	}
+
+	// keep the trace of the caller since the job is published without the caller context
+	data = stampPayload(ctx, data.Publisher, data)
+
+	// every run of the recurring job is the new message
+	if cronExpression != "" {
+		data.ID = ""
+	}
+
+	dataInBytes, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	return r.store.Save(ctx, &ScheduledJob{
+		ID:        id,
+		Topic:     topic,
+		Payload:   string(dataInBytes),
+		Cron:      cronExpression,
+		NextRunAt: at,
+	})
+}
+
+// Run poll the store until the context is canceled
+func (r *Scheduler) Run(ctx context.Context) {
+
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.runDueJobs(ctx)
+		}
+	}
+}
+
+func (r *Scheduler) runDueJobs(ctx context.Context) {
+
+	jobs, err := r.store.AcquireDue(ctx, time.Now(), r.owner, r.LockLease, r.BatchSize)
+	if err != nil {
+		r.log.Error(ctx, "acquire scheduled job %v", err.Error())
+		return
+	}
+
+	for _, job := range jobs {
+
+		err := r.publish(ctx, job)
+		if err != nil {
+			r.log.Error(ctx, "publish scheduled job %s %v", job.ID, err.Error())
+			r.retry(ctx, job, err)
+		} else {
+			job.Attempts = 0
+			job.LastError = ""
+			job.NextRunAt = r.nextRunAt(ctx, job)
+		}
+
+		err = r.store.Complete(ctx, job)
+		if err != nil {
+			r.log.Error(ctx, "complete scheduled job %s %v", job.ID, err.Error())
+		}
+	}
+}
+
+func (r *Scheduler) publish(ctx context.Context, job *ScheduledJob) error {
+
+	var data payload.Payload
+	err := json.Unmarshal([]byte(job.Payload), &data)
+	if err != nil {
+		return err
+	}
+
+	// every run of the recurring job is the new trace
+	if job.Cron != "" {
+		data.TraceID = ""
+	}
+
+	if data.TraceID != "" {
+		ctx = logger.SetTraceID(ctx, data.TraceID)
+	}
+
+	data.SpanID = ""
+
+	return r.publisher.Publish(ctx, job.Topic, 0, data)
+}
+
+// retry set the NextRunAt of the failed job with the exponential backoff, or give up after MaxAttempts
+func (r *Scheduler) retry(ctx context.Context, job *ScheduledJob, err error) {
+
+	job.Attempts++
+	job.LastError = err.Error()
+
+	if r.MaxAttempts > 0 && job.Attempts >= r.MaxAttempts {
+
+		if job.Cron != "" {
+			r.log.Error(ctx, "scheduled job %s fail %d times, skip to the next run", job.ID, job.Attempts)
+			job.Attempts = 0
+			job.NextRunAt = r.nextRunAt(ctx, job)
+			return
+		}
+
+		r.log.Error(ctx, "scheduled job %s fail %d times, it is marked failed", job.ID, job.Attempts)
+		now := time.Now()
+		job.FailedAt = &now
+		return
+	}
+
+	backoff := r.RetryBackoff
+	for i := 1; i < job.Attempts && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+
+	job.NextRunAt = time.Now().Add(backoff)
+}
+
+// nextRunAt return the zero time for the one time job so it is deleted
+func (r *Scheduler) nextRunAt(ctx context.Context, job *ScheduledJob) time.Time {
+
+	if job.Cron == "" {
+		return time.Time{}
+	}
+
+	schedule, err := cron.ParseStandard(job.Cron)
+	if err != nil {
+		r.log.Error(ctx, "invalid cron expression on job %s %v", job.ID, err.Error())
+		return time.Time{}
+	}
+
+	// the missed run while the service is down is skipped
+	return schedule.Next(time.Now())
+}
@@ -0,0 +1,201 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"infrastructure/shared/infrastructure/logger"
+	"infrastructure/shared/model/payload"
+	"sync"
+	"testing"
+	"time"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(ctx context.Context, message string, args ...any) {}
+func (nopLogger) Info(ctx context.Context, message string, args ...any)  {}
+func (nopLogger) Warn(ctx context.Context, message string, args ...any)  {}
+func (nopLogger) Error(ctx context.Context, message string, args ...any) {}
+func (nopLogger) Fatal(ctx context.Context, message string, args ...any) {}
+func (l nopLogger) With(keyValues ...any) logger.Logger                  { return l }
+
+// fakePublisher keep the published payload and return err while it is set
+type fakePublisher struct {
+	mutex     sync.Mutex
+	err       error
+	published []payload.Payload
+}
+
+func (f *fakePublisher) Publish(ctx context.Context, topic string, delayInMS int, data payload.Payload) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.err != nil {
+		return f.err
+	}
+
+	f.published = append(f.published, data)
+	return nil
+}
+
+func (f *fakePublisher) count() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.published)
+}
+
+func newTestScheduler(publisher Publisher) (*Scheduler, *scheduleStoreMemory) {
+	store := NewScheduleStoreMemory().(*scheduleStoreMemory)
+	scheduler := NewScheduler(publisher, store, "instance-1", nopLogger{})
+	scheduler.RetryBackoff = 10 * time.Millisecond
+	scheduler.MaxAttempts = 3
+	return scheduler, store
+}
+
+func storedJob(store *scheduleStoreMemory, id string) (ScheduledJob, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	job, exist := store.jobs[id]
+	return job, exist
+}
+
+func TestSchedulerPublishOneTimeJob(t *testing.T) {
+
+	publisher := &fakePublisher{}
+	scheduler, store := newTestScheduler(publisher)
+	ctx := context.Background()
+
+	err := scheduler.PublishAt(ctx, "J1", "report", time.Now().Add(-time.Second), payload.Payload{Data: map[string]any{"id": "O1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scheduler.runDueJobs(ctx)
+	scheduler.runDueJobs(ctx)
+
+	if publisher.count() != 1 || orderID(publisher.published[0]) != "O1" || publisher.published[0].ID == "" {
+		t.Fatalf("published %v", publisher.published)
+	}
+
+	if _, exist := storedJob(store, "J1"); exist {
+		t.Fatal("one time job is not deleted after it is published")
+	}
+}
+
+func TestSchedulerRetryWithBackoffThenMarkFailed(t *testing.T) {
+
+	publisher := &fakePublisher{err: errors.New("broker is down")}
+	scheduler, store := newTestScheduler(publisher)
+	ctx := context.Background()
+
+	err := scheduler.PublishAt(ctx, "J1", "report", time.Now().Add(-time.Second), payload.Payload{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for attempt := 1; attempt < scheduler.MaxAttempts; attempt++ {
+
+		before := time.Now()
+		scheduler.runDueJobs(ctx)
+
+		job, _ := storedJob(store, "J1")
+		backoff := scheduler.RetryBackoff << (attempt - 1)
+		if job.Attempts != attempt || job.LastError != "broker is down" || job.NextRunAt.Before(before.Add(backoff)) {
+			t.Fatalf("attempt %d is %d %q next %s", attempt, job.Attempts, job.LastError, job.NextRunAt.Sub(before))
+		}
+
+		// the job is not published again before the backoff
+		scheduler.runDueJobs(ctx)
+		if job, _ := storedJob(store, "J1"); job.Attempts != attempt {
+			t.Fatalf("job is retried before the backoff, attempts %d", job.Attempts)
+		}
+
+		time.Sleep(time.Until(job.NextRunAt))
+	}
+
+	scheduler.runDueJobs(ctx)
+
+	job, exist := storedJob(store, "J1")
+	if !exist || job.FailedAt == nil || job.Attempts != scheduler.MaxAttempts {
+		t.Fatalf("job after the last attempt is %+v", job)
+	}
+
+	// the failed job is not retried even when the broker is back
+	publisher.mutex.Lock()
+	publisher.err = nil
+	publisher.mutex.Unlock()
+
+	scheduler.runDueJobs(ctx)
+	if publisher.count() != 0 {
+		t.Fatal("failed job is published")
+	}
+
+	// schedule it again
+	err = scheduler.PublishAt(ctx, "J1", "report", time.Now().Add(-time.Second), payload.Payload{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scheduler.runDueJobs(ctx)
+	if publisher.count() != 1 {
+		t.Fatal("job scheduled again is not published")
+	}
+}
+
+func TestSchedulerRecurringJobSkipToTheNextRun(t *testing.T) {
+
+	publisher := &fakePublisher{err: errors.New("broker is down")}
+	scheduler, store := newTestScheduler(publisher)
+	scheduler.MaxAttempts = 1
+	ctx := context.Background()
+
+	err := scheduler.PublishEvery(ctx, "J1", "report", "* * * * *", payload.Payload{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// make it due now
+	job, _ := storedJob(store, "J1")
+	job.NextRunAt = time.Now().Add(-time.Second)
+	if err := store.Save(ctx, &job); err != nil {
+		t.Fatal(err)
+	}
+
+	scheduler.runDueJobs(ctx)
+
+	job, _ = storedJob(store, "J1")
+	if job.FailedAt != nil || job.Attempts != 0 || !job.NextRunAt.After(time.Now()) || job.LastError != "broker is down" {
+		t.Fatalf("recurring job after the last attempt is %+v", job)
+	}
+}
+
+func TestSchedulerRecurringJobIsNewMessageEveryRun(t *testing.T) {
+
+	publisher := &fakePublisher{}
+	scheduler, store := newTestScheduler(publisher)
+	ctx := logger.SetTraceID(context.Background(), "4bf92f3577b34da6a3ce929d0e0e4736")
+
+	err := scheduler.PublishEvery(ctx, "J1", "report", "* * * * *", payload.Payload{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		job, _ := storedJob(store, "J1")
+		job.NextRunAt = time.Now().Add(-time.Second)
+		if err := store.Save(ctx, &job); err != nil {
+			t.Fatal(err)
+		}
+		scheduler.runDueJobs(context.Background())
+	}
+
+	if publisher.count() != 2 {
+		t.Fatalf("published %d times", publisher.count())
+	}
+
+	for _, data := range publisher.published {
+		if data.ID != "" || data.TraceID == "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Fatalf("recurring run reuse the id %q or the trace %q", data.ID, data.TraceID)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package messaging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"infrastructure/shared/driver"
+	"infrastructure/shared/infrastructure/logger"
+	"infrastructure/shared/model/payload"
+	"regexp"
+	"strings"
+)
+
+const (
+	// headerTraceParent is the W3C trace context header https://www.w3.org/TR/trace-context/#traceparent-header
+	headerTraceParent = "traceparent"
+
+	// headerTraceID keep the original trace id since not every trace id is a valid W3C trace id
+	headerTraceID = "x-trace-id"
+)
+
+var matchTraceParent = regexp.MustCompile("^00-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$")
+var matchTraceID = regexp.MustCompile("^[0-9a-f]{1,32}$")
+var matchSpanID = regexp.MustCompile("^[0-9a-f]{1,16}$")
+
+// stampPayload fill the message id, the trace id, the span id and the publisher before the payload is sent.
+// The span of the caller is the parent of the consumer, the new span is only created when the context has no span.
+// The value which is already set by the caller is kept
+func stampPayload(ctx context.Context, appData driver.ApplicationData, data payload.Payload) payload.Payload {
+
+	if data.ID == "" {
+		data.ID = randomHex(16)
+	}
+
+	if data.TraceID == "" {
+		data.TraceID = getTraceID(ctx)
+	}
+
+	if data.SpanID == "" {
+		data.SpanID = getSpanID(ctx)
+	}
+
+	if data.Publisher == (driver.ApplicationData{}) {
+		data.Publisher = appData
+	}
+
+	return data
+}
+
+// getTraceID take the trace id from the context or create the new one if the context has no trace yet
+func getTraceID(ctx context.Context) string {
+	traceID := logger.GetTraceID(ctx)
+	if strings.Trim(traceID, "0") == "" {
+		return randomHex(16)
+	}
+	return traceID
+}
+
+// getSpanID take the span id from the context or create the new one if the context has no span yet
+func getSpanID(ctx context.Context) string {
+	spanID := logger.GetSpanID(ctx)
+	if strings.Trim(spanID, "0") == "" {
+		return randomHex(8)
+	}
+	return spanID
+}
+
+// traceHeaders return the headers to propagate the trace to the non gogen consumer
+func traceHeaders(data payload.Payload) map[string]string {
+
+	headers := map[string]string{
+		headerTraceID: data.TraceID,
+	}
+
+	// W3C allow the shorter id to be left padded with zero
+	traceID := strings.ToLower(data.TraceID)
+	if matchTraceID.MatchString(traceID) && matchSpanID.MatchString(data.SpanID) {
+		headers[headerTraceParent] = fmt.Sprintf("00-%s-%s-01", padZero(traceID, 32), padZero(data.SpanID, 16))
+	}
+
+	return headers
+}
+
+// restoreContext create the handler context with the trace id and the publisher span id.
+// The trace in the payload is used first, then the header for the message coming from the non gogen publisher.
+// The trace from the header is also put into the payload so the handler see the same trace in both
+func restoreContext(data *payload.Payload, headers map[string]string) context.Context {
+
+	traceID, spanID := data.TraceID, data.SpanID
+
+	if traceID == "" {
+		traceID = headers[headerTraceID]
+	}
+
+	if match := matchTraceParent.FindStringSubmatch(headers[headerTraceParent]); match != nil {
+		if traceID == "" {
+			traceID = match[1]
+		}
+		if spanID == "" {
+			spanID = match[2]
+		}
+	}
+
+	data.TraceID, data.SpanID = traceID, spanID
+
+	ctx := context.Background()
+
+	if traceID != "" {
+		ctx = logger.SetTraceID(ctx, traceID)
+	}
+
+	if spanID != "" {
+		ctx = logger.SetSpanID(ctx, spanID)
+	}
+
+	return ctx
+}
+
+func padZero(s string, length int) string {
+	if len(s) >= length {
+		return s
+	}
+	return strings.Repeat("0", length-len(s)) + s
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,49 @@
+package messaging
+
+import (
+	"context"
+	"infrastructure/shared/driver"
+	"infrastructure/shared/infrastructure/logger"
+	"infrastructure/shared/model/payload"
+	"testing"
+)
+
+func TestStampPayloadUseTheCallerSpanAsParent(t *testing.T) {
+
+	ctx := logger.SetTraceID(context.Background(), "4bf92f3577b34da6a3ce929d0e0e4736")
+	ctx = logger.SetSpanID(ctx, "00f067aa0ba902b7")
+
+	data := stampPayload(ctx, driver.ApplicationData{AppName: "order"}, payload.Payload{})
+
+	if data.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || data.SpanID != "00f067aa0ba902b7" {
+		t.Fatalf("trace is %s %s", data.TraceID, data.SpanID)
+	}
+
+	consumerCtx := restoreContext(&data, traceHeaders(data))
+	if logger.GetTraceID(consumerCtx) != data.TraceID || logger.GetSpanID(consumerCtx) != data.SpanID {
+		t.Fatalf("consumer context has %s %s", logger.GetTraceID(consumerCtx), logger.GetSpanID(consumerCtx))
+	}
+}
+
+func TestStampPayloadCreateTheSpanWithoutCaller(t *testing.T) {
+
+	data := stampPayload(context.Background(), driver.ApplicationData{}, payload.Payload{})
+
+	if len(data.TraceID) != 32 || len(data.SpanID) != 16 {
+		t.Fatalf("trace is %s %s", data.TraceID, data.SpanID)
+	}
+}
+
+func TestRestoreContextFillTheTraceFromHeader(t *testing.T) {
+
+	data := payload.Payload{}
+	ctx := restoreContext(&data, map[string]string{headerTraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"})
+
+	if data.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || data.SpanID != "00f067aa0ba902b7" {
+		t.Fatalf("payload trace is %s %s", data.TraceID, data.SpanID)
+	}
+
+	if logger.GetSpanID(ctx) != data.SpanID {
+		t.Fatalf("context span is %s", logger.GetSpanID(ctx))
+	}
+}
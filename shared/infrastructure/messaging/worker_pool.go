@@ -0,0 +1,74 @@
+package messaging
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// workerPool run the submitted job with a fixed number of goroutine.
+// When it is not partitioned all the workers share one queue,
+// when it is partitioned every worker has its own queue so the job with the same key is executed in order
+type workerPool struct {
+	shared chan func()
+	queues []chan func()
+	wg     sync.WaitGroup
+}
+
+func newWorkerPool(concurrency, prefetch int, partitioned bool) *workerPool {
+
+	w := &workerPool{}
+
+	if !partitioned {
+		w.shared = make(chan func())
+		for i := 0; i < concurrency; i++ {
+			w.start(w.shared)
+		}
+		return w
+	}
+
+	w.queues = make([]chan func(), concurrency)
+	for i := range w.queues {
+		w.queues[i] = make(chan func(), prefetch/concurrency)
+		w.start(w.queues[i])
+	}
+
+	return w
+}
+
+func (w *workerPool) start(queue chan func()) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		for job := range queue {
+			job()
+		}
+	}()
+}
+
+// Submit is blocked until the worker is able to accept the job.
+// This is what keep the slow handler from pulling unbounded message
+func (w *workerPool) Submit(key string, job func()) {
+
+	if w.shared != nil {
+		w.shared <- job
+		return
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	w.queues[h.Sum32()%uint32(len(w.queues))] <- job
+}
+
+// Stop wait all the submitted job finished. Submit must not be called after Stop
+func (w *workerPool) Stop() {
+
+	if w.shared != nil {
+		close(w.shared)
+	}
+
+	for _, queue := range w.queues {
+		close(queue)
+	}
+
+	w.wg.Wait()
+}
@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type Algorithm int
+
+const (
+	// FixedWindow count the request in the window which start from the first request
+	FixedWindow Algorithm = iota
+
+	// SlidingWindow count the request in the last period, it is exact but keep every request time
+	SlidingWindow
+
+	// TokenBucket refill Rate token every Period up to Burst, it allow the short burst
+	TokenBucket
+)
+
+// Limit is Rate request per Period. Burst is only used by TokenBucket, 0 means the same as Rate
+type Limit struct {
+	Rate   int64
+	Period time.Duration
+	Burst  int64
+}
+
+func PerSecond(rate int64) Limit {
+	return Limit{Rate: rate, Period: time.Second}
+}
+
+func PerMinute(rate int64) Limit {
+	return Limit{Rate: rate, Period: time.Minute}
+}
+
+func PerHour(rate int64) Limit {
+	return Limit{Rate: rate, Period: time.Hour}
+}
+
+func (l Limit) SetBurst(burst int64) Limit {
+	l.Burst = burst
+	return l
+}
+
+// Validate reject the limit which the algorithm can not compute, the Period is at least one millisecond since redis keep the time in millisecond
+func (l Limit) Validate() error {
+
+	if l.Rate <= 0 {
+		return fmt.Errorf("rate limit rate must be more than 0, got %d", l.Rate)
+	}
+
+	if l.Period < time.Millisecond {
+		return fmt.Errorf("rate limit period must be at least 1ms, got %s", l.Period)
+	}
+
+	if l.Burst < 0 {
+		return fmt.Errorf("rate limit burst must not be negative, got %d", l.Burst)
+	}
+
+	return nil
+}
+
+func (l Limit) burst() int64 {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Rate
+}
+
+// Result is the state of the key after the call
+type Result struct {
+	Allowed bool
+
+	Limit int64
+
+	// Remaining is the number of request still allowed now
+	Remaining int64
+
+	// RetryAfter is how long to wait before the denied request can be allowed, 0 when allowed
+	RetryAfter time.Duration
+}
+
+// Limiter decide whether the request of the key is allowed. The key is usually the user id or the api key
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Result, error)
+
+	// AllowN take n request at once, nothing is taken when it is denied
+	AllowN(ctx context.Context, key string, n int64) (Result, error)
+}
+
+// LimiterOption start from NewDefaultLimiterOption then override the value with the setter
+type LimiterOption struct {
+
+	// Prefix is put in front of every key
+	Prefix string
+}
+
+func NewDefaultLimiterOption() LimiterOption {
+	return LimiterOption{
+		Prefix: "ratelimit:",
+	}
+}
+
+func (l LimiterOption) SetPrefix(prefix string) LimiterOption {
+	l.Prefix = prefix
+	return l
+}
+
+func getLimiterOption(option []LimiterOption) LimiterOption {
+	if len(option) > 0 {
+		return option[0]
+	}
+	return NewDefaultLimiterOption()
+}
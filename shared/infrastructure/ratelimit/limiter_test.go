@@ -0,0 +1,188 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// newLimiterFunc create the limiter and the function that move its clock forward
+type newLimiterFunc func(t *testing.T, algorithm Algorithm, limit Limit) (Limiter, func(d time.Duration))
+
+func newTestMemoryLimiter(t *testing.T, algorithm Algorithm, limit Limit) (Limiter, func(d time.Duration)) {
+
+	now := time.Date(2030, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	limiter := NewMemoryLimiter(algorithm, limit).(*memoryLimiter)
+	limiter.now = func() time.Time { return now }
+
+	return limiter, func(d time.Duration) {
+		now = now.Add(d)
+	}
+}
+
+func newTestRedisLimiter(t *testing.T, algorithm Algorithm, limit Limit) (Limiter, func(d time.Duration)) {
+
+	server := miniredis.RunT(t)
+
+	now := time.Date(2030, 1, 1, 10, 0, 0, 0, time.UTC)
+	server.SetTime(now)
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	// the TIME in the script follow SetTime and the key expire by FastForward
+	return NewRedisLimiter(client, algorithm, limit), func(d time.Duration) {
+		now = now.Add(d)
+		server.SetTime(now)
+		server.FastForward(d)
+	}
+}
+
+// step is one call of AllowN after the clock move by advance
+type step struct {
+	advance    time.Duration
+	n          int64
+	allowed    bool
+	remaining  int64
+	retryAfter time.Duration
+}
+
+func TestLimiter(t *testing.T) {
+
+	testCases := []struct {
+		name      string
+		algorithm Algorithm
+		limit     Limit
+		steps     []step
+	}{
+		{
+			name:      "fixed window",
+			algorithm: FixedWindow,
+			limit:     PerSecond(2),
+			steps: []step{
+				{n: 1, allowed: true, remaining: 1},
+				{advance: 400 * time.Millisecond, n: 1, allowed: true, remaining: 0},
+				{n: 1, allowed: false, remaining: 0, retryAfter: 600 * time.Millisecond},
+				{advance: 600 * time.Millisecond, n: 2, allowed: true, remaining: 0},
+			},
+		},
+		{
+			name:      "fixed window denied request does not move the window",
+			algorithm: FixedWindow,
+			limit:     PerSecond(2),
+			steps: []step{
+				{n: 3, allowed: false, remaining: 2, retryAfter: time.Second},
+				{advance: 600 * time.Millisecond, n: 2, allowed: true, remaining: 0},
+				{n: 1, allowed: false, remaining: 0, retryAfter: 400 * time.Millisecond},
+				{advance: 400 * time.Millisecond, n: 1, allowed: true, remaining: 1},
+			},
+		},
+		{
+			name:      "sliding window",
+			algorithm: SlidingWindow,
+			limit:     PerSecond(2),
+			steps: []step{
+				{n: 1, allowed: true, remaining: 1},
+				{advance: 400 * time.Millisecond, n: 1, allowed: true, remaining: 0},
+				{advance: 200 * time.Millisecond, n: 1, allowed: false, remaining: 0, retryAfter: 400 * time.Millisecond},
+				{advance: 400 * time.Millisecond, n: 1, allowed: true, remaining: 0},
+				{n: 3, allowed: false, remaining: 0, retryAfter: time.Second},
+			},
+		},
+		{
+			name:      "token bucket",
+			algorithm: TokenBucket,
+			limit:     PerSecond(2).SetBurst(4),
+			steps: []step{
+				{n: 4, allowed: true, remaining: 0},
+				{n: 1, allowed: false, remaining: 0, retryAfter: 500 * time.Millisecond},
+				{advance: 500 * time.Millisecond, n: 1, allowed: true, remaining: 0},
+				{advance: 5 * time.Second, n: 5, allowed: false, remaining: 4, retryAfter: 500 * time.Millisecond},
+				{n: 4, allowed: true, remaining: 0},
+			},
+		},
+	}
+
+	limiters := map[string]newLimiterFunc{
+		"memory": newTestMemoryLimiter,
+		"redis":  newTestRedisLimiter,
+	}
+
+	for limiterName, newLimiter := range limiters {
+		for _, tc := range testCases {
+			t.Run(limiterName+" "+tc.name, func(t *testing.T) {
+
+				limiter, advance := newLimiter(t, tc.algorithm, tc.limit)
+
+				for i, s := range tc.steps {
+
+					advance(s.advance)
+
+					result, err := limiter.AllowN(context.Background(), "user-1", s.n)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if result.Allowed != s.allowed || result.Remaining != s.remaining || result.RetryAfter != s.retryAfter {
+						t.Fatalf("step %d is %+v, want %+v", i, result, s)
+					}
+				}
+
+				// the other key has its own limit
+				result, err := limiter.Allow(context.Background(), "user-2")
+				if err != nil || !result.Allowed {
+					t.Fatalf("other key is %+v %v", result, err)
+				}
+			})
+		}
+	}
+}
+
+func TestLimitValidate(t *testing.T) {
+
+	testCases := []struct {
+		name  string
+		limit Limit
+		valid bool
+	}{
+		{name: "per second", limit: PerSecond(10), valid: true},
+		{name: "one millisecond", limit: Limit{Rate: 1, Period: time.Millisecond}, valid: true},
+		{name: "zero rate", limit: PerSecond(0)},
+		{name: "negative rate", limit: PerMinute(-1)},
+		{name: "zero period", limit: Limit{Rate: 1}},
+		{name: "period less than millisecond", limit: Limit{Rate: 1, Period: 500 * time.Microsecond}},
+		{name: "negative burst", limit: PerSecond(1).SetBurst(-1)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			if err := tc.limit.Validate(); (err == nil) != tc.valid {
+				t.Fatalf("validate return %v", err)
+			}
+
+			for _, newLimiter := range []func(){
+				func() { NewMemoryLimiter(TokenBucket, tc.limit) },
+				func() { NewRedisLimiter(nil, TokenBucket, tc.limit) },
+			} {
+				if panicked := didPanic(newLimiter); panicked == tc.valid {
+					t.Fatalf("constructor panic is %v", panicked)
+				}
+			}
+		})
+	}
+}
+
+func didPanic(f func()) (panicked bool) {
+	defer func() {
+		panicked = recover() != nil
+	}()
+	f()
+	return false
+}
@@ -0,0 +1,171 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+type memoryState struct {
+
+	// count and resetAt is used by FixedWindow
+	count   int64
+	resetAt time.Time
+
+	// times is used by SlidingWindow
+	times []time.Time
+
+	// tokens and updatedAt is used by TokenBucket
+	tokens    float64
+	updatedAt time.Time
+
+	lastSeen time.Time
+}
+
+type memoryLimiter struct {
+	algorithm Algorithm
+	limit     Limit
+	option    LimiterOption
+
+	mutex     sync.Mutex
+	states    map[string]*memoryState
+	lastSweep time.Time
+
+	// now is replaced by the test
+	now func() time.Time
+}
+
+// NewMemoryLimiter create the Limiter for the single instance and the test. It behave the same as NewRedisLimiter.
+// It panics when the limit is invalid
+func NewMemoryLimiter(algorithm Algorithm, limit Limit, option ...LimiterOption) Limiter {
+
+	if err := limit.Validate(); err != nil {
+		panic(err.Error())
+	}
+
+	return &memoryLimiter{
+		algorithm: algorithm,
+		limit:     limit,
+		option:    getLimiterOption(option),
+		states:    map[string]*memoryState{},
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+func (r *memoryLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return r.AllowN(ctx, key, 1)
+}
+
+func (r *memoryLimiter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := r.now()
+	r.sweep(now)
+
+	state, exist := r.states[r.option.Prefix+key]
+	if !exist {
+		state = &memoryState{tokens: float64(r.limit.burst()), updatedAt: now}
+		r.states[r.option.Prefix+key] = state
+	}
+	state.lastSeen = now
+
+	switch r.algorithm {
+	case FixedWindow:
+		return r.fixedWindow(state, now, n), nil
+	case SlidingWindow:
+		return r.slidingWindow(state, now, n), nil
+	case TokenBucket:
+		return r.tokenBucket(state, now, n), nil
+	}
+
+	return Result{}, fmt.Errorf("unknown rate limit algorithm %d", r.algorithm)
+}
+
+func (r *memoryLimiter) fixedWindow(state *memoryState, now time.Time, n int64) Result {
+
+	if !now.Before(state.resetAt) {
+		state.count = 0
+		state.resetAt = now.Add(r.limit.Period)
+	}
+
+	if state.count+n > r.limit.Rate {
+		return Result{Limit: r.limit.Rate, Remaining: r.limit.Rate - state.count, RetryAfter: state.resetAt.Sub(now)}
+	}
+
+	state.count += n
+
+	return Result{Allowed: true, Limit: r.limit.Rate, Remaining: r.limit.Rate - state.count}
+}
+
+func (r *memoryLimiter) slidingWindow(state *memoryState, now time.Time, n int64) Result {
+
+	start := now.Add(-r.limit.Period)
+
+	i := 0
+	for i < len(state.times) && !state.times[i].After(start) {
+		i++
+	}
+	state.times = state.times[i:]
+
+	count := int64(len(state.times))
+
+	if count+n <= r.limit.Rate {
+		for j := int64(0); j < n; j++ {
+			state.times = append(state.times, now)
+		}
+		return Result{Allowed: true, Limit: r.limit.Rate, Remaining: r.limit.Rate - count - n}
+	}
+
+	retry := r.limit.Period
+	if n <= r.limit.Rate {
+		retry = state.times[count+n-r.limit.Rate-1].Add(r.limit.Period).Sub(now)
+	}
+
+	return Result{Limit: r.limit.Rate, Remaining: r.limit.Rate - count, RetryAfter: retry}
+}
+
+func (r *memoryLimiter) tokenBucket(state *memoryState, now time.Time, n int64) Result {
+
+	capacity := float64(r.limit.burst())
+	ratePerNano := float64(r.limit.Rate) / float64(r.limit.Period)
+
+	state.tokens = math.Min(capacity, state.tokens+float64(now.Sub(state.updatedAt))*ratePerNano)
+	state.updatedAt = now
+
+	if state.tokens >= float64(n) {
+		state.tokens -= float64(n)
+		return Result{Allowed: true, Limit: r.limit.burst(), Remaining: int64(state.tokens)}
+	}
+
+	retry := time.Duration(math.Ceil((float64(n) - state.tokens) / ratePerNano))
+
+	return Result{Limit: r.limit.burst(), Remaining: int64(state.tokens), RetryAfter: retry}
+}
+
+// sweep remove the idle key once every period so the map does not grow forever. The caller must hold the mutex
+func (r *memoryLimiter) sweep(now time.Time) {
+
+	if now.Sub(r.lastSweep) < r.limit.Period {
+		return
+	}
+	r.lastSweep = now
+
+	// the key is back to the initial state after the period or after the bucket is full again
+	idleAfter := r.limit.Period
+	if r.algorithm == TokenBucket && r.limit.Rate > 0 {
+		refill := r.limit.Period * time.Duration(r.limit.burst()) / time.Duration(r.limit.Rate)
+		if refill > idleAfter {
+			idleAfter = refill
+		}
+	}
+
+	for key, state := range r.states {
+		if now.Sub(state.lastSeen) >= idleAfter {
+			delete(r.states, key)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// KeyFunc return the rate limit key of the request, the empty key is not limited
+type KeyFunc func(r *http.Request) string
+
+// KeyByIP use the address of the connection. Behind the proxy use KeyByForwardedIP instead
+func KeyByIP(r *http.Request) string {
+	return "ip:" + remoteIP(r)
+}
+
+// KeyByForwardedIP use the client ip in X-Forwarded-For written by the trusted proxies in front of the service.
+// Every proxy append the address it receives from, so the client is the entry at trustedProxies from the right.
+// The entries on the left of it are set by the client and never used.
+// The request with less entries than trustedProxies does not come through the proxies and use the connection address
+func KeyByForwardedIP(trustedProxies int) KeyFunc {
+	return func(r *http.Request) string {
+
+		if trustedProxies <= 0 {
+			return KeyByIP(r)
+		}
+
+		var entries []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			for _, entry := range strings.Split(header, ",") {
+				entries = append(entries, strings.TrimSpace(entry))
+			}
+		}
+
+		if len(entries) < trustedProxies {
+			return KeyByIP(r)
+		}
+
+		client := entries[len(entries)-trustedProxies]
+		if client == "" {
+			return KeyByIP(r)
+		}
+
+		return "ip:" + client
+	}
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// KeyByHeader use the header value like the api key
+func KeyByHeader(name string) KeyFunc {
+	return func(r *http.Request) string {
+		value := r.Header.Get(name)
+		if value == "" {
+			return ""
+		}
+		return name + ":" + value
+	}
+}
+
+// Middleware reject the request with 429 when the limit is reached and put the X-RateLimit-* header.
+// The request is allowed when the limiter itself is failed so the redis problem does not stop the service
+//
+//	limiter := ratelimit.NewRedisLimiter(client, ratelimit.SlidingWindow, ratelimit.PerMinute(100))
+//	handler = ratelimit.Middleware(limiter, ratelimit.KeyByHeader("X-API-Key"))(handler)
+func Middleware(limiter Limiter, keyFunc KeyFunc) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			key := keyFunc(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			result, err := limiter.Allow(r.Context(), key)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(result.Limit, 10))
+			w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+
+			if !result.Allowed {
+				retryAfter := int64(math.Ceil(result.RetryAfter.Seconds()))
+				w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
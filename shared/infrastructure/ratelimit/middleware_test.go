@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKeyByForwardedIP(t *testing.T) {
+
+	testCases := []struct {
+		name           string
+		trustedProxies int
+		headers        []string
+		want           string
+	}{
+		{name: "no proxy use the connection", trustedProxies: 0, headers: []string{"1.1.1.1"}, want: "ip:10.0.0.1"},
+		{name: "one proxy", trustedProxies: 1, headers: []string{"1.1.1.1"}, want: "ip:1.1.1.1"},
+		{name: "spoofed entry on the left is ignored", trustedProxies: 1, headers: []string{"6.6.6.6, 1.1.1.1"}, want: "ip:1.1.1.1"},
+		{name: "two proxies", trustedProxies: 2, headers: []string{"6.6.6.6, 1.1.1.1, 172.16.0.1"}, want: "ip:1.1.1.1"},
+		{name: "entries in many headers", trustedProxies: 2, headers: []string{"6.6.6.6, 1.1.1.1", "172.16.0.1"}, want: "ip:1.1.1.1"},
+		{name: "less entries than proxies", trustedProxies: 2, headers: []string{"1.1.1.1"}, want: "ip:10.0.0.1"},
+		{name: "no header", trustedProxies: 1, want: "ip:10.0.0.1"},
+		{name: "empty entry", trustedProxies: 1, headers: []string{"1.1.1.1, "}, want: "ip:10.0.0.1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = "10.0.0.1:5000"
+			for _, header := range tc.headers {
+				r.Header.Add("X-Forwarded-For", header)
+			}
+
+			if key := KeyByForwardedIP(tc.trustedProxies)(r); key != tc.want {
+				t.Fatalf("key is %s, want %s", key, tc.want)
+			}
+		})
+	}
+}
+
+// failedLimiter is the limiter whose store is down
+type failedLimiter struct{}
+
+func (failedLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return Result{}, errors.New("redis is down")
+}
+
+func (failedLimiter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	return Result{}, errors.New("redis is down")
+}
+
+func TestMiddleware(t *testing.T) {
+
+	limiter, _ := newTestMemoryLimiter(t, FixedWindow, PerMinute(1))
+
+	handler := Middleware(limiter, KeyByHeader("X-API-Key"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	serve := func(apiKey string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if apiKey != "" {
+			r.Header.Set("X-API-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve("K1"); w.Code != http.StatusNoContent || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("first request is %d remaining %s", w.Code, w.Header().Get("X-RateLimit-Remaining"))
+	}
+
+	if w := serve("K1"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Fatalf("second request is %d retry after %s", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// the request without the key is not limited
+	for i := 0; i < 2; i++ {
+		if w := serve(""); w.Code != http.StatusNoContent {
+			t.Fatalf("request without the key is %d", w.Code)
+		}
+	}
+
+	// the failed limiter does not stop the service
+	handler = Middleware(failedLimiter{}, KeyByHeader("X-API-Key"))(handler)
+	if w := serve("K2"); w.Code != http.StatusNoContent {
+		t.Fatalf("request with the failed limiter is %d", w.Code)
+	}
+}
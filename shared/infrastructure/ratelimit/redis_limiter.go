@@ -0,0 +1,148 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"infrastructure/shared/util"
+)
+
+// all the script return {allowed, remaining, retry after in millisecond}.
+// The sliding window and the token bucket use the redis TIME so the clock of the instances does not matter
+
+var fixedWindowScript = redis.NewScript(`
+local n = tonumber(ARGV[1])
+local limit = tonumber(ARGV[3])
+local current = redis.call("INCRBY", KEYS[1], n)
+if redis.call("PTTL", KEYS[1]) < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if current > limit then
+	current = redis.call("DECRBY", KEYS[1], n)
+	local ttl = redis.call("PTTL", KEYS[1])
+	if ttl < 0 then
+		ttl = tonumber(ARGV[2])
+	end
+	return {0, limit - current, ttl}
+end
+return {1, limit - current, 0}
+`)
+
+var slidingWindowScript = redis.NewScript(`
+local n = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+if count + n <= limit then
+	for i = 1, n do
+		redis.call("ZADD", KEYS[1], now, ARGV[4] .. ":" .. i)
+	end
+	redis.call("PEXPIRE", KEYS[1], window)
+	return {1, limit - count - n, 0}
+end
+local retry = window
+if n <= limit then
+	local oldest = redis.call("ZRANGE", KEYS[1], count + n - limit - 1, count + n - limit - 1, "WITHSCORES")
+	if oldest[2] then
+		retry = tonumber(oldest[2]) + window - now
+	end
+end
+return {0, limit - count, retry}
+`)
+
+var tokenBucketScript = redis.NewScript(`
+local n = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local ratePerMS = tonumber(ARGV[3])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local data = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(data[1]) or capacity
+local ts = tonumber(data[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * ratePerMS)
+local allowed = 0
+local retry = 0
+if tokens >= n then
+	tokens = tokens - n
+	allowed = 1
+else
+	retry = math.ceil((n - tokens) / ratePerMS)
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(capacity / ratePerMS) + 1000)
+return {allowed, math.floor(tokens), retry}
+`)
+
+type redisLimiter struct {
+	client    redis.UniversalClient
+	algorithm Algorithm
+	limit     Limit
+	option    LimiterOption
+}
+
+// NewRedisLimiter create the Limiter which is shared by all the instance using the same redis.
+// Every check is one lua script so it is atomic. It panics when the limit is invalid
+func NewRedisLimiter(client redis.UniversalClient, algorithm Algorithm, limit Limit, option ...LimiterOption) Limiter {
+
+	if err := limit.Validate(); err != nil {
+		panic(err.Error())
+	}
+
+	return &redisLimiter{
+		client:    client,
+		algorithm: algorithm,
+		limit:     limit,
+		option:    getLimiterOption(option),
+	}
+}
+
+func (r *redisLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return r.AllowN(ctx, key, 1)
+}
+
+func (r *redisLimiter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+
+	fullKey := r.option.Prefix + key
+	period := r.limit.Period.Milliseconds()
+
+	var cmd *redis.Cmd
+
+	switch r.algorithm {
+	case FixedWindow:
+		cmd = fixedWindowScript.Run(ctx, r.client, []string{fullKey}, n, period, r.limit.Rate)
+	case SlidingWindow:
+		cmd = slidingWindowScript.Run(ctx, r.client, []string{fullKey}, n, period, r.limit.Rate, util.GenerateID(12))
+	case TokenBucket:
+		ratePerMS := float64(r.limit.Rate) / float64(period)
+		cmd = tokenBucketScript.Run(ctx, r.client, []string{fullKey}, n, r.limit.burst(), strconv.FormatFloat(ratePerMS, 'f', -1, 64))
+	default:
+		return Result{}, fmt.Errorf("unknown rate limit algorithm %d", r.algorithm)
+	}
+
+	values, err := cmd.Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	if len(values) != 3 {
+		return Result{}, fmt.Errorf("unexpected rate limit script result %v", values)
+	}
+
+	limit := r.limit.Rate
+	if r.algorithm == TokenBucket {
+		limit = r.limit.burst()
+	}
+
+	return Result{
+		Allowed:    values[0] == 1,
+		Limit:      limit,
+		Remaining:  values[1],
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,80 @@
+package saga
+
+import (
+	"context"
+	"infrastructure/shared/model/payload"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusRunning      Status = "RUNNING"
+	StatusCompleted    Status = "COMPLETED"
+	StatusCompensating Status = "COMPENSATING"
+	StatusCompensated  Status = "COMPENSATED"
+
+	// StatusFailed means the compensation is failed and need the manual handling
+	StatusFailed Status = "FAILED"
+)
+
+// SagaState is the persisted progress of one saga
+type SagaState struct {
+	ID          string         `bson:"_id" json:"id"`
+	Name        string         `bson:"name" json:"name"`
+	Status      Status         `bson:"status" json:"status"`
+	CurrentStep int            `bson:"current_step" json:"currentStep"`
+	Data        map[string]any `bson:"data" json:"data"`
+	Error       string         `bson:"error" json:"error"`
+	UpdatedAt   time.Time      `bson:"updated_at" json:"updatedAt"`
+
+	// Version is increased on every save. The save of the state which is read before the last save is rejected with ErrConcurrentUpdate
+	Version int64 `bson:"version" json:"version"`
+}
+
+// StepFunc run the action or the compensation of the step. The change on state.Data is persisted after it return.
+// The success event which is handled before the Action return (like with the synchronous InProcessBroker) see the data before the Action,
+// so the value needed by the later step should also come from the event through OnSuccess
+type StepFunc func(ctx context.Context, state *SagaState) error
+
+// Step is one local transaction of the saga
+type Step struct {
+	Name string
+
+	// Action start the step, usually publish the command to the other service
+	Action StepFunc
+
+	// Compensate (optional) undo the Action when the later step is failed. It must be idempotent
+	Compensate StepFunc
+
+	// SuccessTopic (optional) is the topic of the event that complete the step.
+	// Without it the step is completed as soon as the Action return
+	SuccessTopic string
+
+	// OnSuccess (optional) copy the result from the success event into the state
+	OnSuccess func(ctx context.Context, state *SagaState, event payload.Payload) error
+
+	// FailureTopic (optional) is the topic of the event that fail the step
+	FailureTopic string
+
+	// Timeout (optional) fail the step if the success event is not received in time
+	Timeout time.Duration
+}
+
+// Definition declare the saga
+//
+//	orderSaga := saga.Definition{
+//		Name: "order",
+//		Correlate: func(event payload.Payload) string { return event.Data.(map[string]any)["orderID"].(string) },
+//		Steps: []saga.Step{
+//			{Name: "payment", Action: requestPayment, Compensate: refundPayment, SuccessTopic: "payment.paid", FailureTopic: "payment.rejected", Timeout: time.Minute},
+//			{Name: "shipping", Action: requestShipping, SuccessTopic: "shipping.scheduled", FailureTopic: "shipping.rejected"},
+//		},
+//	}
+type Definition struct {
+	Name  string
+	Steps []Step
+
+	// Correlate return the saga id from the step event
+	Correlate func(event payload.Payload) string
+}
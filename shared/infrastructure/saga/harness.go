@@ -0,0 +1,201 @@
+package saga
+
+import (
+	"context"
+	"fmt"
+	"infrastructure/shared/infrastructure/database"
+	"infrastructure/shared/infrastructure/logger"
+	"infrastructure/shared/infrastructure/messaging"
+	"sync"
+)
+
+// ErrSimulatedFailure is returned by the step Action which is set to fail by the Harness
+var ErrSimulatedFailure = fmt.Errorf("simulated failure")
+
+// Harness run the saga with the in-process broker and the in-memory state so it can be tested without any infrastructure.
+// Register the fake of the other services to the Broker, then check that every failure ends with the expected status
+//
+//	h := saga.NewHarness(orderSaga, log)
+//	h.Broker.Handle("payment.requested", fakePaymentService)
+//	results, err := h.SimulateFailures(ctx, map[string]any{"orderID": "O1"})
+//	// every results[stepName].Status must be saga.StatusCompensated
+type Harness struct {
+	Broker  *messaging.InProcessBroker
+	Manager *Manager
+
+	definition Definition
+	mutex      sync.Mutex
+	failAt     string
+}
+
+func NewHarness(def Definition, log logger.Logger) *Harness {
+
+	h := &Harness{
+		Broker: messaging.NewInProcessBroker(),
+	}
+
+	// every action can be switched to fail by FailAt
+	steps := make([]Step, len(def.Steps))
+	for i, step := range def.Steps {
+		action := step.Action
+		stepName := step.Name
+		step.Action = func(ctx context.Context, state *SagaState) error {
+			if h.shouldFail(stepName) {
+				return ErrSimulatedFailure
+			}
+			if action == nil {
+				return nil
+			}
+			return action(ctx, state)
+		}
+		steps[i] = step
+	}
+	def.Steps = steps
+
+	h.definition = def
+	h.Manager = NewManager(newMemoryRepository(), h.Broker, log)
+	h.Manager.Register(def)
+	h.Manager.Subscribe(h.Broker)
+
+	return h
+}
+
+// FailAt make the Action of the step return ErrSimulatedFailure. Empty string disable the failure
+func (h *Harness) FailAt(stepName string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.failAt = stepName
+}
+
+func (h *Harness) shouldFail(stepName string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.failAt == stepName
+}
+
+// SimulateFailures start one saga for every step with the failure at that step
+// and return the state after the start by the step name. The saga id is the step name
+func (h *Harness) SimulateFailures(ctx context.Context, data map[string]any) (map[string]*SagaState, error) {
+
+	defer h.FailAt("")
+
+	results := map[string]*SagaState{}
+
+	for _, step := range h.definition.Steps {
+
+		h.FailAt(step.Name)
+
+		obj := map[string]any{}
+		for k, v := range data {
+			obj[k] = v
+		}
+
+		// the saga of the previous run is replaced
+		err := h.Manager.repo.Delete(map[string]any{"_id": step.Name})
+		if err != nil {
+			return nil, err
+		}
+
+		state, err := h.Manager.Start(ctx, h.definition.Name, step.Name, obj)
+		if err != nil {
+			return nil, err
+		}
+
+		results[step.Name] = state
+	}
+
+	return results, nil
+}
+
+// memoryRepository keep the saga state in memory, only the "_id" filter is supported
+type memoryRepository struct {
+	mutex  sync.RWMutex
+	states map[string]SagaState
+}
+
+func newMemoryRepository() StateRepository {
+	return &memoryRepository{
+		states: map[string]SagaState{},
+	}
+}
+
+func copyState(state SagaState) SagaState {
+	data := map[string]any{}
+	for k, v := range state.Data {
+		data[k] = v
+	}
+	state.Data = data
+	return state
+}
+
+func (r *memoryRepository) InsertOrUpdate(obj *SagaState) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.states[obj.ID] = copyState(*obj)
+	return nil
+}
+
+// UpdateIf only support the "version" filter
+func (r *memoryRepository) UpdateIf(filter map[string]any, obj *SagaState) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if current, exist := r.states[obj.ID]; exist && current.Version != filter["version"] {
+		return false, nil
+	}
+
+	r.states[obj.ID] = copyState(*obj)
+	return true, nil
+}
+
+func (r *memoryRepository) InsertMany(objs ...*SagaState) error {
+	for _, obj := range objs {
+		_ = r.InsertOrUpdate(obj)
+	}
+	return nil
+}
+
+func (r *memoryRepository) GetOne(filter map[string]any, result *SagaState) error {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	state, exist := r.states[fmt.Sprintf("%v", filter["_id"])]
+	if !exist {
+		return fmt.Errorf("saga state %v is not found", filter["_id"])
+	}
+
+	*result = copyState(state)
+	return nil
+}
+
+func (r *memoryRepository) GetAll(param database.GetAllParam, results *[]*SagaState) (int64, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, state := range r.states {
+		obj := copyState(state)
+		*results = append(*results, &obj)
+	}
+	return int64(len(r.states)), nil
+}
+
+func (r *memoryRepository) GetAllEachItem(param database.GetAllParam, resultEachItem func(result SagaState)) (int64, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, state := range r.states {
+		resultEachItem(copyState(state))
+	}
+	return int64(len(r.states)), nil
+}
+
+func (r *memoryRepository) Delete(filter map[string]any) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.states, fmt.Sprintf("%v", filter["_id"]))
+	return nil
+}
+
+func (r *memoryRepository) GetTypeName() string {
+	return "saga_state"
+}
@@ -0,0 +1,175 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"infrastructure/shared/infrastructure/logger"
+	"infrastructure/shared/infrastructure/messaging"
+	"infrastructure/shared/model/payload"
+	"sync"
+	"testing"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(ctx context.Context, message string, args ...any) {}
+func (nopLogger) Info(ctx context.Context, message string, args ...any)  {}
+func (nopLogger) Warn(ctx context.Context, message string, args ...any)  {}
+func (nopLogger) Error(ctx context.Context, message string, args ...any) {}
+func (nopLogger) Fatal(ctx context.Context, message string, args ...any) {}
+func (l nopLogger) With(keyValues ...any) logger.Logger                  { return l }
+
+// orderSaga request the payment then the shipping, the refund is counted to check the compensation
+type orderSaga struct {
+	mutex   sync.Mutex
+	refunds int
+	broker  func() messaging.Publisher
+}
+
+func (o *orderSaga) definition() Definition {
+	return Definition{
+		Name: "order",
+		Correlate: func(event payload.Payload) string {
+			obj, _ := event.Data.(map[string]any)
+			id, _ := obj["orderID"].(string)
+			return id
+		},
+		Steps: []Step{
+			{
+				Name: "payment",
+				Action: func(ctx context.Context, state *SagaState) error {
+					state.Data["paymentRequested"] = true
+					return o.broker().Publish(ctx, "payment.requested", 0, payload.Payload{Data: map[string]any{"orderID": state.ID}})
+				},
+				Compensate: func(ctx context.Context, state *SagaState) error {
+					o.mutex.Lock()
+					defer o.mutex.Unlock()
+					o.refunds++
+					return nil
+				},
+				SuccessTopic: "payment.paid",
+				OnSuccess: func(ctx context.Context, state *SagaState, event payload.Payload) error {
+					state.Data["paid"] = true
+					return nil
+				},
+				FailureTopic: "payment.rejected",
+			},
+			{
+				Name: "shipping",
+			},
+		},
+	}
+}
+
+func (o *orderSaga) refundCount() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.refunds
+}
+
+func newOrderHarness(t *testing.T, paymentTopic string) (*Harness, *orderSaga) {
+
+	o := &orderSaga{}
+	h := NewHarness(o.definition(), nopLogger{})
+	o.broker = func() messaging.Publisher { return h.Broker }
+
+	// the fake payment service answer the request with the paymentTopic
+	h.Broker.Handle("payment.requested", func(ctx context.Context, data payload.Payload, err error) error {
+		if err != nil {
+			return err
+		}
+		return h.Broker.Publish(ctx, paymentTopic, 0, payload.Payload{Data: data.Data})
+	})
+
+	return h, o
+}
+
+func TestHarnessSuccess(t *testing.T) {
+
+	h, o := newOrderHarness(t, "payment.paid")
+
+	state, err := h.Manager.Start(context.Background(), "order", "O1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state.Status != StatusCompleted || state.CurrentStep != 2 {
+		t.Fatalf("saga is %s at step %d", state.Status, state.CurrentStep)
+	}
+
+	if state.Data["paid"] != true {
+		t.Fatalf("data of OnSuccess is not kept %v", state.Data)
+	}
+
+	if o.refundCount() != 0 {
+		t.Fatalf("completed saga is compensated %d times", o.refundCount())
+	}
+}
+
+func TestHarnessFailureEventCompensate(t *testing.T) {
+
+	h, o := newOrderHarness(t, "payment.rejected")
+
+	state, err := h.Manager.Start(context.Background(), "order", "O1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the rejected payment is not refunded, only the completed step before it
+	if state.Status != StatusCompensated || o.refundCount() != 0 {
+		t.Fatalf("saga is %s with %d refunds", state.Status, o.refundCount())
+	}
+}
+
+func TestHarnessSimulateFailures(t *testing.T) {
+
+	h, o := newOrderHarness(t, "payment.paid")
+
+	results, err := h.SimulateFailures(context.Background(), map[string]any{"customer": "C1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"payment", "shipping"} {
+		if results[name].Status != StatusCompensated {
+			t.Fatalf("failure at %s end with %s", name, results[name].Status)
+		}
+	}
+
+	// only the failure at the shipping need the refund of the payment
+	if o.refundCount() != 1 {
+		t.Fatalf("payment is refunded %d times, want 1", o.refundCount())
+	}
+}
+
+func TestConcurrentUpdateIsRejected(t *testing.T) {
+
+	h, _ := newOrderHarness(t, "payment.rejected")
+
+	_, err := h.Manager.Start(context.Background(), "order", "O1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := h.Manager.Get("O1")
+	second, _ := h.Manager.Get("O1")
+
+	if err := h.Manager.save(first); err != nil {
+		t.Fatal(err)
+	}
+
+	err = h.Manager.save(second)
+	if !errors.Is(err, ErrConcurrentUpdate) || !errors.Is(err, messaging.ErrRequeue) {
+		t.Fatalf("save of the stale state return %v", err)
+	}
+
+	if second.Version != first.Version-1 {
+		t.Fatalf("version of the rejected state is changed to %d", second.Version)
+	}
+
+	// the saga with the same id can not be started again
+	_, err = h.Manager.Start(context.Background(), "order", "O1", nil)
+	if !errors.Is(err, ErrConcurrentUpdate) {
+		t.Fatalf("start of the existing saga return %v", err)
+	}
+}
@@ -0,0 +1,377 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"infrastructure/shared/infrastructure/database"
+	"infrastructure/shared/infrastructure/logger"
+	"infrastructure/shared/infrastructure/messaging"
+	"infrastructure/shared/model/payload"
+	"reflect"
+	"time"
+)
+
+// TimeoutTopic is the topic of the delayed message that check the step timeout
+const TimeoutTopic = "saga.timeout"
+
+// ErrConcurrentUpdate is returned when the saga state is changed by the other handler after it is read.
+// It wrap messaging.ErrRequeue so the broker deliver the event again and it is handled with the latest state
+var ErrConcurrentUpdate = fmt.Errorf("saga state is updated concurrently: %w", messaging.ErrRequeue)
+
+type timeoutEvent struct {
+	SagaID string `json:"sagaID"`
+	Step   int    `json:"step"`
+}
+
+// StateRepository store the saga state, UpdateIf is used to write the state only when the stored Version is not changed
+type StateRepository interface {
+	database.Repository[SagaState]
+	database.UpdateIfRepo[SagaState]
+}
+
+// Manager run the saga. Every instance of the service can run the Manager.
+// Inside one instance the event of the same saga is handled in order because the subscriber is partitioned by the saga id.
+// Across the instances the state is protected by the Version: the save is the conditional update on the Version
+// so the save of the stale state fail with ErrConcurrentUpdate and the event is redelivered
+//
+//	manager := saga.NewManager(database.NewMongoGateway[saga.SagaState](db), publisher, log)
+type Manager struct {
+	repo        StateRepository
+	publisher   messaging.Publisher
+	log         logger.Logger
+	definitions map[string]Definition
+	topicMap    map[string][]string
+}
+
+func NewManager(repo StateRepository, publisher messaging.Publisher, log logger.Logger) *Manager {
+	return &Manager{
+		repo:        repo,
+		publisher:   publisher,
+		log:         log,
+		definitions: map[string]Definition{},
+		topicMap:    map[string][]string{},
+	}
+}
+
+// Register add the saga definition. It must be called before Subscribe
+func (m *Manager) Register(def Definition) {
+
+	m.definitions[def.Name] = def
+
+	for _, step := range def.Steps {
+		for _, topic := range []string{step.SuccessTopic, step.FailureTopic} {
+			if topic != "" {
+				m.topicMap[topic] = append(m.topicMap[topic], def.Name)
+			}
+		}
+	}
+}
+
+// Subscribe register the handler of every step event and the timeout
+func (m *Manager) Subscribe(subscriber messaging.Subscriber) {
+
+	for topic, names := range m.topicMap {
+		topic, names := topic, names
+		opt := messaging.NewDefaultHandleOption().SetPartitionKey(func(data payload.Payload) string {
+			for _, name := range names {
+				if id := m.definitions[name].Correlate(data); id != "" {
+					return id
+				}
+			}
+			return ""
+		})
+		subscriber.Handle(topic, m.onStepEvent(topic, names), opt)
+	}
+
+	opt := messaging.NewDefaultHandleOption().SetPartitionKey(func(data payload.Payload) string {
+		var event timeoutEvent
+		_ = data.DecodeData(&event)
+		return event.SagaID
+	})
+	subscriber.Handle(TimeoutTopic, m.onTimeout, opt)
+}
+
+// Start create the new saga and run the steps until it is waiting for the event
+func (m *Manager) Start(ctx context.Context, name, id string, data map[string]any) (*SagaState, error) {
+
+	def, exist := m.definitions[name]
+	if !exist {
+		return nil, fmt.Errorf("saga %s is not registered", name)
+	}
+
+	if data == nil {
+		data = map[string]any{}
+	}
+
+	state := &SagaState{
+		ID:          id,
+		Name:        name,
+		Status:      StatusRunning,
+		CurrentStep: 0,
+		Data:        data,
+	}
+
+	err := m.runSteps(ctx, def, state)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Get(id)
+}
+
+// Get return the current state of the saga
+func (m *Manager) Get(id string) (*SagaState, error) {
+	var state SagaState
+	err := m.repo.GetOne(map[string]any{"_id": id}, &state)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// save write the state if nobody has saved it since it is read, the new saga must not exist yet
+func (m *Manager) save(state *SagaState) error {
+
+	version := state.Version
+
+	state.Version++
+	state.UpdatedAt = time.Now()
+
+	ok, err := m.repo.UpdateIf(map[string]any{"version": version}, state)
+	if err != nil || !ok {
+		state.Version = version
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrConcurrentUpdate
+	}
+
+	return nil
+}
+
+// saveActionData persist the data changed by the Action of the step waiting for the event.
+// The success event may be already handled while the Action is running, then the change is put on the latest state
+func (m *Manager) saveActionData(state *SagaState, before map[string]any) error {
+
+	changed := map[string]any{}
+	for k, v := range state.Data {
+		if old, exist := before[k]; !exist || !reflect.DeepEqual(old, v) {
+			changed[k] = v
+		}
+	}
+
+	removed := make([]string, 0)
+	for k := range before {
+		if _, exist := state.Data[k]; !exist {
+			removed = append(removed, k)
+		}
+	}
+
+	if len(changed) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	for attempt := 0; ; attempt++ {
+
+		err := m.save(state)
+		if !errors.Is(err, ErrConcurrentUpdate) || attempt == 3 {
+			return err
+		}
+
+		latest, err := m.Get(state.ID)
+		if err != nil {
+			return err
+		}
+
+		if latest.Data == nil {
+			latest.Data = map[string]any{}
+		}
+		for k, v := range changed {
+			latest.Data[k] = v
+		}
+		for _, k := range removed {
+			delete(latest.Data, k)
+		}
+
+		state = latest
+	}
+}
+
+// runSteps run the step from the CurrentStep. The state is saved before the Action
+// since the success event may arrive before the Action return, and saved again after the Action to keep its data
+func (m *Manager) runSteps(ctx context.Context, def Definition, state *SagaState) error {
+
+	for state.CurrentStep < len(def.Steps) {
+
+		step := def.Steps[state.CurrentStep]
+
+		err := m.save(state)
+		if err != nil {
+			return err
+		}
+
+		if step.SuccessTopic != "" && step.Timeout > 0 {
+			err := m.publisher.Publish(ctx, TimeoutTopic, int(step.Timeout.Milliseconds()), payload.Payload{
+				Data: timeoutEvent{SagaID: state.ID, Step: state.CurrentStep},
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		m.log.Info(ctx, "saga %s %s run step %s", state.Name, state.ID, step.Name)
+
+		before := copyData(state.Data)
+
+		if step.Action != nil {
+			err := step.Action(ctx, state)
+			if err != nil {
+				return m.compensate(ctx, def, state, fmt.Errorf("step %s action: %v", step.Name, err), false)
+			}
+		}
+
+		// the next step is triggered by the event
+		if step.SuccessTopic != "" {
+			return m.saveActionData(state, before)
+		}
+
+		state.CurrentStep++
+	}
+
+	state.Status = StatusCompleted
+
+	m.log.Info(ctx, "saga %s %s completed", state.Name, state.ID)
+
+	return m.save(state)
+}
+
+// compensate run the compensation in the reverse order.
+// The current step is compensated only if its result is unknown (timeout or failed OnSuccess)
+func (m *Manager) compensate(ctx context.Context, def Definition, state *SagaState, cause error, includeCurrentStep bool) error {
+
+	m.log.Error(ctx, "saga %s %s compensating %v", state.Name, state.ID, cause.Error())
+
+	state.Status = StatusCompensating
+	state.Error = cause.Error()
+
+	err := m.save(state)
+	if err != nil {
+		return err
+	}
+
+	last := state.CurrentStep - 1
+	if includeCurrentStep {
+		last = state.CurrentStep
+	}
+
+	for i := last; i >= 0; i-- {
+
+		step := def.Steps[i]
+		if step.Compensate == nil {
+			continue
+		}
+
+		err := step.Compensate(ctx, state)
+		if err != nil {
+			state.Status = StatusFailed
+			state.Error = fmt.Sprintf("%s; compensate step %s: %v", state.Error, step.Name, err)
+			m.log.Error(ctx, "saga %s %s failed %v", state.Name, state.ID, state.Error)
+			return m.save(state)
+		}
+	}
+
+	state.Status = StatusCompensated
+
+	return m.save(state)
+}
+
+func copyData(data map[string]any) map[string]any {
+	result := make(map[string]any, len(data))
+	for k, v := range data {
+		result[k] = v
+	}
+	return result
+}
+
+func (m *Manager) onStepEvent(topic string, names []string) messaging.HandleFunc {
+	return func(ctx context.Context, data payload.Payload, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+
+			def := m.definitions[name]
+
+			id := def.Correlate(data)
+			if id == "" {
+				continue
+			}
+
+			state, err := m.Get(id)
+			if err != nil {
+				return err
+			}
+
+			// the late or duplicate event is ignored
+			if state.Name != def.Name || state.Status != StatusRunning || state.CurrentStep >= len(def.Steps) {
+				continue
+			}
+
+			step := def.Steps[state.CurrentStep]
+
+			switch topic {
+
+			case step.SuccessTopic:
+				if step.OnSuccess != nil {
+					err := step.OnSuccess(ctx, state, data)
+					if err != nil {
+						return m.compensate(ctx, def, state, fmt.Errorf("step %s on success: %v", step.Name, err), true)
+					}
+				}
+				state.CurrentStep++
+				return m.runSteps(ctx, def, state)
+
+			case step.FailureTopic:
+				return m.compensate(ctx, def, state, fmt.Errorf("step %s failed", step.Name), false)
+
+			}
+		}
+
+		return nil
+	}
+}
+
+func (m *Manager) onTimeout(ctx context.Context, data payload.Payload, err error) error {
+
+	if err != nil {
+		return err
+	}
+
+	var event timeoutEvent
+	err = data.DecodeData(&event)
+	if err != nil {
+		return err
+	}
+
+	state, err := m.Get(event.SagaID)
+	if err != nil {
+		return err
+	}
+
+	def, exist := m.definitions[state.Name]
+	if !exist {
+		return fmt.Errorf("saga %s is not registered", state.Name)
+	}
+
+	// the step is already completed
+	if state.Status != StatusRunning || state.CurrentStep != event.Step {
+		return nil
+	}
+
+	return m.compensate(ctx, def, state, fmt.Errorf("step %s timeout", def.Steps[event.Step].Name), true)
+}
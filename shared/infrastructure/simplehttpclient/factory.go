@@ -0,0 +1,88 @@
+package simplehttpclient
+
+import (
+	"infrastructure/shared/infrastructure/config"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NewHTTPClient create the http client with the timeout, the connection pool and the tls in the config
+func NewHTTPClient(cfg config.HTTPClient) (*http.Client, error) {
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if cfg.MaxIdleConns > 0 {
+		transport.MaxIdleConns = cfg.MaxIdleConns
+		transport.MaxIdleConnsPerHost = cfg.MaxIdleConns
+	}
+
+	if cfg.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = cfg.IdleConnTimeout.Duration()
+	}
+
+	tlsConfig, err := cfg.TLS.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   cfg.Timeout.Duration(),
+	}, nil
+}
+
+// HTTPClientFactory create the SimpleHTTPClient with the base url, the headers and the timeout in the config.
+// All the SimpleHTTPClient share one http client
+//
+//	factory, err := simplehttpclient.NewHTTPClientFactory(cfg.HTTPClient)
+//	err = factory.New(http.MethodGet, "/users/1").Call(&responseData)
+type HTTPClientFactory struct {
+	client  *http.Client
+	baseURL string
+	headers map[string]string
+	timeout time.Duration
+}
+
+func NewHTTPClientFactory(cfg config.HTTPClient) (*HTTPClientFactory, error) {
+
+	client, err := NewHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := time.Second * 3
+	if cfg.Timeout > 0 {
+		timeout = cfg.Timeout.Duration()
+	}
+
+	return &HTTPClientFactory{
+		client:  client,
+		baseURL: strings.TrimSuffix(cfg.BaseURL, "/"),
+		headers: cfg.Headers,
+		timeout: timeout,
+	}, nil
+}
+
+// New is NewSimpleHTTPClient with the path joined to the base url. The full url is used as is
+func (f *HTTPClientFactory) New(method, path string, requestData ...any) *SimpleHTTPClient {
+
+	url := path
+	if f.baseURL != "" && !strings.Contains(path, "://") {
+		url = f.baseURL + "/" + strings.TrimPrefix(path, "/")
+	}
+
+	r := NewSimpleHTTPClient(method, url, requestData...)
+	r.client = f.client
+	r.timeout = f.timeout
+
+	for k, v := range f.headers {
+		r.headers[k] = v
+	}
+
+	return r
+}
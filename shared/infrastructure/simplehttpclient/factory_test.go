@@ -0,0 +1,112 @@
+package simplehttpclient
+
+import (
+	"encoding/pem"
+	"infrastructure/shared/infrastructure/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHTTPClientFactory(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/users/1" || r.Header.Get("X-Api-Key") != "k1" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"Data": "bad request"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"Data": "U1"}`))
+	}))
+	defer server.Close()
+
+	factory, err := NewHTTPClientFactory(config.HTTPClient{
+		BaseURL: server.URL + "/api/",
+		Headers: map[string]string{"X-Api-Key": "k1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the path is joined to the base url with or without the leading slash
+	for _, path := range []string{"/users/1", "users/1", server.URL + "/api/users/1"} {
+		var response ResponseData
+		if err := factory.New(http.MethodGet, path).Call(&response); err != nil || response.Data != "U1" {
+			t.Fatalf("call of %s return %v %v", path, response.Data, err)
+		}
+	}
+
+	if factory.timeout != 3*time.Second {
+		t.Fatalf("default timeout is %s", factory.timeout)
+	}
+}
+
+func TestHTTPClientFactoryTimeout(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	factory, err := NewHTTPClientFactory(config.HTTPClient{BaseURL: server.URL, Timeout: config.Duration(20 * time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var response ResponseData
+	if err := factory.New(http.MethodGet, "/slow").Call(&response); err == nil {
+		t.Fatal("slow call does not time out")
+	}
+}
+
+func TestNewHTTPClientWithTLS(t *testing.T) {
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, certificate, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewHTTPClient(config.HTTPClient{
+		MaxIdleConns:    3,
+		IdleConnTimeout: config.Duration(time.Minute),
+		TLS:             config.TLS{Enabled: true, CAFile: caFile},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := client.Transport.(*http.Transport)
+	if transport.MaxIdleConnsPerHost != 3 || transport.IdleConnTimeout != time.Minute {
+		t.Fatalf("transport has %d idle conns and %s idle timeout", transport.MaxIdleConnsPerHost, transport.IdleConnTimeout)
+	}
+
+	response, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+
+	// the server is not trusted without the ca
+	untrusted, err := NewHTTPClient(config.HTTPClient{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := untrusted.Get(server.URL); err == nil {
+		t.Fatal("server of the unknown ca is trusted")
+	}
+
+	if _, err := NewHTTPClient(config.HTTPClient{TLS: config.TLS{Enabled: true, CAFile: filepath.Join(t.TempDir(), "missing.pem")}}); err == nil {
+		t.Fatal("missing ca file is accepted")
+	}
+}
@@ -16,6 +16,9 @@ type SimpleHTTPClient struct {
 	errs    []error
 	headers map[string]string
 	timeout time.Duration
+
+	// client (optional) is shared by the SimpleHTTPClient created from the same HTTPClientFactory to reuse the connection
+	client *http.Client
 }
 
 // NewSimpleHTTPClient is the fastest way to use the http client for calling any API by default it already have header
@@ -134,6 +137,11 @@ func (r *SimpleHTTPClient) Call(responseData *ResponseData) error {
 	}
 
 	var client = &http.Client{Timeout: r.timeout}
+	if r.client != nil {
+		sharedClient := *r.client
+		sharedClient.Timeout = r.timeout
+		client = &sharedClient
+	}
 
 	response, err := client.Do(request)
 	if err != nil {
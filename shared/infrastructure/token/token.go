@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"github.com/golang-jwt/jwt"
+	"infrastructure/shared/infrastructure/config"
 	"strings"
 	"time"
 )
@@ -18,8 +19,17 @@ type JWTToken interface {
 
 const fieldContent = "content"
 
+// defaultExpiration is used when the expiration is not set in the config and CreateToken is called with 0 expired
+const defaultExpiration = time.Hour
+
 type jwtToken struct {
 	secretKey string
+
+	// expiration is used when CreateToken is called with 0 expired
+	expiration time.Duration
+
+	// issuer is written into the iss claim and checked on verify when it is not empty
+	issuer string
 }
 
 func NewJWTToken(secretKey string) (JWTToken, error) {
@@ -28,7 +38,31 @@ func NewJWTToken(secretKey string) (JWTToken, error) {
 	}
 
 	return &jwtToken{
-		secretKey: secretKey,
+		secretKey:  secretKey,
+		expiration: defaultExpiration,
+	}, nil
+}
+
+// NewJWTTokenFromConfig create the token with the secret, the default expiration and the issuer in the config.
+// The empty expiration is one hour
+func NewJWTTokenFromConfig(cfg config.Token) (JWTToken, error) {
+	if strings.TrimSpace(cfg.Secret.Value()) == "" {
+		return nil, fmt.Errorf("SecretKey must not empty")
+	}
+
+	if cfg.Expiration < 0 {
+		return nil, fmt.Errorf("token expiration must not negative")
+	}
+
+	expiration := defaultExpiration
+	if cfg.Expiration > 0 {
+		expiration = cfg.Expiration.Duration()
+	}
+
+	return &jwtToken{
+		secretKey:  cfg.Secret.Value(),
+		expiration: expiration,
+		issuer:     cfg.Issuer,
 	}, nil
 }
 
@@ -36,12 +70,26 @@ func (j jwtToken) CreateToken(content []byte, expired time.Duration) (string, er
 
 	contentBase64 := base64.StdEncoding.EncodeToString(content)
 
-	// Create a new token object, specifying signing method and the claims
-	// you would like it to contain.
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	if expired == 0 {
+		expired = j.expiration
+	}
+
+	if expired <= 0 {
+		return "", fmt.Errorf("token expired must be positive")
+	}
+
+	claims := jwt.MapClaims{
 		"exp":        time.Now().Add(expired).Unix(),
 		fieldContent: contentBase64,
-	})
+	}
+
+	if j.issuer != "" {
+		claims["iss"] = j.issuer
+	}
+
+	// Create a new token object, specifying signing method and the claims
+	// you would like it to contain.
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	// Sign and get the complete encoded token as a string using the secret
 	tokenString, err := token.SignedString([]byte(j.secretKey))
@@ -77,6 +125,10 @@ func (j jwtToken) VerifyToken(tokenString string) ([]byte, error) {
 		return nil, fmt.Errorf("Claims is can not asserted")
 	}
 
+	if j.issuer != "" && !claims.VerifyIssuer(j.issuer, true) {
+		return nil, fmt.Errorf("Token issuer is not valid")
+	}
+
 	decodeStringInBytes, err := base64.StdEncoding.DecodeString(claims[fieldContent].(string))
 	if err != nil {
 		return nil, err
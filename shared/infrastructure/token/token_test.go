@@ -0,0 +1,92 @@
+package token
+
+import (
+	"infrastructure/shared/infrastructure/config"
+	"testing"
+	"time"
+)
+
+func TestNewJWTTokenFromConfig(t *testing.T) {
+
+	cfg := config.Token{Secret: config.NewSecret("s3cret"), Issuer: "order"}
+
+	// the empty expiration is the default instead of the token which expire right away
+	jwtToken, err := NewJWTTokenFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := jwtToken.CreateToken([]byte("user-1"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := jwtToken.VerifyToken(token)
+	if err != nil || string(content) != "user-1" {
+		t.Fatalf("verify return %q %v", content, err)
+	}
+
+	// the token of the other issuer is rejected
+	other, err := NewJWTTokenFromConfig(config.Token{Secret: config.NewSecret("s3cret"), Issuer: "payment"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := other.VerifyToken(token); err == nil {
+		t.Fatal("token of the other issuer is accepted")
+	}
+}
+
+func TestNewJWTTokenFromConfigError(t *testing.T) {
+
+	testCases := []struct {
+		name string
+		cfg  config.Token
+	}{
+		{name: "empty secret", cfg: config.Token{}},
+		{name: "blank secret", cfg: config.Token{Secret: config.NewSecret("  ")}},
+		{name: "negative expiration", cfg: config.Token{Secret: config.NewSecret("s3cret"), Expiration: config.Duration(-time.Second)}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if _, err := NewJWTTokenFromConfig(testCase.cfg); err == nil {
+				t.Fatal("invalid config is accepted")
+			}
+		})
+	}
+}
+
+func TestCreateTokenExpiration(t *testing.T) {
+
+	creator, err := NewJWTTokenFromConfig(config.Token{Secret: config.NewSecret("s3cret"), Expiration: config.Duration(time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the token which is already expired is never valid
+	if _, err := creator.CreateToken([]byte("user-1"), -time.Second); err == nil {
+		t.Fatal("token with the negative expired is created")
+	}
+
+	withoutExpiration := creator.(*jwtToken)
+	withoutExpiration.expiration = 0
+	if _, err := withoutExpiration.CreateToken([]byte("user-1"), 0); err == nil {
+		t.Fatal("token without the expiration is created")
+	}
+
+	// NewJWTToken has the default expiration too
+	plain, err := NewJWTToken("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := plain.CreateToken([]byte("user-1"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := plain.VerifyToken(token); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,11 +1,78 @@
 package payload
 
 import (
+	"encoding/json"
 	"infrastructure/shared/driver"
+	"reflect"
 )
 
 type Payload struct {
+
+	// ID identify the message, the redelivery of the message has the same ID
+	ID        string                 `json:"id,omitempty"`
 	Data      interface{}            `json:"data"`
+	EventType string                 `json:"eventType,omitempty"`
 	Publisher driver.ApplicationData `json:"publisher"`
 	TraceID   string                 `json:"traceId"`
+	SpanID    string                 `json:"spanId,omitempty"`
+
+	// rawData is the original Data before it is decoded into interface{}, it is not used after the Data is changed
+	rawData json.RawMessage
+}
+
+// UnmarshalJSON keep the original data so it can be decoded later into the concrete type by DecodeData
+func (p *Payload) UnmarshalJSON(bytes []byte) error {
+
+	type payloadAlias Payload
+
+	var obj struct {
+		payloadAlias
+		Data json.RawMessage `json:"data"`
+	}
+
+	err := json.Unmarshal(bytes, &obj)
+	if err != nil {
+		return err
+	}
+
+	*p = Payload(obj.payloadAlias)
+	p.rawData = obj.Data
+
+	if len(obj.Data) > 0 {
+		err = json.Unmarshal(obj.Data, &p.Data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DecodeData decode the Data into obj. obj must be a pointer.
+// The original data is decoded so the big number keep its precision, unless the Data is changed after it is received
+//
+//	var event OrderCreated
+//	err := payload.DecodeData(&event)
+func (p Payload) DecodeData(obj any) error {
+
+	if len(p.rawData) > 0 && p.isRawData() {
+		return json.Unmarshal(p.rawData, obj)
+	}
+
+	bytes, err := json.Marshal(p.Data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(bytes, obj)
+}
+
+// isRawData decode the original data again and compare it with the Data,
+// the Data may be replaced or its map may be changed in place after it is received
+func (p Payload) isRawData() bool {
+	var original any
+	if err := json.Unmarshal(p.rawData, &original); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(original, p.Data)
 }
@@ -0,0 +1,72 @@
+package payload
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type order struct {
+	ID     string `json:"id"`
+	Amount int64  `json:"amount"`
+}
+
+func receive(t *testing.T, body string) Payload {
+	var p Payload
+	if err := json.Unmarshal([]byte(body), &p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestDecodeDataKeepTheBigNumber(t *testing.T) {
+
+	p := receive(t, `{"data": {"id": "O1", "amount": 9007199254740993}, "traceId": "T1"}`)
+
+	var o order
+	if err := p.DecodeData(&o); err != nil {
+		t.Fatal(err)
+	}
+
+	// the float64 of the generic Data can not hold this number
+	if o.ID != "O1" || o.Amount != 9007199254740993 || p.TraceID != "T1" {
+		t.Fatalf("order is %+v", o)
+	}
+}
+
+func TestDecodeDataUseTheChangedData(t *testing.T) {
+
+	testCases := []struct {
+		name   string
+		change func(p *Payload)
+	}{
+		{name: "replaced", change: func(p *Payload) { p.Data = order{ID: "O2"} }},
+		{name: "changed in place", change: func(p *Payload) { p.Data.(map[string]any)["id"] = "O2" }},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+
+			p := receive(t, `{"data": {"id": "O1"}}`)
+			testCase.change(&p)
+
+			var o order
+			if err := p.DecodeData(&o); err != nil {
+				t.Fatal(err)
+			}
+
+			if o.ID != "O2" {
+				t.Fatalf("stale data %+v is decoded", o)
+			}
+		})
+	}
+}
+
+func TestDecodeDataWithoutTheOriginal(t *testing.T) {
+
+	p := Payload{Data: map[string]any{"id": "O1", "amount": 10}}
+
+	var o order
+	if err := p.DecodeData(&o); err != nil || o.ID != "O1" || o.Amount != 10 {
+		t.Fatalf("order is %+v %v", o, err)
+	}
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"infrastructure/shared/model/repository"
+	"sync"
 )
 
 // WithoutTransaction is helper function that simplify the readonly db
@@ -22,9 +23,13 @@ func WithoutTransaction[T any](ctx context.Context, trx repository.WithoutTransa
 	return trxFunc(dbCtx)
 }
 
-// WithTransaction is helper function that simplify the transaction execution handling
+// WithTransaction is helper function that simplify the transaction execution handling.
+// The function registered with AfterCommit is called only after the commit is succeed
 func WithTransaction[T any](ctx context.Context, trx repository.WithTransactionDB, trxFunc func(dbCtx context.Context) (*T, error)) (*T, error) {
-	dbCtx, err := trx.BeginTransaction(ctx)
+
+	hooks := &commitHooks{}
+
+	dbCtx, err := trx.BeginTransaction(context.WithValue(ctx, commitHooksKey{}, hooks))
 	if err != nil {
 		return nil, err
 	}
@@ -39,6 +44,9 @@ func WithTransaction[T any](ctx context.Context, trx repository.WithTransactionD
 
 		} else {
 			err = trx.CommitTransaction(dbCtx)
+			if err == nil {
+				hooks.run()
+			}
 
 		}
 	}()
@@ -51,3 +59,44 @@ func WithTransaction[T any](ctx context.Context, trx repository.WithTransactionD
 
 	return t, err
 }
+
+type commitHooksKey struct{}
+
+type commitHooks struct {
+	mutex sync.Mutex
+	funcs []func()
+}
+
+func (h *commitHooks) add(fn func()) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.funcs = append(h.funcs, fn)
+}
+
+func (h *commitHooks) run() {
+	h.mutex.Lock()
+	funcs := h.funcs
+	h.funcs = nil
+	h.mutex.Unlock()
+
+	for _, fn := range funcs {
+		fn()
+	}
+}
+
+// InTransaction return true if the ctx is the dbCtx given by WithTransaction
+func InTransaction(ctx context.Context) bool {
+	_, ok := ctx.Value(commitHooksKey{}).(*commitHooks)
+	return ok
+}
+
+// AfterCommit call the fn after the transaction is committed, the fn is dropped if the transaction is rolled back.
+// Outside WithTransaction the fn is called immediately
+func AfterCommit(ctx context.Context, fn func()) {
+	hooks, ok := ctx.Value(commitHooksKey{}).(*commitHooks)
+	if !ok {
+		fn()
+		return
+	}
+	hooks.add(fn)
+}